@@ -0,0 +1,83 @@
+package promsentry
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/telemetry"
+)
+
+// AdminServerOptions configures the server created by NewAdminServer. Every field is optional.
+type AdminServerOptions struct {
+	// ListenAddress is the address the admin server listens on. Defaults to 127.0.0.1:3001.
+	ListenAddress string
+	// Health backs the /api/v1/status endpoint and the /status page. Defaults to a Health that checks the transport
+	// of the current hub.
+	Health *Health
+	// Recorder, when set, is shown on the /status page.
+	Recorder *pipeline.Recorder
+	// DisableStatusPage removes the /status page.
+	DisableStatusPage bool
+	// Reload is called by the /-/reload endpoint. The endpoint is not registered when Reload is nil.
+	Reload func() error
+	// Logger receives the logs of the admin server. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// NewAdminServer creates the HTTP server of the operational endpoints: /metrics, /api/v1/status, /status and
+// /-/reload. They are not authenticated, the admin server should listen on an address that only operators reach.
+func NewAdminServer(options AdminServerOptions) (*http.Server, error) {
+	listenAddress := options.ListenAddress
+	if listenAddress == "" {
+		listenAddress = "127.0.0.1:3001"
+	}
+
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("component", "admin")
+
+	health := options.Health
+	if health == nil {
+		health = NewHealth(HealthOptions{})
+	}
+
+	router := http.NewServeMux()
+	router.Handle("/metrics", telemetry.Handler())
+	health.registerStatus(router)
+	if !options.DisableStatusPage {
+		router.Handle("/status", statusPageHandler(health, options.Recorder, logger))
+	}
+	if options.Reload != nil {
+		router.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost && r.Method != http.MethodPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte("Only POST or PUT requests allowed"))
+				return
+			}
+
+			if err := options.Reload(); err != nil {
+				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	server := &http.Server{
+		Addr:              listenAddress,
+		Handler:           router,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ReadTimeout:       defaultReadTimeout,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       time.Minute,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	return server, nil
+}
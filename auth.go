@@ -0,0 +1,319 @@
+package promsentry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aldy505/promsentry/telemetry"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identity is the authenticated client of a remote write request.
+type Identity struct {
+	// Name identifies the client: the identity of the bearer token, the username, or the name taken from the client
+	// certificate.
+	Name string
+	// Method is the authentication method: bearer_token, basic_auth or client_certificate.
+	Method string
+}
+
+type identityContextKey struct{}
+
+// IdentityFromContext returns the identity of the client, when the request was authenticated.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(Identity)
+	return identity, ok
+}
+
+// ContextWithIdentity returns a copy of the context that holds the identity of the client.
+func ContextWithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// Authenticator authenticates remote write requests with bearer tokens, basic authentication or the client
+// certificate of mutual TLS. Its credentials can be replaced while the server is running.
+type Authenticator struct {
+	current atomic.Pointer[credentials]
+}
+
+// credentials are the clients accepted by an Authenticator.
+type credentials struct {
+	// tokens maps the SHA-256 of each token to its identity, so that tokens are compared in constant time.
+	tokens map[[sha256.Size]byte]string
+	// users maps usernames to bcrypt hashes.
+	users map[string][]byte
+
+	clientCertificate bool
+	identityFrom      string
+	allowed           map[string]bool
+
+	// verified caches the SHA-256 of the username and password pairs that matched their bcrypt hash, since bcrypt
+	// is deliberately slow.
+	verified sync.Map
+}
+
+// NewAuthenticator creates an Authenticator from the auth section of the configuration.
+func NewAuthenticator(configuration *Configuration) (*Authenticator, error) {
+	c, err := newCredentials(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticator := &Authenticator{}
+	authenticator.current.Store(c)
+	return authenticator, nil
+}
+
+func newCredentials(configuration *Configuration) (*credentials, error) {
+	auth := configuration.Auth
+	c := &credentials{
+		tokens:            make(map[[sha256.Size]byte]string),
+		users:             make(map[string][]byte),
+		clientCertificate: auth.ClientCertificate.Enabled,
+		identityFrom:      auth.ClientCertificate.IdentityFrom,
+	}
+
+	for i, bearerToken := range auth.BearerTokens {
+		token := bearerToken.Token
+		if bearerToken.TokenFile != "" {
+			content, err := os.ReadFile(bearerToken.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading bearer token #%d: %w", i+1, err)
+			}
+			token = strings.TrimRight(string(content), "\r\n")
+		}
+		if token == "" {
+			return nil, fmt.Errorf("bearer token #%d is empty", i+1)
+		}
+
+		identity := bearerToken.Identity
+		if identity == "" {
+			identity = fmt.Sprintf("token-%d", i+1)
+		}
+		c.tokens[sha256.Sum256([]byte(token))] = identity
+	}
+
+	for _, user := range auth.BasicAuth.Users {
+		if err := c.addUser(user.Username, user.PasswordHash); err != nil {
+			return nil, err
+		}
+	}
+
+	if auth.BasicAuth.UsersFile != "" {
+		if err := c.readUsersFile(auth.BasicAuth.UsersFile); err != nil {
+			return nil, err
+		}
+	}
+
+	switch c.identityFrom {
+	case "":
+		c.identityFrom = "common_name"
+	case "common_name", "dns_name", "email_address", "uri":
+	default:
+		return nil, fmt.Errorf("unknown client certificate identity_from %q, use common_name, dns_name, email_address or uri", c.identityFrom)
+	}
+
+	if c.clientCertificate {
+		if configuration.TLS.ServerCertificatePath == "" {
+			return nil, fmt.Errorf("client certificate authentication requires TLS")
+		}
+
+		// Only verified certificates are trusted, the server must verify them against a CA.
+		switch configuration.TLS.ClientAuthenticationType {
+		case "VerifyClientCertIfGiven", "RequireAndVerifyClientCert":
+		default:
+			return nil, fmt.Errorf("client certificate authentication requires tls.client_authentication_type VerifyClientCertIfGiven or RequireAndVerifyClientCert, got %q", configuration.TLS.ClientAuthenticationType)
+		}
+		if configuration.TLS.CertificateAuthorityPath == "" {
+			return nil, fmt.Errorf("client certificate authentication requires tls.certificate_authority_path")
+		}
+
+		if len(auth.ClientCertificate.Allowed) > 0 {
+			c.allowed = make(map[string]bool)
+			for _, identity := range auth.ClientCertificate.Allowed {
+				c.allowed[identity] = true
+			}
+		}
+	}
+
+	return c, nil
+}
+
+func (c *credentials) addUser(username string, passwordHash string) error {
+	if username == "" {
+		return fmt.Errorf("basic auth username is empty")
+	}
+
+	if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+		return fmt.Errorf("password hash of %s is not a bcrypt hash: %w", username, err)
+	}
+
+	c.users[username] = []byte(passwordHash)
+	return nil
+}
+
+// readUsersFile reads a htpasswd file, with one username:hash pair per line. Only bcrypt hashes are supported.
+func (c *credentials) readUsersFile(filePath string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("reading basic auth users file: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		username, passwordHash, ok := strings.Cut(text, ":")
+		if !ok {
+			return fmt.Errorf("basic auth users file, line %d: expected username:hash", line)
+		}
+		if err := c.addUser(username, passwordHash); err != nil {
+			return fmt.Errorf("basic auth users file, line %d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (c *credentials) enabled() bool {
+	return len(c.tokens) > 0 || len(c.users) > 0 || c.clientCertificate
+}
+
+// Reload reads the credentials of the new configuration, which replace the current ones once it is applied.
+// Authentication can't be enabled or disabled without restarting the server.
+func (a *Authenticator) Reload(_ *Configuration, configuration *Configuration) (ReloadCommit, error) {
+	c, err := newCredentials(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.enabled() != a.current.Load().enabled() {
+		return nil, fmt.Errorf("enabling or disabling authentication requires a restart")
+	}
+
+	return func(apply bool) {
+		if !apply {
+			return
+		}
+		a.current.Store(c)
+	}, nil
+}
+
+// Enabled reports whether any authentication method is configured.
+func (a *Authenticator) Enabled() bool {
+	return a.current.Load().enabled()
+}
+
+// Middleware rejects the requests that are not authenticated with 401, and the ones whose client certificate is
+// not allowed with 403. The identity of authenticated requests is added to their context. Requests pass through
+// untouched when no authentication method is configured.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := a.current.Load()
+		if !c.enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, status, reason := c.authenticate(r)
+		if status != http.StatusOK {
+			telemetry.AuthFailures.WithLabelValues(reason).Inc()
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Basic realm="promsentry", charset="UTF-8"`)
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithIdentity(r.Context(), identity)))
+	})
+}
+
+// authenticate returns the identity of the client, or the status code and the reason of the failure. The
+// Authorization header is checked first, then the client certificate.
+func (c *credentials) authenticate(r *http.Request) (Identity, int, string) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		if token, ok := strings.CutPrefix(authorization, "Bearer "); ok && len(c.tokens) > 0 {
+			if identity, ok := c.tokens[sha256.Sum256([]byte(token))]; ok {
+				return Identity{Name: identity, Method: "bearer_token"}, http.StatusOK, ""
+			}
+			return Identity{}, http.StatusUnauthorized, "invalid_credentials"
+		}
+
+		if username, password, ok := r.BasicAuth(); ok && len(c.users) > 0 {
+			if c.verify(username, password) {
+				return Identity{Name: username, Method: "basic_auth"}, http.StatusOK, ""
+			}
+			return Identity{}, http.StatusUnauthorized, "invalid_credentials"
+		}
+	}
+
+	if c.clientCertificate && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		name := c.certificateIdentity(r.TLS.VerifiedChains[0][0])
+		if name == "" || (c.allowed != nil && !c.allowed[name]) {
+			return Identity{}, http.StatusForbidden, "forbidden"
+		}
+		return Identity{Name: name, Method: "client_certificate"}, http.StatusOK, ""
+	}
+
+	if r.Header.Get("Authorization") != "" {
+		return Identity{}, http.StatusUnauthorized, "invalid_credentials"
+	}
+	return Identity{}, http.StatusUnauthorized, "missing_credentials"
+}
+
+// verify compares the password with the bcrypt hash of the user.
+func (c *credentials) verify(username string, password string) bool {
+	hash, ok := c.users[username]
+	if !ok {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(username + ":" + password))
+	if cached, ok := c.verified.Load(username); ok && subtle.ConstantTimeCompare(cached.([]byte), key[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	c.verified.Store(username, key[:])
+	return true
+}
+
+// certificateIdentity returns the name of the client certificate, according to identity_from.
+func (c *credentials) certificateIdentity(certificate *x509.Certificate) string {
+	switch c.identityFrom {
+	case "dns_name":
+		if len(certificate.DNSNames) > 0 {
+			return certificate.DNSNames[0]
+		}
+	case "email_address":
+		if len(certificate.EmailAddresses) > 0 {
+			return certificate.EmailAddresses[0]
+		}
+	case "uri":
+		if len(certificate.URIs) > 0 {
+			return certificate.URIs[0].String()
+		}
+	default:
+		return certificate.Subject.CommonName
+	}
+	return ""
+}
@@ -0,0 +1,234 @@
+package promsentry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func authTestHandler(t *testing.T, configuration *Configuration) http.Handler {
+	t.Helper()
+
+	authenticator, err := NewAuthenticator(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok {
+			t.Error("expected an identity in the context")
+		}
+		w.Write([]byte(identity.Method + ":" + identity.Name))
+	}))
+}
+
+func TestAuthenticatorBearerTokens(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	configuration := &Configuration{}
+	configuration.Auth.BearerTokens = []BearerToken{
+		{Identity: "prometheus-a", Token: "secret"},
+		{Identity: "prometheus-b", TokenFile: tokenFile},
+	}
+	handler := authTestHandler(t, configuration)
+
+	tests := []struct {
+		authorization string
+		code          int
+		body          string
+	}{
+		{"Bearer secret", http.StatusOK, "bearer_token:prometheus-a"},
+		{"Bearer from-file", http.StatusOK, "bearer_token:prometheus-b"},
+		{"Bearer wrong", http.StatusUnauthorized, ""},
+		{"", http.StatusUnauthorized, ""},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if response.Code != test.code {
+			t.Errorf("%q: expected status %d, got %d", test.authorization, test.code, response.Code)
+		}
+		if test.code == http.StatusOK && response.Body.String() != test.body {
+			t.Errorf("%q: unexpected identity %q", test.authorization, response.Body.String())
+		}
+	}
+}
+
+func TestAuthenticatorBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersFile := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(usersFile, []byte("# comment\nbob:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	configuration := &Configuration{}
+	configuration.Auth.BasicAuth.Users = []BasicAuthUser{{Username: "alice", PasswordHash: string(hash)}}
+	configuration.Auth.BasicAuth.UsersFile = usersFile
+	handler := authTestHandler(t, configuration)
+
+	tests := []struct {
+		username string
+		password string
+		code     int
+	}{
+		{"alice", "password", http.StatusOK},
+		// The second request goes through the cache.
+		{"alice", "password", http.StatusOK},
+		{"bob", "password", http.StatusOK},
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"carol", "password", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+		request.SetBasicAuth(test.username, test.password)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if response.Code != test.code {
+			t.Errorf("%s:%s: expected status %d, got %d", test.username, test.password, test.code, response.Code)
+		}
+		if test.code == http.StatusOK && response.Body.String() != "basic_auth:"+test.username {
+			t.Errorf("%s: unexpected identity %q", test.username, response.Body.String())
+		}
+	}
+}
+
+func TestAuthenticatorClientCertificate(t *testing.T) {
+	configuration := &Configuration{}
+	configuration.TLS.ServerCertificatePath = "cert.pem"
+	configuration.TLS.CertificateAuthorityPath = "ca.pem"
+	configuration.TLS.ClientAuthenticationType = "RequireAndVerifyClientCert"
+	configuration.Auth.ClientCertificate.Enabled = true
+	configuration.Auth.ClientCertificate.Allowed = []string{"prometheus"}
+	handler := authTestHandler(t, configuration)
+
+	tests := []struct {
+		commonName string
+		code       int
+	}{
+		{"prometheus", http.StatusOK},
+		{"someone-else", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+		request.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: test.commonName}}}},
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if response.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.commonName, test.code, response.Code)
+		}
+		if test.code == http.StatusOK && response.Body.String() != "client_certificate:"+test.commonName {
+			t.Errorf("%s: unexpected identity %q", test.commonName, response.Body.String())
+		}
+	}
+
+	// A certificate that wasn't verified isn't trusted.
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+	request.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "prometheus"}}},
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for an unverified certificate, got %d", response.Code)
+	}
+}
+
+func TestAuthenticatorClientCertificateRequiresVerification(t *testing.T) {
+	tests := []struct {
+		clientAuthenticationType string
+		certificateAuthorityPath string
+		want                     string
+	}{
+		{"", "ca.pem", "client_authentication_type"},
+		{"RequireAnyClientCert", "ca.pem", "client_authentication_type"},
+		{"VerifyClientCertIfGiven", "", "certificate_authority_path"},
+	}
+
+	for _, test := range tests {
+		configuration := &Configuration{}
+		configuration.TLS.ServerCertificatePath = "cert.pem"
+		configuration.TLS.ClientAuthenticationType = test.clientAuthenticationType
+		configuration.TLS.CertificateAuthorityPath = test.certificateAuthorityPath
+		configuration.Auth.ClientCertificate.Enabled = true
+
+		if _, err := NewAuthenticator(configuration); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: expected an error about %s, got %v", test.clientAuthenticationType, test.want, err)
+		}
+	}
+}
+
+func TestAuthenticatorDisabled(t *testing.T) {
+	authenticator, err := NewAuthenticator(&Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/write", nil))
+	if !called {
+		t.Error("expected the request to pass through")
+	}
+}
+
+func TestAuthenticatorReload(t *testing.T) {
+	configuration := &Configuration{}
+	configuration.Auth.BearerTokens = []BearerToken{{Identity: "prometheus", Token: "old"}}
+	authenticator, err := NewAuthenticator(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := &Configuration{}
+	reloaded.Auth.BearerTokens = []BearerToken{{Identity: "prometheus", Token: "new"}}
+	commit, err := authenticator.Reload(configuration, reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(true)
+
+	handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for token, code := range map[string]int{"old": http.StatusUnauthorized, "new": http.StatusOK} {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != code {
+			t.Errorf("%s: expected status %d, got %d", token, code, response.Code)
+		}
+	}
+
+	if _, err := authenticator.Reload(reloaded, &Configuration{}); err == nil {
+		t.Error("expected disabling authentication to be rejected")
+	}
+}
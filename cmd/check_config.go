@@ -0,0 +1,48 @@
+//	Copyright 2023 Reinaldy Rafli <aldy505@proton.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/aldy505/promsentry"
+)
+
+// runCheckConfig parses and validates the configuration, including the TLS
+// files and the DSN, and returns the first problem found.
+func runCheckConfig(args []string) error {
+	configurationFilePath := parseFlags(flag.NewFlagSet("check-config", flag.ExitOnError), args)
+
+	configuration, err := promsentry.ParseConfiguration(configurationFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := configuration.Validate(); err != nil {
+		return err
+	}
+
+	if configuration.SentryDsn == "" {
+		fmt.Println("Warning: sentry_dsn is empty, metrics will not be sent to Sentry")
+	}
+
+	if configurationFilePath == "" {
+		fmt.Println("Configuration from the environment is valid")
+	} else {
+		fmt.Printf("Configuration %s is valid\n", configurationFilePath)
+	}
+	return nil
+}
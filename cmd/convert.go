@@ -0,0 +1,172 @@
+//	Copyright 2023 Reinaldy Rafli <aldy505@proton.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aldy505/promsentry"
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/sentry"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// placeholderDsn is used to build envelopes when no DSN is configured.
+const placeholderDsn = "https://public@example.com/1"
+
+// runConvert reads metrics from a file, or stdin, and prints what promsentry
+// would send to Sentry for them.
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	format := flags.String("format", "auto", "Format of the input: auto, text, openmetrics or remote-write")
+	output := flags.String("output", "statsd", "What to print: statsd for the statsd lines, envelope for the envelopes sent to Sentry")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: promsentry convert [flags] [file]\n\nReads stdin when no file is given.\n\n")
+		flags.PrintDefaults()
+	}
+	configurationFilePath := parseFlags(flags, args)
+
+	configuration, err := promsentry.ParseConfiguration(configurationFilePath)
+	if err != nil {
+		return err
+	}
+
+	input, err := readInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	timeseries, err := parseInput(input, *format)
+	if err != nil {
+		return err
+	}
+
+	// The tags of the configuration apply, except for the routes that match on an identity.
+	metric := pipeline.NewConverter(nil, promsentry.NewConversionOptions(configuration)).Convert(timeseries)
+
+	switch *output {
+	case "statsd":
+		if len(metric) > 0 {
+			fmt.Printf("%s\n", metric)
+		}
+		return nil
+	case "envelope":
+		return printEnvelopes(configuration, metric)
+	default:
+		return fmt.Errorf("unknown output %q, use statsd or envelope", *output)
+	}
+}
+
+func readInput(filePath string) ([]byte, error) {
+	if filePath == "" || filePath == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(filePath)
+}
+
+// parseInput converts the input into time series. The auto format recognizes
+// snappy compressed remote write requests and OpenMetrics, and falls back to
+// the Prometheus text format.
+func parseInput(input []byte, format string) ([]prompb.TimeSeries, error) {
+	if format == "auto" {
+		format = detectFormat(input)
+	}
+
+	switch format {
+	case "remote-write":
+		request, err := remote.DecodeWriteRequest(bytes.NewReader(input))
+		if err != nil {
+			return nil, fmt.Errorf("decoding the remote write request: %w", err)
+		}
+		return request.GetTimeseries(), nil
+	case "openmetrics":
+		return pipeline.ParseText(input, pipeline.ContentTypeOpenMetrics, time.Now())
+	case "text":
+		return pipeline.ParseText(input, pipeline.ContentTypeText, time.Now())
+	default:
+		return nil, fmt.Errorf("unknown format %q, use auto, text, openmetrics or remote-write", format)
+	}
+}
+
+func detectFormat(input []byte) string {
+	if _, err := remote.DecodeWriteRequest(bytes.NewReader(input)); err == nil {
+		return "remote-write"
+	}
+
+	if bytes.HasSuffix(bytes.TrimSpace(input), []byte("# EOF")) {
+		return "openmetrics"
+	}
+
+	return "text"
+}
+
+// printEnvelopes captures the metric with a client configured like the server
+// one, and prints the envelopes instead of sending them.
+func printEnvelopes(configuration *promsentry.Configuration, metric []byte) error {
+	dsn := configuration.SentryDsn
+	if dsn == "" {
+		dsn = placeholderDsn
+	}
+
+	transport := sentry.NewHTTPSyncTransport()
+	transport.RetryPolicy = sentry.RetryPolicy{MaxAttempts: 1}
+
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:           dsn,
+		ServerName:    "promsentry",
+		Transport:     transport,
+		HTTPTransport: printingRoundTripper{w: os.Stdout},
+
+		MaxMetricItemBytes: configuration.Transport.MaxItemBytes,
+		MaxMetricItemLines: configuration.Transport.MaxItemLines,
+	})
+	if err != nil {
+		return err
+	}
+
+	client.CaptureMetric(metric)
+	return nil
+}
+
+// printingRoundTripper writes the body of the requests instead of sending
+// them, and answers as if Sentry accepted them.
+type printingRoundTripper struct {
+	w io.Writer
+}
+
+func (p printingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		_, err := io.Copy(p.w, request.Body)
+		_ = request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+		Request:    request,
+	}, nil
+}
@@ -15,85 +15,66 @@
 package main
 
 import (
-	"context"
-	"crypto/tls"
-	"errors"
 	"flag"
-	"log"
-	"net/http"
+	"fmt"
 	"os"
-	"os/signal"
-
-	"github.com/aldy505/promsentry"
-	"github.com/aldy505/promsentry/sentry"
+	"strings"
 )
 
-func main() {
-	var configurationFilePath string
-	flag.StringVar(&configurationFilePath, "config-file", "", "Path to configuration file (JSON, or YAML)")
-	flag.Parse()
-	if v, ok := os.LookupEnv("CONFIG_FILE_PATH"); ok {
-		configurationFilePath = v
-	}
+const usage = `Usage: promsentry [command] [flags]
 
-	configuration, err := promsentry.ParseConfiguration(configurationFilePath)
-	if err != nil {
-		log.Fatalln(err)
-		return
-	}
+Commands:
+  serve         Start the remote write server (default)
+  check-config  Check the configuration, the TLS files and the DSN, then exit
+  send-test     Send a test metric to the configured DSN and report the response
+  convert       Print the statsd lines or envelopes of Prometheus metrics read from a file or stdin
+  version       Print the version and build information
 
-	err = sentry.Init(sentry.ClientOptions{
-		Dsn:        configuration.SentryDsn,
-		Debug:      configuration.Debug,
-		SampleRate: 1.0,
-		ServerName: "promsentry",
-	})
-	if err != nil {
-		log.Fatalln(err)
-		return
+Run 'promsentry [command] -h' for the flags of a command.
+`
+
+func main() {
+	command, args := "serve", os.Args[1:]
+	// The flags of serve are accepted without the command, as before commands
+	// were introduced.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	var tlsConfig *tls.Config = nil
-	if configuration.TLS.ServerCertificatePath != "" {
-		tlsConfig, err = promsentry.CreateTLSConfiguration(
-			configuration.TLS.ServerCertificatePath,
-			configuration.TLS.ServerKeyPath,
-			configuration.TLS.CertificateAuthorityPath,
-			configuration.TLS.ClientAuthenticationType)
-		if err != nil {
-			log.Fatalln(err)
-			return
-		}
+	var err error
+	switch command {
+	case "serve":
+		err = runServe(args)
+	case "check-config":
+		err = runCheckConfig(args)
+	case "send-test":
+		err = runSendTest(args)
+	case "convert":
+		err = runConvert(args)
+	case "version":
+		err = runVersion(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
 	}
 
-	server, err := promsentry.NewServer(configuration.ListenAddress, tlsConfig)
 	if err != nil {
-		log.Fatalln(err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+}
 
-	go func() {
-		if tlsConfig == nil {
-			log.Printf("Server starting on http://%s\n", server.Addr)
-			err := server.ListenAndServe()
-			if err != nil && !errors.Is(err, http.ErrServerClosed) {
-				log.Println(err)
-			}
-		} else {
-			log.Printf("Server starting on https://%s\n", server.Addr)
-			err := server.ListenAndServeTLS("", "")
-			if err != nil && !errors.Is(err, http.ErrServerClosed) {
-				log.Println(err)
-			}
-		}
-	}()
-
-	exitSignal := make(chan os.Signal, 1)
-	signal.Notify(exitSignal, os.Interrupt)
+// parseFlags registers the flag of the configuration file path, parses the
+// arguments and returns the path of the configuration file. The
+// CONFIG_FILE_PATH environment variable takes precedence over the flag.
+func parseFlags(flags *flag.FlagSet, args []string) string {
+	configurationFilePath := flags.String("config-file", "", "Path to configuration file (JSON, or YAML)")
+	_ = flags.Parse(args)
 
-	<-exitSignal
-	err = server.Shutdown(context.Background())
-	if err != nil {
-		log.Println(err)
+	if v, ok := os.LookupEnv("CONFIG_FILE_PATH"); ok {
+		return v
 	}
+	return *configurationFilePath
 }
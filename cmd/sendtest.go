@@ -0,0 +1,210 @@
+//	Copyright 2023 Reinaldy Rafli <aldy505@proton.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldy505/promsentry"
+	"github.com/aldy505/promsentry/sentry"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// runSendTest converts a synthetic remote write request with the same handler
+// as the server, sends the result to the configured DSN and reports the
+// response of Sentry.
+func runSendTest(args []string) error {
+	flags := flag.NewFlagSet("send-test", flag.ExitOnError)
+	metricName := flags.String("metric", "promsentry_test", "Name of the test metric")
+	timeout := flags.Duration("timeout", time.Second*30, "Timeout of the request to Sentry")
+	configurationFilePath := parseFlags(flags, args)
+
+	configuration, err := promsentry.ParseConfiguration(configurationFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := configuration.Validate(); err != nil {
+		return err
+	}
+
+	if configuration.SentryDsn == "" {
+		return errors.New("sentry_dsn is not configured")
+	}
+
+	compression, err := sentry.ParseCompression(configuration.Transport.Compression)
+	if err != nil {
+		return err
+	}
+
+	options, err := promsentry.SentryClientOptions(configuration)
+	if err != nil {
+		return err
+	}
+
+	// The request goes through the same proxy and TLS settings as the server.
+	recorder := &recordingRoundTripper{RoundTripper: sentry.NewHTTPRoundTripper(options)}
+
+	// Deliveries are synchronous and not retried, so that the response of
+	// Sentry is known once the request is handled.
+	transport := sentry.NewHTTPSyncTransport()
+	transport.Timeout = *timeout
+	transport.RetryPolicy = sentry.RetryPolicy{MaxAttempts: 1}
+	transport.Compression = compression
+	transport.CompressionLevel = configuration.Transport.CompressionLevel
+
+	options.Transport = transport
+	options.HTTPTransport = recorder
+	client, err := sentry.NewClient(options)
+	if err != nil {
+		return err
+	}
+	// The metric gets the environment, the release and the tags of the
+	// configuration, like the ones sent to the server.
+	server, err := promsentry.NewServer(promsentry.ServerOptions{
+		Hub:        sentry.NewHub(client, sentry.NewScope()),
+		Conversion: promsentry.NewConversionOptions(configuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	body, err := testWriteRequest(*metricName)
+	if err != nil {
+		return err
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	response := httptest.NewRecorder()
+	server.Handler.ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		return fmt.Errorf("remote write handler responded with %d: %s", response.Code, strings.TrimSpace(response.Body.String()))
+	}
+
+	return recorder.report()
+}
+
+// testWriteRequest returns the snappy compressed remote write request of a
+// single gauge sample.
+func testWriteRequest(metricName string) ([]byte, error) {
+	writeRequest := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: metricName},
+				{Name: "source", Value: "promsentry-send-test"},
+			},
+			Samples: []prompb.Sample{{
+				Value:     1,
+				Timestamp: time.Now().UnixMilli(),
+			}},
+		}},
+	}
+
+	data, err := writeRequest.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// recordingRoundTripper keeps the responses to the requests it sends.
+type recordingRoundTripper struct {
+	http.RoundTripper
+
+	mu        sync.Mutex
+	exchanges []exchange
+}
+
+type exchange struct {
+	url    string
+	status string
+	header http.Header
+	body   []byte
+	err    error
+}
+
+func (r *recordingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := r.RoundTripper.RoundTrip(request)
+
+	e := exchange{url: request.URL.Redacted(), err: err}
+	if err == nil {
+		e.status = response.Status
+		e.header = response.Header
+		e.body, _ = io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		response.Body = io.NopCloser(bytes.NewReader(e.body))
+	}
+
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, e)
+	r.mu.Unlock()
+
+	return response, err
+}
+
+// report prints the recorded responses. It returns an error if nothing was
+// sent, or if Sentry did not accept the envelopes.
+func (r *recordingRoundTripper) report() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.exchanges) == 0 {
+		return errors.New("no envelope was sent to Sentry, check the logs with debug enabled")
+	}
+
+	var failed bool
+	for _, e := range r.exchanges {
+		fmt.Printf("POST %s\n", e.url)
+		if e.err != nil {
+			fmt.Printf("  error: %v\n", e.err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("  status: %s\n", e.status)
+		for _, name := range []string{"X-Sentry-Rate-Limits", "Retry-After"} {
+			if v := e.header.Get(name); v != "" {
+				fmt.Printf("  %s: %s\n", strings.ToLower(name), v)
+			}
+		}
+		if body := strings.TrimSpace(string(e.body)); body != "" {
+			fmt.Printf("  body: %s\n", body)
+		}
+
+		if !strings.HasPrefix(e.status, "200") {
+			failed = true
+		}
+	}
+
+	if failed {
+		return errors.New("sentry did not accept the test metric")
+	}
+
+	fmt.Println("Test metric accepted by Sentry")
+	return nil
+}
@@ -0,0 +1,293 @@
+//	Copyright 2023 Reinaldy Rafli <aldy505@proton.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aldy505/promsentry"
+	"github.com/aldy505/promsentry/forward"
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/scrape"
+	"github.com/aldy505/promsentry/sentry"
+	"github.com/aldy505/promsentry/telemetry"
+)
+
+// defaultShutdownTimeout is how long the shutdown waits for in-flight requests
+// and pending events when no shutdown_timeout is configured.
+const defaultShutdownTimeout = time.Second * 30
+
+// runServe starts the remote write server, until SIGINT or SIGTERM is received.
+func runServe(args []string) error {
+	configurationFilePath := parseFlags(flag.NewFlagSet("serve", flag.ExitOnError), args)
+
+	configuration, err := promsentry.ParseConfiguration(configurationFilePath)
+	if err != nil {
+		return err
+	}
+
+	logger, err := promsentry.NewLogger(configuration, os.Stderr)
+	if err != nil {
+		return err
+	}
+	// The log package, and the components without a logger of their own, write through it too.
+	slog.SetDefault(logger)
+
+	client, err := promsentry.NewSentryClient(configuration)
+	if err != nil {
+		return err
+	}
+	hub := sentry.CurrentHub()
+	hub.BindClient(client)
+
+	reloader := promsentry.NewReloader(configurationFilePath, configuration, logger.With("component", "reload"))
+	reloader.Register(promsentry.ReloadSentryClient(hub, shutdownTimeout(configuration)))
+
+	var tlsConfig *tls.Config = nil
+	var tlsReloader *promsentry.TLSReloader
+	if configuration.TLS.ServerCertificatePath != "" {
+		tlsReloader, err = promsentry.NewTLSReloader(configuration, logger.With("component", "tls"))
+		if err != nil {
+			return err
+		}
+		reloader.Register(tlsReloader.Reload)
+		tlsConfig = tlsReloader.TLSConfig()
+	}
+
+	sink, err := promsentry.CreateSink(configuration, hub)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := sink.Close(); err != nil {
+			logger.Error("Unable to close the sinks", "error", err)
+		}
+	}()
+
+	authenticator, err := promsentry.NewAuthenticator(configuration)
+	if err != nil {
+		return err
+	}
+	reloader.Register(authenticator.Reload)
+
+	forwarders, err := promsentry.CreateForwarders(configuration, logger.With("component", "forward"))
+	if err != nil {
+		return err
+	}
+	reloader.Register(promsentry.ReloadForwarders(forwarders))
+
+	health := promsentry.NewHealth(promsentry.HealthOptions{
+		Hub:           hub,
+		Configuration: reloader.Configuration,
+		MaxQueueUsage: configuration.Health.MaxQueueUsage,
+	})
+
+	var recorder *pipeline.Recorder
+	// Recording parses every statsd line, it is only done when the status page asks for it.
+	if !configuration.StatusPage.Disabled && configuration.StatusPage.Record {
+		recorder = pipeline.NewRecorder(pipeline.RecorderOptions{TailSize: configuration.StatusPage.TailLines})
+	}
+
+	conversion := promsentry.NewConversionOptions(configuration)
+	conversion.Logger = logger.With("component", "converter")
+	conversion.Metrics = telemetry.Pipeline{}
+
+	// Scraped metrics go through the same conversion and sinks as the remote write requests.
+	var recordedSink pipeline.Sink = sink
+	if recorder != nil {
+		recordedSink = recorder.Wrap(sink)
+	}
+	converter := pipeline.NewConverter(recordedSink, conversion)
+	reloader.Register(promsentry.ReloadConversion(converter))
+
+	scrapers, err := promsentry.CreateScrapers(configuration, converter, logger.With("component", "scrape"))
+	if err != nil {
+		return err
+	}
+
+	limits := promsentry.NewServerLimits(configuration)
+	limiter := promsentry.NewRequestLimiter(limits)
+	reloader.Register(promsentry.ReloadLimits(limiter))
+
+	server, err := promsentry.NewServer(promsentry.ServerOptions{
+		ListenAddress: configuration.ListenAddress,
+		TLSConfig:     tlsConfig,
+		Converter:     converter,
+		Forwarders:    forwarders,
+		Limits:        limits,
+		Limiter:       limiter,
+		Middleware:    []func(http.Handler) http.Handler{authenticator.Middleware},
+		Health:        health,
+		Logger:        logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	adminServer, err := promsentry.NewAdminServer(promsentry.AdminServerOptions{
+		ListenAddress:     configuration.AdminListenAddress,
+		Health:            health,
+		Recorder:          recorder,
+		DisableStatusPage: configuration.StatusPage.Disabled,
+		Reload:            reloader.Reload,
+		Logger:            logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if tlsConfig == nil {
+			logger.Info("Server starting", "address", "http://"+server.Addr)
+			err := server.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Server stopped", "error", err)
+				stop()
+			}
+		} else {
+			logger.Info("Server starting", "address", "https://"+server.Addr)
+			err := server.ListenAndServeTLS("", "")
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Server stopped", "error", err)
+				stop()
+			}
+		}
+	}()
+
+	go func() {
+		logger.Info("Admin server starting", "address", "http://"+adminServer.Addr)
+		err := adminServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Admin server stopped", "error", err)
+			stop()
+		}
+	}()
+
+	go func() {
+		err := reloader.Watch(ctx)
+		if err != nil {
+			logger.Error("Unable to watch the configuration file", "error", err)
+		}
+	}()
+
+	if tlsReloader != nil {
+		go func() {
+			err := tlsReloader.Watch(ctx)
+			if err != nil {
+				logger.Error("Unable to watch the TLS files", "error", err)
+			}
+		}()
+	}
+
+	var scraping sync.WaitGroup
+	for _, scraper := range scrapers {
+		logger.Info("Scraping targets", "job", scraper.Job())
+		scraping.Add(1)
+		go func(scraper *scrape.Scraper) {
+			defer scraping.Done()
+			scraper.Run(ctx)
+		}(scraper)
+	}
+
+	<-ctx.Done()
+	// Restore the default behavior, a second signal terminates immediately.
+	stop()
+	// Scrapes in progress are abandoned, the scraped metrics are already in the transport.
+	scraping.Wait()
+
+	timeout := shutdownTimeout(reloader.Configuration())
+	logger.Info("Shutting down, waiting for pending events", "timeout", timeout.String())
+	shutdown(server, hub, forwarders, timeout, logger.With("component", "shutdown"))
+	// The admin server answers until the pending events are flushed.
+	if err := adminServer.Close(); err != nil {
+		logger.Error("Unable to close the admin server", "error", err)
+	}
+	return nil
+}
+
+// shutdownTimeout returns the shutdown_timeout of the configuration, or its default.
+func shutdownTimeout(configuration *promsentry.Configuration) time.Duration {
+	if configuration.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(configuration.ShutdownTimeout)
+}
+
+// shutdown stops accepting remote write requests, waits for the in-flight ones,
+// flushes the Sentry transport and the forwarding queues, all within the
+// given timeout.
+func shutdown(server *http.Server, hub *sentry.Hub, forwarders []*forward.Forwarder, timeout time.Duration, logger *slog.Logger) {
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	// Handlers flush their statsd buffer into an event before returning, so
+	// every received sample is in the transport once Shutdown returns.
+	err := server.Shutdown(ctx)
+	if err != nil {
+		logger.Error("Unable to wait for in-flight requests", "error", err)
+	}
+
+	// Forwarding queues are flushed concurrently with the Sentry transport.
+	var wg sync.WaitGroup
+	for _, forwarder := range forwarders {
+		wg.Add(1)
+		go func(forwarder *forward.Forwarder) {
+			defer wg.Done()
+			if err := forwarder.Close(ctx); err != nil {
+				logger.Error("Unable to flush the forwarding queue", "target", forwarder.Name(), "error", err)
+			}
+		}(forwarder)
+	}
+	defer wg.Wait()
+
+	client := hub.Client()
+	if client == nil {
+		return
+	}
+	transport := client.Transport
+
+	if !client.Flush(time.Until(deadline)) {
+		switch t := transport.(type) {
+		case *sentry.HTTPTransport:
+			logger.Warn("Shutdown timeout reached, events were not sent to Sentry and are lost", "events", t.Pending())
+		case *sentry.DiskTransport:
+			logger.Warn("Shutdown timeout reached, events stay queued on disk until the next start", "bytes", t.QueueSize())
+		default:
+			logger.Warn("Shutdown timeout reached, some events might not have been sent to Sentry")
+		}
+	}
+
+	if closer, ok := transport.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			logger.Error("Unable to close the Sentry transport", "error", err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+//	Copyright 2023 Reinaldy Rafli <aldy505@proton.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/aldy505/promsentry/sentry"
+)
+
+// runVersion prints the version of promsentry and how it was built.
+func runVersion(args []string) error {
+	_ = flag.NewFlagSet("version", flag.ExitOnError).Parse(args)
+
+	fmt.Print(versionInfo())
+	return nil
+}
+
+// versionInfo formats the build information embedded by the Go toolchain.
+func versionInfo() string {
+	var b strings.Builder
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		b.WriteString("promsentry (unknown version)\n")
+		fmt.Fprintf(&b, "  sentry sdk: %s\n", sentry.SDKVersion)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "promsentry %s\n", info.Main.Version)
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			fmt.Fprintf(&b, "  revision: %s\n", setting.Value)
+		case "vcs.time":
+			fmt.Fprintf(&b, "  build date: %s\n", setting.Value)
+		case "vcs.modified":
+			fmt.Fprintf(&b, "  modified: %s\n", setting.Value)
+		}
+	}
+	fmt.Fprintf(&b, "  go version: %s\n", info.GoVersion)
+	fmt.Fprintf(&b, "  sentry sdk: %s\n", sentry.SDKVersion)
+
+	return b.String()
+}
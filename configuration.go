@@ -1,32 +1,272 @@
 package promsentry
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/sentry"
+	"github.com/aldy505/promsentry/sentry/wal"
 	"gopkg.in/yaml.v3"
 )
 
+// Duration is a time.Duration that is written as a Go duration string, like
+// "30s" or "1h30m", in the configuration file.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string: %w", err)
+	}
+
+	return d.parse(s)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return fmt.Errorf("duration should be a string: %w", err)
+	}
+
+	if err := d.parse(s); err != nil {
+		return fmt.Errorf("line %d, column %d: %w", value.Line, value.Column, err)
+	}
+	return nil
+}
+
+func (d *Duration) parse(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
 type Configuration struct {
-	ListenAddress string `json:"listen_address" yaml:"listen_address"`
-	TLS           struct {
-		CertificateAuthorityPath string `json:"certificate_authority_path" yaml:"certificate_authority_path"`
-		ServerCertificatePath    string `json:"server_certificate_path" yaml:"server_certificate_path"`
-		ServerKeyPath            string `json:"server_key_path" yaml:"server_key_path"`
-		ClientAuthenticationType string `json:"client_authentication_type" yaml:"client_authentication_type"`
+	ListenAddress      string `json:"listen_address" yaml:"listen_address"`
+	AdminListenAddress string `json:"admin_listen_address" yaml:"admin_listen_address"`
+	TLS                struct {
+		CertificateAuthorityPath string   `json:"certificate_authority_path" yaml:"certificate_authority_path"`
+		ServerCertificatePath    string   `json:"server_certificate_path" yaml:"server_certificate_path"`
+		ServerKeyPath            string   `json:"server_key_path" yaml:"server_key_path"`
+		ClientAuthenticationType string   `json:"client_authentication_type" yaml:"client_authentication_type"`
+		MinVersion               string   `json:"min_version" yaml:"min_version"`
+		CipherSuites             []string `json:"cipher_suites" yaml:"cipher_suites"`
 	} `json:"tls" yaml:"tls"`
-	SentryDsn string `json:"sentry_dsn" yaml:"sentry_dsn"`
-	Debug     bool   `json:"debug" yaml:"debug"`
+	Limits struct {
+		MaxRequestBytes      int64    `json:"max_request_bytes" yaml:"max_request_bytes"`
+		MaxDecodedBytes      int64    `json:"max_decoded_bytes" yaml:"max_decoded_bytes"`
+		MaxSeriesPerRequest  int      `json:"max_series_per_request" yaml:"max_series_per_request"`
+		MaxSamplesPerRequest int      `json:"max_samples_per_request" yaml:"max_samples_per_request"`
+		RateLimit            float64  `json:"rate_limit" yaml:"rate_limit"`
+		RateLimitBurst       int      `json:"rate_limit_burst" yaml:"rate_limit_burst"`
+		ReadHeaderTimeout    Duration `json:"read_header_timeout" yaml:"read_header_timeout"`
+		ReadTimeout          Duration `json:"read_timeout" yaml:"read_timeout"`
+		WriteTimeout         Duration `json:"write_timeout" yaml:"write_timeout"`
+		IdleTimeout          Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	} `json:"limits" yaml:"limits"`
+	Auth struct {
+		BearerTokens []BearerToken `json:"bearer_tokens" yaml:"bearer_tokens"`
+		BasicAuth    struct {
+			Users     []BasicAuthUser `json:"users" yaml:"users"`
+			UsersFile string          `json:"users_file" yaml:"users_file"`
+		} `json:"basic_auth" yaml:"basic_auth"`
+		ClientCertificate struct {
+			Enabled      bool     `json:"enabled" yaml:"enabled"`
+			IdentityFrom string   `json:"identity_from" yaml:"identity_from"`
+			Allowed      []string `json:"allowed" yaml:"allowed"`
+		} `json:"client_certificate" yaml:"client_certificate"`
+	} `json:"auth" yaml:"auth"`
+	SentryDsn   string `json:"sentry_dsn" yaml:"sentry_dsn"`
+	DryRun      bool   `json:"dry_run" yaml:"dry_run"`
+	Environment string `json:"environment" yaml:"environment"`
+	Release     string `json:"release" yaml:"release"`
+	Tags        struct {
+		Static     map[string]string `json:"static" yaml:"static"`
+		FromLabels map[string]string `json:"from_labels" yaml:"from_labels"`
+		Routes     []TagRoute        `json:"routes" yaml:"routes"`
+	} `json:"tags" yaml:"tags"`
+	Transport struct {
+		Queue struct {
+			Directory      string   `json:"directory" yaml:"directory"`
+			MaxSegmentSize int64    `json:"max_segment_size" yaml:"max_segment_size"`
+			MaxSize        int64    `json:"max_size" yaml:"max_size"`
+			MaxAge         Duration `json:"max_age" yaml:"max_age"`
+			Fsync          string   `json:"fsync" yaml:"fsync"`
+			FsyncInterval  Duration `json:"fsync_interval" yaml:"fsync_interval"`
+		} `json:"queue" yaml:"queue"`
+		Retry struct {
+			MaxAttempts    int      `json:"max_attempts" yaml:"max_attempts"`
+			InitialBackoff Duration `json:"initial_backoff" yaml:"initial_backoff"`
+			MaxBackoff     Duration `json:"max_backoff" yaml:"max_backoff"`
+			Jitter         float64  `json:"jitter" yaml:"jitter"`
+		} `json:"retry" yaml:"retry"`
+		MaxItemBytes         int    `json:"max_item_bytes" yaml:"max_item_bytes"`
+		MaxItemLines         int    `json:"max_item_lines" yaml:"max_item_lines"`
+		Compression          string `json:"compression" yaml:"compression"`
+		CompressionLevel     int    `json:"compression_level" yaml:"compression_level"`
+		Workers              int    `json:"workers" yaml:"workers"`
+		DisableClientReports bool   `json:"disable_client_reports" yaml:"disable_client_reports"`
+		TLS                  struct {
+			CertificateAuthorityPath string `json:"certificate_authority_path" yaml:"certificate_authority_path"`
+			ClientCertificatePath    string `json:"client_certificate_path" yaml:"client_certificate_path"`
+			ClientKeyPath            string `json:"client_key_path" yaml:"client_key_path"`
+			MinVersion               string `json:"min_version" yaml:"min_version"`
+		} `json:"tls" yaml:"tls"`
+		ProxyURL   string   `json:"proxy_url" yaml:"proxy_url"`
+		Timeout    Duration `json:"timeout" yaml:"timeout"`
+		BufferSize int      `json:"buffer_size" yaml:"buffer_size"`
+	} `json:"transport" yaml:"transport"`
+	Sinks struct {
+		Sentry struct {
+			Disabled bool `json:"disabled" yaml:"disabled"`
+		} `json:"sentry" yaml:"sentry"`
+		Stdout struct {
+			Enabled bool   `json:"enabled" yaml:"enabled"`
+			Format  string `json:"format" yaml:"format"`
+		} `json:"stdout" yaml:"stdout"`
+		File struct {
+			Path       string `json:"path" yaml:"path"`
+			Format     string `json:"format" yaml:"format"`
+			MaxSize    int64  `json:"max_size" yaml:"max_size"`
+			MaxBackups int    `json:"max_backups" yaml:"max_backups"`
+		} `json:"file" yaml:"file"`
+		Statsd struct {
+			Address       string `json:"address" yaml:"address"`
+			MaxPacketSize int    `json:"max_packet_size" yaml:"max_packet_size"`
+		} `json:"statsd" yaml:"statsd"`
+	} `json:"sinks" yaml:"sinks"`
+	Forward       []ForwardTarget `json:"forward" yaml:"forward"`
+	ScrapeConfigs []ScrapeConfig  `json:"scrape_configs" yaml:"scrape_configs"`
+	Log           struct {
+		Level    string `json:"level" yaml:"level"`
+		Format   string `json:"format" yaml:"format"`
+		Sampling struct {
+			Interval   Duration `json:"interval" yaml:"interval"`
+			First      int      `json:"first" yaml:"first"`
+			Thereafter int      `json:"thereafter" yaml:"thereafter"`
+		} `json:"sampling" yaml:"sampling"`
+	} `json:"log" yaml:"log"`
+	Health struct {
+		MaxQueueUsage float64 `json:"max_queue_usage" yaml:"max_queue_usage"`
+	} `json:"health" yaml:"health"`
+	StatusPage struct {
+		Disabled  bool `json:"disabled" yaml:"disabled"`
+		Record    bool `json:"record" yaml:"record"`
+		TailLines int  `json:"tail_lines" yaml:"tail_lines"`
+	} `json:"status_page" yaml:"status_page"`
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	Debug           bool     `json:"debug" yaml:"debug"`
+}
+
+// BearerToken is a static token accepted in the Authorization header of remote write requests.
+type BearerToken struct {
+	Identity  string `json:"identity" yaml:"identity"`
+	Token     string `json:"token" yaml:"token"`
+	TokenFile string `json:"token_file" yaml:"token_file"`
+}
+
+// BasicAuthUser is a user accepted with basic authentication on remote write requests.
+type BasicAuthUser struct {
+	Username     string `json:"username" yaml:"username"`
+	PasswordHash string `json:"password_hash" yaml:"password_hash"`
 }
 
+// ForwardTarget is a Prometheus remote write backend that receives a copy of every remote write request.
+type ForwardTarget struct {
+	Name      string            `json:"name" yaml:"name"`
+	URL       string            `json:"url" yaml:"url"`
+	Headers   map[string]string `json:"headers" yaml:"headers"`
+	BasicAuth struct {
+		Username string `json:"username" yaml:"username"`
+		Password string `json:"password" yaml:"password"`
+	} `json:"basic_auth" yaml:"basic_auth"`
+	BearerToken string   `json:"bearer_token" yaml:"bearer_token"`
+	Timeout     Duration `json:"timeout" yaml:"timeout"`
+	QueueSize   int      `json:"queue_size" yaml:"queue_size"`
+	Retry       struct {
+		MaxAttempts    int      `json:"max_attempts" yaml:"max_attempts"`
+		InitialBackoff Duration `json:"initial_backoff" yaml:"initial_backoff"`
+		MaxBackoff     Duration `json:"max_backoff" yaml:"max_backoff"`
+	} `json:"retry" yaml:"retry"`
+	WriteRelabelConfigs []RelabelConfig `json:"write_relabel_configs" yaml:"write_relabel_configs"`
+}
+
+// RelabelConfig is a Prometheus relabeling rule, with the same fields and defaults as in the Prometheus
+// configuration.
+type RelabelConfig struct {
+	SourceLabels []string `json:"source_labels" yaml:"source_labels"`
+	Separator    string   `json:"separator" yaml:"separator"`
+	Regex        string   `json:"regex" yaml:"regex"`
+	Modulus      uint64   `json:"modulus" yaml:"modulus"`
+	TargetLabel  string   `json:"target_label" yaml:"target_label"`
+	Replacement  string   `json:"replacement" yaml:"replacement"`
+	Action       string   `json:"action" yaml:"action"`
+}
+
+// ScrapeConfig is a job whose targets are scraped by promsentry, with the same fields and defaults as a scrape config
+// of Prometheus.
+type ScrapeConfig struct {
+	JobName              string          `json:"job_name" yaml:"job_name"`
+	ScrapeInterval       Duration        `json:"scrape_interval" yaml:"scrape_interval"`
+	ScrapeTimeout        Duration        `json:"scrape_timeout" yaml:"scrape_timeout"`
+	MetricsPath          string          `json:"metrics_path" yaml:"metrics_path"`
+	Scheme               string          `json:"scheme" yaml:"scheme"`
+	StaticConfigs        []StaticConfig  `json:"static_configs" yaml:"static_configs"`
+	FileSDConfigs        []FileSDConfig  `json:"file_sd_configs" yaml:"file_sd_configs"`
+	MetricRelabelConfigs []RelabelConfig `json:"metric_relabel_configs" yaml:"metric_relabel_configs"`
+}
+
+// StaticConfig lists scrape targets, as host:port addresses, and the labels added to their time series.
+type StaticConfig struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// FileSDConfig lists the files holding scrape targets, in the file_sd format of Prometheus.
+type FileSDConfig struct {
+	Files           []string `json:"files" yaml:"files"`
+	RefreshInterval Duration `json:"refresh_interval" yaml:"refresh_interval"`
+}
+
+// TagRoute overrides the environment, the release and the static tags of the time series sent by a client, or of
+// the ones that have the given labels.
+type TagRoute struct {
+	Identity    string            `json:"identity" yaml:"identity"`
+	Labels      map[string]string `json:"labels" yaml:"labels"`
+	Environment string            `json:"environment" yaml:"environment"`
+	Release     string            `json:"release" yaml:"release"`
+	Static      map[string]string `json:"static" yaml:"static"`
+}
+
+// ParseConfiguration reads the configuration file, if any, then applies the environment variables on top of it.
+//
+// The format of the file is chosen by its extension (.json, .yaml or .yml). Unknown fields are rejected, and
+// ${VARIABLE} references are replaced by the value of the environment variable before parsing. Every environment
+// variable can also be read from a file, by setting the variable suffixed with _FILE to the path of the file.
 func ParseConfiguration(filePath string) (*Configuration, error) {
 	var configuration Configuration
 	if filePath != "" {
-		file, err := os.Open(filePath)
+		content, err := os.ReadFile(filePath)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				return nil, fmt.Errorf("configuration file does not exists")
@@ -38,57 +278,650 @@ func ParseConfiguration(filePath string) (*Configuration, error) {
 
 			return nil, fmt.Errorf("unhandled file error: %w", err)
 		}
-		defer func() {
-			_ = file.Close()
-		}()
 
-		switch path.Ext(filePath) {
-		case "json":
-			err := json.NewDecoder(file).Decode(&configuration)
+		content = expandEnv(content)
+
+		switch strings.ToLower(filepath.Ext(filePath)) {
+		case ".json":
+			err := decodeJSON(content, &configuration)
 			if err != nil {
-				return nil, fmt.Errorf("failed parsing the config file for json format, make sure you got everything right")
+				return nil, fmt.Errorf("failed parsing the config file %s: %w", filePath, err)
 			}
-		case "yaml", "yml":
-			err := yaml.NewDecoder(file).Decode(&configuration)
+		case ".yaml", ".yml":
+			err := decodeYAML(content, &configuration)
 			if err != nil {
-				return nil, fmt.Errorf("failed parsing the config file for yaml format, make sure you got everything right")
+				return nil, fmt.Errorf("failed parsing the config file %s: %w", filePath, err)
 			}
 		default:
-			return nil, fmt.Errorf("configuration file format is not supported")
+			return nil, fmt.Errorf("configuration file format is not supported, use a .json, .yaml or .yml file")
 		}
 	}
 
+	env := &environment{}
+
 	// Read from environment variable (this takes priority)
-	if v, ok := os.LookupEnv("LISTEN_ADDRESS"); ok {
+	if v, ok := env.lookup("LISTEN_ADDRESS"); ok {
 		configuration.ListenAddress = v
 	}
 
-	if v, ok := os.LookupEnv("TLS_CERTIFICATE_AUTHORITY_PATH"); ok {
+	if v, ok := env.lookup("ADMIN_LISTEN_ADDRESS"); ok {
+		configuration.AdminListenAddress = v
+	}
+
+	if v, ok := env.lookup("TLS_CERTIFICATE_AUTHORITY_PATH"); ok {
 		configuration.TLS.CertificateAuthorityPath = v
 	}
 
-	if v, ok := os.LookupEnv("TLS_SERVER_CERTIFICATE_PATH"); ok {
+	if v, ok := env.lookup("TLS_SERVER_CERTIFICATE_PATH"); ok {
 		configuration.TLS.ServerCertificatePath = v
 	}
 
-	if v, ok := os.LookupEnv("TLS_SERVER_KEY_PATH"); ok {
+	if v, ok := env.lookup("TLS_SERVER_KEY_PATH"); ok {
 		configuration.TLS.ServerKeyPath = v
 	}
 
-	if v, ok := os.LookupEnv("TLS_CLIENT_AUTHENTICATION_TYPE"); ok {
+	if v, ok := env.lookup("TLS_CLIENT_AUTHENTICATION_TYPE"); ok {
 		configuration.TLS.ClientAuthenticationType = v
 	}
 
-	if v, ok := os.LookupEnv("SENTRY_DSN"); ok {
+	if v, ok := env.lookup("LIMITS_MAX_REQUEST_BYTES"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_MAX_REQUEST_BYTES: %w", err)
+		}
+		configuration.Limits.MaxRequestBytes = n
+	}
+
+	if v, ok := env.lookup("LIMITS_MAX_DECODED_BYTES"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_MAX_DECODED_BYTES: %w", err)
+		}
+		configuration.Limits.MaxDecodedBytes = n
+	}
+
+	if v, ok := env.lookup("LIMITS_MAX_SERIES_PER_REQUEST"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_MAX_SERIES_PER_REQUEST: %w", err)
+		}
+		configuration.Limits.MaxSeriesPerRequest = n
+	}
+
+	if v, ok := env.lookup("LIMITS_MAX_SAMPLES_PER_REQUEST"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_MAX_SAMPLES_PER_REQUEST: %w", err)
+		}
+		configuration.Limits.MaxSamplesPerRequest = n
+	}
+
+	if v, ok := env.lookup("LIMITS_RATE_LIMIT"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_RATE_LIMIT: %w", err)
+		}
+		configuration.Limits.RateLimit = f
+	}
+
+	if v, ok := env.lookup("LIMITS_RATE_LIMIT_BURST"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_RATE_LIMIT_BURST: %w", err)
+		}
+		configuration.Limits.RateLimitBurst = n
+	}
+
+	if v, ok := env.lookup("LIMITS_READ_HEADER_TIMEOUT"); ok {
+		if err := configuration.Limits.ReadHeaderTimeout.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_READ_HEADER_TIMEOUT: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("LIMITS_READ_TIMEOUT"); ok {
+		if err := configuration.Limits.ReadTimeout.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_READ_TIMEOUT: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("LIMITS_WRITE_TIMEOUT"); ok {
+		if err := configuration.Limits.WriteTimeout.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_WRITE_TIMEOUT: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("LIMITS_IDLE_TIMEOUT"); ok {
+		if err := configuration.Limits.IdleTimeout.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid LIMITS_IDLE_TIMEOUT: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("AUTH_BEARER_TOKEN"); ok {
+		configuration.Auth.BearerTokens = append(configuration.Auth.BearerTokens, BearerToken{Identity: "default", Token: v})
+	}
+
+	if v, ok := env.lookup("AUTH_BASIC_AUTH_USERS_FILE"); ok {
+		configuration.Auth.BasicAuth.UsersFile = v
+	}
+
+	if v, ok := env.lookup("AUTH_CLIENT_CERTIFICATE_ENABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTH_CLIENT_CERTIFICATE_ENABLED: %w", err)
+		}
+		configuration.Auth.ClientCertificate.Enabled = b
+	}
+
+	if v, ok := env.lookup("AUTH_CLIENT_CERTIFICATE_IDENTITY_FROM"); ok {
+		configuration.Auth.ClientCertificate.IdentityFrom = v
+	}
+
+	if v, ok := env.lookup("TLS_MIN_VERSION"); ok {
+		configuration.TLS.MinVersion = v
+	}
+
+	if v, ok := env.lookup("TLS_CIPHER_SUITES"); ok {
+		configuration.TLS.CipherSuites = strings.Split(v, ",")
+	}
+
+	if v, ok := env.lookup("SENTRY_DSN"); ok {
 		configuration.SentryDsn = v
 	}
 
-	if v, ok := os.LookupEnv("DEBUG"); ok {
+	if v, ok := env.lookup("DRY_RUN"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DRY_RUN: %w", err)
+		}
+		configuration.DryRun = b
+	}
+
+	if v, ok := env.lookup("SENTRY_ENVIRONMENT"); ok {
+		configuration.Environment = v
+	}
+
+	if v, ok := env.lookup("SENTRY_RELEASE"); ok {
+		configuration.Release = v
+	}
+
+	if v, ok := env.lookup("TAGS_STATIC"); ok {
+		configuration.Tags.Static = parseKeyValues(v)
+	}
+
+	if v, ok := env.lookup("TAGS_FROM_LABELS"); ok {
+		configuration.Tags.FromLabels = parseKeyValues(v)
+	}
+
+	if v, ok := env.lookup("TRANSPORT_QUEUE_DIRECTORY"); ok {
+		configuration.Transport.Queue.Directory = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_QUEUE_MAX_SEGMENT_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_QUEUE_MAX_SEGMENT_SIZE: %w", err)
+		}
+		configuration.Transport.Queue.MaxSegmentSize = n
+	}
+
+	if v, ok := env.lookup("TRANSPORT_QUEUE_MAX_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_QUEUE_MAX_SIZE: %w", err)
+		}
+		configuration.Transport.Queue.MaxSize = n
+	}
+
+	if v, ok := env.lookup("TRANSPORT_QUEUE_MAX_AGE"); ok {
+		if err := configuration.Transport.Queue.MaxAge.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_QUEUE_MAX_AGE: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("TRANSPORT_QUEUE_FSYNC"); ok {
+		configuration.Transport.Queue.Fsync = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_QUEUE_FSYNC_INTERVAL"); ok {
+		if err := configuration.Transport.Queue.FsyncInterval.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_QUEUE_FSYNC_INTERVAL: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("TRANSPORT_RETRY_MAX_ATTEMPTS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_RETRY_MAX_ATTEMPTS: %w", err)
+		}
+		configuration.Transport.Retry.MaxAttempts = n
+	}
+
+	if v, ok := env.lookup("TRANSPORT_RETRY_INITIAL_BACKOFF"); ok {
+		if err := configuration.Transport.Retry.InitialBackoff.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_RETRY_INITIAL_BACKOFF: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("TRANSPORT_RETRY_MAX_BACKOFF"); ok {
+		if err := configuration.Transport.Retry.MaxBackoff.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_RETRY_MAX_BACKOFF: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("TRANSPORT_RETRY_JITTER"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_RETRY_JITTER: %w", err)
+		}
+		configuration.Transport.Retry.Jitter = f
+	}
+
+	if v, ok := env.lookup("TRANSPORT_MAX_ITEM_BYTES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_MAX_ITEM_BYTES: %w", err)
+		}
+		configuration.Transport.MaxItemBytes = n
+	}
+
+	if v, ok := env.lookup("TRANSPORT_MAX_ITEM_LINES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_MAX_ITEM_LINES: %w", err)
+		}
+		configuration.Transport.MaxItemLines = n
+	}
+
+	if v, ok := env.lookup("TRANSPORT_COMPRESSION"); ok {
+		configuration.Transport.Compression = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_COMPRESSION_LEVEL"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_COMPRESSION_LEVEL: %w", err)
+		}
+		configuration.Transport.CompressionLevel = n
+	}
+
+	if v, ok := env.lookup("TRANSPORT_WORKERS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_WORKERS: %w", err)
+		}
+		configuration.Transport.Workers = n
+	}
+
+	if v, ok := env.lookup("TRANSPORT_DISABLE_CLIENT_REPORTS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_DISABLE_CLIENT_REPORTS: %w", err)
+		}
+		configuration.Transport.DisableClientReports = b
+	}
+
+	if v, ok := env.lookup("TRANSPORT_TLS_CERTIFICATE_AUTHORITY_PATH"); ok {
+		configuration.Transport.TLS.CertificateAuthorityPath = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_TLS_CLIENT_CERTIFICATE_PATH"); ok {
+		configuration.Transport.TLS.ClientCertificatePath = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_TLS_CLIENT_KEY_PATH"); ok {
+		configuration.Transport.TLS.ClientKeyPath = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_TLS_MIN_VERSION"); ok {
+		configuration.Transport.TLS.MinVersion = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_PROXY_URL"); ok {
+		configuration.Transport.ProxyURL = v
+	}
+
+	if v, ok := env.lookup("TRANSPORT_TIMEOUT"); ok {
+		if err := configuration.Transport.Timeout.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_TIMEOUT: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("TRANSPORT_BUFFER_SIZE"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRANSPORT_BUFFER_SIZE: %w", err)
+		}
+		configuration.Transport.BufferSize = n
+	}
+
+	if v, ok := env.lookup("SINKS_SENTRY_DISABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SINKS_SENTRY_DISABLED: %w", err)
+		}
+		configuration.Sinks.Sentry.Disabled = b
+	}
+
+	if v, ok := env.lookup("SINKS_STDOUT_ENABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SINKS_STDOUT_ENABLED: %w", err)
+		}
+		configuration.Sinks.Stdout.Enabled = b
+	}
+
+	if v, ok := env.lookup("SINKS_STDOUT_FORMAT"); ok {
+		configuration.Sinks.Stdout.Format = v
+	}
+
+	if v, ok := env.lookup("SINKS_FILE_PATH"); ok {
+		configuration.Sinks.File.Path = v
+	}
+
+	if v, ok := env.lookup("SINKS_FILE_FORMAT"); ok {
+		configuration.Sinks.File.Format = v
+	}
+
+	if v, ok := env.lookup("SINKS_FILE_MAX_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SINKS_FILE_MAX_SIZE: %w", err)
+		}
+		configuration.Sinks.File.MaxSize = n
+	}
+
+	if v, ok := env.lookup("SINKS_FILE_MAX_BACKUPS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SINKS_FILE_MAX_BACKUPS: %w", err)
+		}
+		configuration.Sinks.File.MaxBackups = n
+	}
+
+	if v, ok := env.lookup("SINKS_STATSD_ADDRESS"); ok {
+		configuration.Sinks.Statsd.Address = v
+	}
+
+	if v, ok := env.lookup("SINKS_STATSD_MAX_PACKET_SIZE"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SINKS_STATSD_MAX_PACKET_SIZE: %w", err)
+		}
+		configuration.Sinks.Statsd.MaxPacketSize = n
+	}
+
+	if v, ok := env.lookup("LOG_LEVEL"); ok {
+		configuration.Log.Level = v
+	}
+
+	if v, ok := env.lookup("LOG_FORMAT"); ok {
+		configuration.Log.Format = v
+	}
+
+	if v, ok := env.lookup("LOG_SAMPLING_INTERVAL"); ok {
+		if err := configuration.Log.Sampling.Interval.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid LOG_SAMPLING_INTERVAL: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("LOG_SAMPLING_FIRST"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_SAMPLING_FIRST: %w", err)
+		}
+		configuration.Log.Sampling.First = n
+	}
+
+	if v, ok := env.lookup("LOG_SAMPLING_THEREAFTER"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_SAMPLING_THEREAFTER: %w", err)
+		}
+		configuration.Log.Sampling.Thereafter = n
+	}
+
+	if v, ok := env.lookup("HEALTH_MAX_QUEUE_USAGE"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEALTH_MAX_QUEUE_USAGE: %w", err)
+		}
+		configuration.Health.MaxQueueUsage = f
+	}
+
+	if v, ok := env.lookup("STATUS_PAGE_DISABLED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATUS_PAGE_DISABLED: %w", err)
+		}
+		configuration.StatusPage.Disabled = b
+	}
+
+	if v, ok := env.lookup("STATUS_PAGE_RECORD"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATUS_PAGE_RECORD: %w", err)
+		}
+		configuration.StatusPage.Record = b
+	}
+
+	if v, ok := env.lookup("STATUS_PAGE_TAIL_LINES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATUS_PAGE_TAIL_LINES: %w", err)
+		}
+		configuration.StatusPage.TailLines = n
+	}
+
+	if v, ok := env.lookup("SHUTDOWN_TIMEOUT"); ok {
+		if err := configuration.ShutdownTimeout.parse(v); err != nil {
+			return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+		}
+	}
+
+	if v, ok := env.lookup("DEBUG"); ok {
 		b, err := strconv.ParseBool(v)
-		if err == nil {
-			configuration.Debug = b
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEBUG: %w", err)
 		}
+		configuration.Debug = b
+	}
+
+	if env.err != nil {
+		return nil, env.err
 	}
 
 	return &configuration, nil
 }
+
+// decodeJSON decodes a JSON configuration, rejecting unknown fields. Errors are prefixed by the line and column
+// where decoding stopped.
+func decodeJSON(content []byte, configuration *Configuration) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(configuration)
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	offset := decoder.InputOffset()
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxError):
+		// The offset is right after the invalid character.
+		offset = syntaxError.Offset - 1
+	case errors.As(err, &typeError):
+		offset = typeError.Offset
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder doesn't report where the unknown field is, look for its first occurrence instead.
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		if i := bytes.Index(content, []byte(field)); i >= 0 {
+			offset = int64(i)
+		}
+	}
+
+	line, column := position(content, offset)
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// decodeYAML decodes a YAML configuration, rejecting unknown fields. The errors of the YAML decoder already
+// contain the line.
+func decodeYAML(content []byte, configuration *Configuration) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(configuration)
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// position converts a byte offset into a line and a column, both starting at 1.
+func position(content []byte, offset int64) (line int, column int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	before := content[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the ${VARIABLE} references with the value of the environment variable. Unset variables are
+// replaced by an empty string, and other uses of $ are left untouched.
+func expandEnv(content []byte) []byte {
+	return envReference.ReplaceAllFunc(content, func(reference []byte) []byte {
+		name := envReference.FindSubmatch(reference)[1]
+		return []byte(os.Getenv(string(name)))
+	})
+}
+
+// environment reads the environment variables that override the configuration file.
+type environment struct {
+	// err is the first error that occurred while reading a _FILE variable.
+	err error
+}
+
+// lookup returns the value of the environment variable. When it isn't set, the variable suffixed with _FILE is
+// looked up instead, and the content of the file it names is returned, without its trailing newline.
+func (e *environment) lookup(key string) (string, bool) {
+	if v, ok := os.LookupEnv(key); ok {
+		return v, true
+	}
+
+	filePath, ok := os.LookupEnv(key + "_FILE")
+	if !ok {
+		return "", false
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		if e.err == nil {
+			e.err = fmt.Errorf("reading %s_FILE: %w", key, err)
+		}
+		return "", false
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), true
+}
+
+// parseKeyValues parses comma separated key=value pairs, like "region=eu,cluster=main". Pairs without a = are
+// ignored.
+func parseKeyValues(s string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// Validate checks that every component can be created from the configuration, so that reloading an invalid
+// configuration keeps the previous one in place.
+func (c *Configuration) Validate() error {
+	if c.AdminListenAddress != "" && c.AdminListenAddress == c.ListenAddress {
+		return fmt.Errorf("invalid admin_listen_address: must differ from listen_address, got %q", c.AdminListenAddress)
+	}
+
+	if c.SentryDsn != "" {
+		if _, err := sentry.NewDsn(c.SentryDsn); err != nil {
+			return fmt.Errorf("invalid sentry_dsn: %w", err)
+		}
+	}
+
+	compression, err := sentry.ParseCompression(c.Transport.Compression)
+	if err == nil {
+		err = sentry.ValidateCompression(compression, c.Transport.CompressionLevel)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid transport configuration: %w", err)
+	}
+
+	if _, err := wal.ParseFsyncPolicy(c.Transport.Queue.Fsync); err != nil {
+		return fmt.Errorf("invalid transport queue configuration: %w", err)
+	}
+
+	if _, err := SentryClientOptions(c); err != nil {
+		return err
+	}
+
+	if _, err := NewLogger(c, io.Discard); err != nil {
+		return fmt.Errorf("invalid log configuration: %w", err)
+	}
+
+	if c.Health.MaxQueueUsage < 0 || c.Health.MaxQueueUsage > 1 {
+		return fmt.Errorf("invalid health configuration: max_queue_usage must be between 0 and 1, got %v", c.Health.MaxQueueUsage)
+	}
+
+	if err := validateTags(c); err != nil {
+		return fmt.Errorf("invalid tags configuration: %w", err)
+	}
+
+	if _, err := pipeline.ParseFormat(c.Sinks.Stdout.Format); err != nil {
+		return fmt.Errorf("invalid stdout sink configuration: %w", err)
+	}
+
+	if _, err := pipeline.ParseFormat(c.Sinks.File.Format); err != nil {
+		return fmt.Errorf("invalid file sink configuration: %w", err)
+	}
+
+	if _, err := NewAuthenticator(c); err != nil {
+		return fmt.Errorf("invalid auth configuration: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i, target := range c.Forward {
+		if _, err := forwardOptions(target); err != nil {
+			return fmt.Errorf("invalid forward configuration #%d: %w", i+1, err)
+		}
+
+		name := forwardTargetName(target)
+		if names[name] {
+			return fmt.Errorf("invalid forward configuration #%d: duplicate name %q", i+1, name)
+		}
+		names[name] = true
+	}
+
+	if err := validateScrapeConfigs(c); err != nil {
+		return err
+	}
+
+	if c.TLS.ServerCertificatePath != "" {
+		if _, err := createTLSConfigurationFrom(c); err != nil {
+			return fmt.Errorf("invalid tls configuration: %w", err)
+		}
+	}
+
+	return nil
+}
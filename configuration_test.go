@@ -0,0 +1,177 @@
+package promsentry
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigurationFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestParseConfigurationFormats(t *testing.T) {
+	tests := map[string]string{
+		"config.json": `{"listen_address": "0.0.0.0:3000", "transport": {"queue": {"max_age": "1h"}}}`,
+		"config.yaml": "listen_address: 0.0.0.0:3000\ntransport:\n  queue:\n    max_age: 1h\n",
+		"config.YML":  "listen_address: 0.0.0.0:3000\ntransport:\n  queue:\n    max_age: 1h\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			configuration, err := ParseConfiguration(writeConfigurationFile(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if configuration.ListenAddress != "0.0.0.0:3000" {
+				t.Errorf("unexpected listen address %q", configuration.ListenAddress)
+			}
+			if time.Duration(configuration.Transport.Queue.MaxAge) != time.Hour {
+				t.Errorf("unexpected max age %v", configuration.Transport.Queue.MaxAge)
+			}
+		})
+	}
+}
+
+func TestParseConfigurationEmptyFile(t *testing.T) {
+	for _, name := range []string{"config.json", "config.yaml"} {
+		if _, err := ParseConfiguration(writeConfigurationFile(t, name, "")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestParseConfigurationUnsupportedFormat(t *testing.T) {
+	_, err := ParseConfiguration(writeConfigurationFile(t, "config.toml", ""))
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expected an unsupported format error, got %v", err)
+	}
+}
+
+func TestParseConfigurationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "config.json",
+			content: "{\n  \"listen_address\": \"0.0.0.0:3000\",\n  \"sentry_dns\": \"typo\"\n}",
+			want:    `line 3, column 3: json: unknown field "sentry_dns"`,
+		},
+		{
+			name:    "config.json",
+			content: "{\n  \"listen_address\": 3000\n}",
+			want:    "line 2, column",
+		},
+		{
+			name:    "config.json",
+			content: "{\n  \"listen_address\": \"0.0.0.0:3000\"\n  \"debug\": true\n}",
+			want:    "line 3, column 3: invalid character",
+		},
+		{
+			name:    "config.yaml",
+			content: "listen_address: 0.0.0.0:3000\nsentry_dns: typo\n",
+			want:    "line 2: field sentry_dns not found",
+		},
+		{
+			name:    "config.yaml",
+			content: "transport:\n  queue:\n    max_age: forever\n",
+			want:    "line 3, column 14: time: invalid duration",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseConfiguration(writeConfigurationFile(t, tt.name, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestParseConfigurationExpandsEnvironmentVariables(t *testing.T) {
+	t.Setenv("PROMSENTRY_TEST_DSN", "https://key@example.com/1")
+
+	filePath := writeConfigurationFile(t, "config.yaml", "sentry_dsn: ${PROMSENTRY_TEST_DSN}\nlisten_address: $HOST${PROMSENTRY_TEST_UNSET}\n")
+	configuration, err := ParseConfiguration(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if configuration.SentryDsn != "https://key@example.com/1" {
+		t.Errorf("unexpected DSN %q", configuration.SentryDsn)
+	}
+	if configuration.ListenAddress != "$HOST" {
+		t.Errorf("unexpected listen address %q", configuration.ListenAddress)
+	}
+}
+
+func TestParseConfigurationReadsFileEnvironmentVariables(t *testing.T) {
+	secret := writeConfigurationFile(t, "dsn", "https://key@example.com/1\n")
+	t.Setenv("SENTRY_DSN_FILE", secret)
+
+	configuration, err := ParseConfiguration("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.SentryDsn != "https://key@example.com/1" {
+		t.Errorf("unexpected DSN %q", configuration.SentryDsn)
+	}
+
+	t.Setenv("SENTRY_DSN", "https://other@example.com/1")
+	configuration, err = ParseConfiguration("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.SentryDsn != "https://other@example.com/1" {
+		t.Errorf("expected SENTRY_DSN to take precedence over SENTRY_DSN_FILE, got %q", configuration.SentryDsn)
+	}
+}
+
+func TestParseConfigurationMissingFileEnvironmentVariable(t *testing.T) {
+	t.Setenv("SENTRY_DSN_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	if _, err := ParseConfiguration(""); err == nil || !strings.Contains(err.Error(), "SENTRY_DSN_FILE") {
+		t.Errorf("expected an error about SENTRY_DSN_FILE, got %v", err)
+	}
+}
+
+func TestParseConfigurationInvalidEnvironmentVariables(t *testing.T) {
+	tests := map[string]string{
+		"LIMITS_MAX_REQUEST_BYTES": "16MiB",
+		"LIMITS_READ_TIMEOUT":      "30",
+		"TRANSPORT_RETRY_JITTER":   "high",
+		"DRY_RUN":                  "maybe",
+	}
+
+	for key, value := range tests {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+
+			if _, err := ParseConfiguration(""); err == nil || !strings.Contains(err.Error(), "invalid "+key) {
+				t.Errorf("expected an error about %s, got %v", key, err)
+			}
+		})
+	}
+}
+
+func TestValidateAdminListenAddress(t *testing.T) {
+	configuration := &Configuration{ListenAddress: "0.0.0.0:3000", AdminListenAddress: "0.0.0.0:3000"}
+	err := configuration.Validate()
+	if err == nil || !strings.Contains(err.Error(), "must differ from listen_address") {
+		t.Errorf("expected an admin_listen_address error, got %v", err)
+	}
+
+	configuration.AdminListenAddress = "127.0.0.1:3001"
+	if err := configuration.Validate(); err != nil {
+		t.Errorf("expected a distinct admin_listen_address to be accepted, got %v", err)
+	}
+}
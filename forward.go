@@ -0,0 +1,166 @@
+package promsentry
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/aldy505/promsentry/forward"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+// CreateForwarders creates a Forwarder for every forward target of the configuration. In dry run, nothing is
+// forwarded and no forwarder is created. The forwarders log through the logger, slog.Default() when it is nil, and
+// must be closed once the server is shut down.
+func CreateForwarders(configuration *Configuration, logger *slog.Logger) ([]*forward.Forwarder, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if configuration.DryRun {
+		if len(configuration.Forward) > 0 {
+			logger.Warn("Dry run, the forward targets are skipped")
+		}
+		return nil, nil
+	}
+
+	var forwarders []*forward.Forwarder
+	for _, target := range configuration.Forward {
+		options, err := forwardOptions(target)
+		if err != nil {
+			return nil, err
+		}
+
+		options.Logger = logger
+
+		forwarder, err := forward.New(forwardTargetName(target), options)
+		if err != nil {
+			return nil, err
+		}
+		forwarders = append(forwarders, forwarder)
+	}
+
+	return forwarders, nil
+}
+
+// forwardTargetName returns the name of the target, or the host of its URL when it has none.
+func forwardTargetName(target ForwardTarget) string {
+	if target.Name != "" {
+		return target.Name
+	}
+
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return target.URL
+	}
+	return u.Host
+}
+
+// findForwardTarget returns the target of the given name.
+func findForwardTarget(targets []ForwardTarget, name string) (ForwardTarget, bool) {
+	for _, target := range targets {
+		if forwardTargetName(target) == name {
+			return target, true
+		}
+	}
+	return ForwardTarget{}, false
+}
+
+// appliedForwardTargets returns the forward targets in effect once the new targets are applied: the current targets,
+// with the write_relabel_configs of the new target of the same name. The other settings require a restart.
+func appliedForwardTargets(current []ForwardTarget, targets []ForwardTarget) []ForwardTarget {
+	applied := make([]ForwardTarget, 0, len(current))
+	for _, target := range current {
+		if next, ok := findForwardTarget(targets, forwardTargetName(target)); ok {
+			target.WriteRelabelConfigs = next.WriteRelabelConfigs
+		}
+		applied = append(applied, target)
+	}
+	return applied
+}
+
+func forwardOptions(target ForwardTarget) (forward.Options, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return forward.Options{}, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return forward.Options{}, fmt.Errorf("invalid url %q: the scheme must be http or https", target.URL)
+	}
+
+	options := forward.Options{
+		URL:         target.URL,
+		Headers:     target.Headers,
+		Username:    target.BasicAuth.Username,
+		Password:    target.BasicAuth.Password,
+		BearerToken: target.BearerToken,
+		Timeout:     time.Duration(target.Timeout),
+		QueueSize:   target.QueueSize,
+	}
+
+	if target.Retry.MaxAttempts != 0 || target.Retry.InitialBackoff != 0 || target.Retry.MaxBackoff != 0 {
+		retry := forward.DefaultRetryPolicy()
+		if target.Retry.MaxAttempts != 0 {
+			retry.MaxAttempts = target.Retry.MaxAttempts
+		}
+		if target.Retry.InitialBackoff != 0 {
+			retry.InitialBackoff = time.Duration(target.Retry.InitialBackoff)
+		}
+		if target.Retry.MaxBackoff != 0 {
+			retry.MaxBackoff = time.Duration(target.Retry.MaxBackoff)
+		}
+		options.Retry = &retry
+	}
+
+	for i, c := range target.WriteRelabelConfigs {
+		relabelConfig, err := c.relabelConfig()
+		if err != nil {
+			return forward.Options{}, fmt.Errorf("invalid write_relabel_configs #%d: %w", i+1, err)
+		}
+		options.RelabelConfigs = append(options.RelabelConfigs, relabelConfig)
+	}
+
+	return options, nil
+}
+
+// relabelConfig converts the rule into its Prometheus counterpart. It goes through YAML, so that the defaults and
+// the validation of Prometheus apply.
+func (c RelabelConfig) relabelConfig() (*relabel.Config, error) {
+	fields := make(map[string]interface{})
+	if len(c.SourceLabels) > 0 {
+		fields["source_labels"] = c.SourceLabels
+	}
+	if c.Separator != "" {
+		fields["separator"] = c.Separator
+	}
+	if c.Regex != "" {
+		fields["regex"] = c.Regex
+	}
+	if c.Modulus != 0 {
+		fields["modulus"] = c.Modulus
+	}
+	if c.TargetLabel != "" {
+		fields["target_label"] = c.TargetLabel
+	}
+	if c.Replacement != "" {
+		fields["replacement"] = c.Replacement
+	}
+	if c.Action != "" {
+		fields["action"] = c.Action
+	} else {
+		fields["action"] = string(relabel.Replace)
+	}
+
+	content, err := yaml.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var relabelConfig relabel.Config
+	if err := yaml.Unmarshal(content, &relabelConfig); err != nil {
+		return nil, err
+	}
+	return &relabelConfig, nil
+}
@@ -0,0 +1,403 @@
+// Package forward sends copies of the remote write requests received by
+// promsentry to other Prometheus remote write backends, like Mimir, Thanos or
+// another Prometheus. Requests are queued and sent in the background, so that
+// a slow or unavailable backend never delays the conversion for Sentry.
+package forward
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aldy505/promsentry/telemetry"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	defaultTimeout   = time.Second * 30
+	defaultQueueSize = 1000
+)
+
+// RetryPolicy configures how failed requests are retried. Connection errors,
+// server errors (5xx) and rate limits (429) are retried, other errors are
+// not, as sending the same request again would fail the same way.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one. Values below 2
+	// disable retries.
+	MaxAttempts int
+	// Delay before the first retry, doubled on every attempt.
+	InitialBackoff time.Duration
+	// Upper bound of the delay between two attempts.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns the RetryPolicy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second * 30,
+	}
+}
+
+// backoff returns the delay to wait after the given number of attempts, with
+// up to 20% of jitter.
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(2, float64(attempts-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d += d * 0.2 * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
+
+// Options configures a Forwarder.
+type Options struct {
+	// URL of the remote write endpoint of the backend.
+	URL string
+	// Headers are added to every request, like X-Scope-OrgID for Mimir.
+	Headers map[string]string
+	// Username and Password enable basic authentication when Username is set.
+	Username string
+	Password string
+	// BearerToken is sent in the Authorization header when it is set.
+	BearerToken string
+	// Timeout of a single attempt. Defaults to 30 seconds.
+	Timeout time.Duration
+	// QueueSize is the number of requests that wait to be sent. Requests
+	// received while the queue is full are dropped. Defaults to 1000.
+	QueueSize int
+	// Retry configures the retries. Defaults to DefaultRetryPolicy.
+	Retry *RetryPolicy
+	// RelabelConfigs are applied to the time series before they are sent.
+	// The request is sent as it was received when there are none.
+	RelabelConfigs []*relabel.Config
+	// Client sends the requests. Defaults to a client using
+	// http.DefaultTransport.
+	Client *http.Client
+	// Logger receives the forwarding errors. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Forwarder sends copies of remote write requests to a backend.
+type Forwarder struct {
+	name    string
+	options Options
+	retry   RetryPolicy
+	client  *http.Client
+	logger  *slog.Logger
+
+	queue chan request
+	// ctx is canceled when the queue must be abandoned.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	// relabelConfigs replace the RelabelConfigs of the options, see
+	// SetRelabelConfigs.
+	relabelConfigs []*relabel.Config
+}
+
+// request is a remote write request waiting to be forwarded.
+type request struct {
+	compressed   []byte
+	writeRequest *prompb.WriteRequest
+}
+
+// New creates a Forwarder and starts sending in the background. The name
+// identifies the backend in the logs and metrics.
+func New(name string, options Options) (*Forwarder, error) {
+	if options.URL == "" {
+		return nil, errors.New("forwarding url is required")
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaultQueueSize
+	}
+
+	retry := DefaultRetryPolicy()
+	if options.Retry != nil {
+		retry = *options.Retry
+	}
+
+	client := options.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forwarder{
+		name:    name,
+		options: options,
+		retry:   retry,
+		client:  client,
+		logger:  logger.With("target", name),
+		queue:   make(chan request, options.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+
+		relabelConfigs: options.RelabelConfigs,
+	}
+	go f.worker()
+
+	return f, nil
+}
+
+// Name returns the name of the backend.
+func (f *Forwarder) Name() string {
+	return f.name
+}
+
+// SetRelabelConfigs replaces the relabel configurations. They apply to the
+// requests that are sent afterwards, including the queued ones.
+func (f *Forwarder) SetRelabelConfigs(relabelConfigs []*relabel.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.relabelConfigs = relabelConfigs
+}
+
+// Forward queues a remote write request. compressed is the snappy encoded
+// request as it was received, and writeRequest its decoded content, which
+// must not be modified afterwards. It never blocks, and returns false if the
+// request was dropped because the queue is full or the Forwarder is closed.
+func (f *Forwarder) Forward(compressed []byte, writeRequest *prompb.WriteRequest) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		telemetry.ForwardRequests.WithLabelValues(f.name, "dropped").Inc()
+		telemetry.Drops.Record("forward", "closed", 1)
+		return false
+	}
+
+	select {
+	case f.queue <- request{compressed: compressed, writeRequest: writeRequest}:
+		telemetry.ForwardQueueLength.WithLabelValues(f.name).Set(float64(len(f.queue)))
+		return true
+	default:
+		telemetry.ForwardRequests.WithLabelValues(f.name, "dropped").Inc()
+		telemetry.Drops.Record("forward", "queue_full", 1)
+		return false
+	}
+}
+
+// Close stops accepting requests and waits for the queued ones to be sent,
+// until the context is done. The requests that could not be sent in time are
+// dropped.
+func (f *Forwarder) Close(ctx context.Context) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.queue)
+	f.mu.Unlock()
+
+	defer f.cancel()
+
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	f.cancel()
+	<-f.done
+
+	dropped := len(f.queue)
+	for range f.queue {
+		telemetry.ForwardRequests.WithLabelValues(f.name, "dropped").Inc()
+	}
+	if dropped > 0 {
+		telemetry.Drops.Record("forward", "shutdown", dropped)
+	}
+	return fmt.Errorf("forwarding to %s: %d requests were not sent in time", f.name, dropped)
+}
+
+func (f *Forwarder) worker() {
+	defer close(f.done)
+
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case r, ok := <-f.queue:
+			if !ok {
+				return
+			}
+			telemetry.ForwardQueueLength.WithLabelValues(f.name).Set(float64(len(f.queue)))
+
+			if err := f.send(r); err != nil {
+				f.logger.Error("Unable to forward a remote write request", "error", err)
+				telemetry.ForwardRequests.WithLabelValues(f.name, "failure").Inc()
+				continue
+			}
+			telemetry.ForwardRequests.WithLabelValues(f.name, "success").Inc()
+		}
+	}
+}
+
+// send sends the request, retrying it according to the retry policy.
+func (f *Forwarder) send(r request) error {
+	f.mu.RLock()
+	relabelConfigs := f.relabelConfigs
+	f.mu.RUnlock()
+
+	body := r.compressed
+	if len(relabelConfigs) > 0 {
+		var err error
+		body, err = relabelRequest(r.writeRequest, relabelConfigs)
+		if err != nil {
+			return err
+		}
+		if body == nil {
+			// Every time series was dropped.
+			return nil
+		}
+	}
+
+	for attempts := 1; ; attempts++ {
+		retryAfter, err := f.attempt(body)
+		if err == nil {
+			return nil
+		}
+
+		var permanent permanentError
+		if errors.As(err, &permanent) || attempts >= f.retry.MaxAttempts {
+			return err
+		}
+
+		delay := f.retry.backoff(attempts)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-f.ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		telemetry.ForwardRetries.WithLabelValues(f.name).Inc()
+	}
+}
+
+// permanentError is a failure that a retry wouldn't fix.
+type permanentError struct {
+	error
+}
+
+// attempt sends the body once. It returns the delay the backend asked to
+// wait for before retrying, if any.
+func (f *Forwarder) attempt(body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(f.ctx, f.options.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, permanentError{err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "promsentry")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for key, value := range f.options.Headers {
+		req.Header.Set(key, value)
+	}
+	if f.options.Username != "" {
+		req.SetBasicAuth(f.options.Username, f.options.Password)
+	}
+	if f.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+f.options.BearerToken)
+	}
+
+	response, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return 0, nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 256))
+	err = fmt.Errorf("server responded with %s: %s", response.Status, bytes.TrimSpace(message))
+	if response.StatusCode/100 == 5 {
+		return 0, err
+	}
+	if response.StatusCode == http.StatusTooManyRequests {
+		var retryAfter time.Duration
+		if seconds, parseErr := strconv.Atoi(response.Header.Get("Retry-After")); parseErr == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, err
+	}
+	return 0, permanentError{err}
+}
+
+// relabelRequest applies the relabel configurations to the time series of the
+// request, and returns the snappy encoded result. It returns nil if every time
+// series was dropped.
+func relabelRequest(writeRequest *prompb.WriteRequest, relabelConfigs []*relabel.Config) ([]byte, error) {
+	relabeled := &prompb.WriteRequest{
+		Metadata: writeRequest.GetMetadata(),
+	}
+
+	for _, series := range writeRequest.GetTimeseries() {
+		builder := labels.NewScratchBuilder(len(series.GetLabels()))
+		for _, l := range series.GetLabels() {
+			builder.Add(l.GetName(), l.GetValue())
+		}
+		builder.Sort()
+
+		lbls, keep := relabel.Process(builder.Labels(), relabelConfigs...)
+		if !keep {
+			continue
+		}
+
+		protoLabels := make([]prompb.Label, 0, lbls.Len())
+		lbls.Range(func(l labels.Label) {
+			protoLabels = append(protoLabels, prompb.Label{Name: l.Name, Value: l.Value})
+		})
+
+		series.Labels = protoLabels
+		relabeled.Timeseries = append(relabeled.Timeseries, series)
+	}
+
+	if len(relabeled.Timeseries) == 0 {
+		return nil, nil
+	}
+
+	data, err := relabeled.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
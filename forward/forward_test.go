@@ -0,0 +1,273 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func testWriteRequest(t *testing.T) ([]byte, *prompb.WriteRequest) {
+	t.Helper()
+
+	writeRequest := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1700000000000}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "debug"}},
+				Samples: []prompb.Sample{{Value: 0, Timestamp: 1700000000000}},
+			},
+		},
+	}
+
+	data, err := writeRequest.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snappy.Encode(nil, data), writeRequest
+}
+
+// recordingServer keeps the bodies of the requests it receives, and responds
+// with the given status codes in order, then with 204.
+type recordingServer struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	headers  []http.Header
+	statuses []int
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bodies = append(s.bodies, body)
+	s.headers = append(s.headers, r.Header.Clone())
+	if len(s.statuses) > 0 {
+		w.WriteHeader(s.statuses[0])
+		s.statuses = s.statuses[1:]
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestForwardRawRequest(t *testing.T) {
+	recorder := &recordingServer{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	forwarder, err := New("test", Options{
+		URL:         server.URL,
+		Headers:     map[string]string{"X-Scope-OrgID": "tenant"},
+		BearerToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed, writeRequest := testWriteRequest(t)
+	if !forwarder.Forward(compressed, writeRequest) {
+		t.Fatal("expected the request to be queued")
+	}
+	if err := forwarder.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	if len(recorder.bodies) != 1 {
+		t.Fatalf("expected a single request, got %d", len(recorder.bodies))
+	}
+	if !bytes.Equal(recorder.bodies[0], compressed) {
+		t.Error("expected the request to be forwarded as it was received")
+	}
+	for key, want := range map[string]string{
+		"Content-Encoding": "snappy",
+		"X-Scope-OrgID":    "tenant",
+		"Authorization":    "Bearer secret",
+	} {
+		if got := recorder.headers[0].Get(key); got != want {
+			t.Errorf("unexpected %s header %q", key, got)
+		}
+	}
+}
+
+func TestForwardRetries(t *testing.T) {
+	recorder := &recordingServer{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	forwarder, err := New("test", Options{
+		URL:   server.URL,
+		Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwarder.Forward(testWriteRequest(t))
+	if err := forwarder.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.bodies) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(recorder.bodies))
+	}
+}
+
+func TestForwardDoesNotRetryClientErrors(t *testing.T) {
+	recorder := &recordingServer{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	forwarder, err := New("test", Options{
+		URL:   server.URL,
+		Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwarder.Forward(testWriteRequest(t))
+	if err := forwarder.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.bodies) != 1 {
+		t.Errorf("expected a single attempt, got %d", len(recorder.bodies))
+	}
+}
+
+func TestForwardRelabel(t *testing.T) {
+	recorder := &recordingServer{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	forwarder, err := New("test", Options{
+		URL: server.URL,
+		RelabelConfigs: []*relabel.Config{
+			{
+				SourceLabels: []model.LabelName{"job"},
+				Regex:        relabel.MustNewRegexp("debug"),
+				Action:       relabel.Drop,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwarder.Forward(testWriteRequest(t))
+	if err := forwarder.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.bodies) != 1 {
+		t.Fatalf("expected a single request, got %d", len(recorder.bodies))
+	}
+
+	data, err := snappy.Decode(nil, recorder.bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got prompb.WriteRequest
+	if err := got.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1700000000000}},
+	}}
+	if diff := cmp.Diff(want, got.Timeseries); diff != "" {
+		t.Errorf("unexpected time series (-want +got):\n%s", diff)
+	}
+}
+
+func TestForwardSetRelabelConfigs(t *testing.T) {
+	recorder := &recordingServer{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	forwarder, err := New("test", Options{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarder.SetRelabelConfigs([]*relabel.Config{{
+		SourceLabels: []model.LabelName{"job"},
+		Regex:        relabel.MustNewRegexp("debug"),
+		Action:       relabel.Drop,
+	}})
+
+	forwarder.Forward(testWriteRequest(t))
+	if err := forwarder.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	data, err := snappy.Decode(nil, recorder.bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got prompb.WriteRequest
+	if err := got.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Timeseries) != 1 {
+		t.Errorf("expected the replaced relabel configurations to drop a time series, got %d", len(got.Timeseries))
+	}
+}
+
+func TestForwardDropsWhenQueueIsFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	forwarder, err := New("test", Options{URL: server.URL, QueueSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed, writeRequest := testWriteRequest(t)
+	queued := 0
+	for i := 0; i < 5; i++ {
+		if forwarder.Forward(compressed, writeRequest) {
+			queued++
+		}
+	}
+	// One request is being sent, one waits in the queue.
+	if queued > 2 {
+		t.Errorf("expected at most 2 requests to be queued, got %d", queued)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := forwarder.Close(ctx); err == nil {
+		t.Error("expected an error for the requests that were not sent in time")
+	}
+}
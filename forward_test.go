@@ -0,0 +1,64 @@
+package promsentry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+func TestRelabelConfig(t *testing.T) {
+	c, err := RelabelConfig{SourceLabels: []string{"job"}, Regex: "debug.*", Action: "drop"}.relabelConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Action != relabel.Drop || c.Regex.String() != "debug.*" || c.Separator != ";" {
+		t.Errorf("unexpected relabel config %+v", c)
+	}
+
+	// The action defaults to replace, like in Prometheus.
+	c, err = RelabelConfig{TargetLabel: "source", Replacement: "promsentry"}.relabelConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Action != relabel.Replace || c.Regex.String() != "(.*)" {
+		t.Errorf("unexpected relabel config %+v", c)
+	}
+
+	_, err = RelabelConfig{Action: "hashmod", TargetLabel: "shard"}.relabelConfig()
+	if err == nil || !strings.Contains(err.Error(), "modulus") {
+		t.Errorf("expected a modulus error, got %v", err)
+	}
+}
+
+func TestValidateForward(t *testing.T) {
+	configuration := &Configuration{
+		Forward: []ForwardTarget{
+			{URL: "http://mimir:9009/api/v1/push"},
+			{URL: "http://mimir:9009/api/v1/push"},
+		},
+	}
+	err := configuration.Validate()
+	if err == nil || !strings.Contains(err.Error(), "duplicate name") {
+		t.Errorf("expected a duplicate name error, got %v", err)
+	}
+
+	configuration.Forward = []ForwardTarget{{URL: "mimir:9009"}}
+	if err := configuration.Validate(); err == nil {
+		t.Error("expected an invalid url error")
+	}
+}
+
+func TestCreateForwardersDryRun(t *testing.T) {
+	configuration := &Configuration{
+		DryRun:  true,
+		Forward: []ForwardTarget{{URL: "http://mimir:9009/api/v1/push"}},
+	}
+	forwarders, err := CreateForwarders(configuration, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forwarders) != 0 {
+		t.Errorf("expected no forwarder in dry run, got %d", len(forwarders))
+	}
+}
@@ -0,0 +1,192 @@
+package promsentry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry"
+)
+
+const defaultMaxQueueUsage = 0.9
+
+// HealthOptions configures a Health. Every field is optional.
+type HealthOptions struct {
+	// Hub is the hub whose transport is checked. Defaults to the current hub.
+	Hub *sentry.Hub
+	// Configuration returns the configuration in effect, like Reloader.Configuration. The server is not ready while it
+	// returns nil. Without it, the configuration is not checked and the transport is always checked.
+	Configuration func() *Configuration
+	// MaxQueueUsage is the fraction of the transport queue above which the server is not ready. Defaults to 0.9.
+	MaxQueueUsage float64
+}
+
+// Health reports whether promsentry is alive and ready to receive remote write requests, on the /-/healthy,
+// /-/ready and /api/v1/status endpoints.
+type Health struct {
+	options HealthOptions
+	started time.Time
+}
+
+// HealthCheck is the outcome of one of the readiness checks.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+// Status is the state of promsentry, as returned by /api/v1/status.
+type Status struct {
+	Ready  bool          `json:"ready"`
+	Checks []HealthCheck `json:"checks"`
+	// StartedAt is when the server was created.
+	StartedAt time.Time `json:"started_at"`
+	// ConfigurationHash is the SHA-256 of the configuration in effect, to tell whether a reload was applied.
+	ConfigurationHash string `json:"configuration_hash,omitempty"`
+	// Transport is the status of the Sentry transport, when it reports one.
+	Transport *sentry.TransportStatus `json:"transport,omitempty"`
+}
+
+// NewHealth creates a Health.
+func NewHealth(options HealthOptions) *Health {
+	if options.MaxQueueUsage <= 0 {
+		options.MaxQueueUsage = defaultMaxQueueUsage
+	}
+
+	return &Health{
+		options: options,
+		started: time.Now(),
+	}
+}
+
+// Status checks the configuration and the Sentry transport. The server is ready when a configuration is loaded, and,
+// when metrics are sent to Sentry, the transport is configured, its queue is below MaxQueueUsage and the metrics are
+// not rate limited.
+func (h *Health) Status() Status {
+	status := Status{StartedAt: h.started}
+
+	var configuration *Configuration
+	if h.options.Configuration != nil {
+		configuration = h.options.Configuration()
+		check := HealthCheck{Name: "configuration", Healthy: configuration != nil}
+		if configuration == nil {
+			check.Message = "no configuration is loaded"
+		} else {
+			status.ConfigurationHash = configurationHash(configuration)
+		}
+		status.Checks = append(status.Checks, check)
+	}
+
+	hub := h.options.Hub
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+
+	// Without a configuration, the transport is checked whenever it reports its status.
+	required := configuration != nil && (configuration.SentryDsn != "" || configuration.DryRun) &&
+		!configuration.Sinks.Sentry.Disabled
+	checked := h.options.Configuration == nil || required
+
+	var reporter sentry.StatusReporter
+	if client := hub.Client(); client != nil {
+		reporter, _ = client.Transport.(sentry.StatusReporter)
+	}
+
+	switch {
+	case reporter != nil:
+		transport := reporter.Status()
+		status.Transport = &transport
+		if checked {
+			status.Checks = append(status.Checks, h.transportChecks(transport)...)
+		}
+	case required:
+		status.Checks = append(status.Checks, HealthCheck{
+			Name:    "transport",
+			Message: "no Sentry transport is configured",
+		})
+	}
+
+	status.Ready = true
+	for _, check := range status.Checks {
+		status.Ready = status.Ready && check.Healthy
+	}
+	return status
+}
+
+func (h *Health) transportChecks(transport sentry.TransportStatus) []HealthCheck {
+	configured := HealthCheck{Name: "transport", Healthy: transport.Configured}
+	if !transport.Configured {
+		configured.Message = "the Sentry transport is not configured, check sentry_dsn"
+	}
+
+	queue := HealthCheck{Name: "queue", Healthy: true}
+	var usage float64
+	switch {
+	case transport.QueueCapacity > 0:
+		usage = float64(transport.QueueLength) / float64(transport.QueueCapacity)
+	case transport.QueueMaxBytes > 0:
+		usage = float64(transport.QueueBytes) / float64(transport.QueueMaxBytes)
+	}
+	if usage > h.options.MaxQueueUsage {
+		queue.Healthy = false
+		queue.Message = fmt.Sprintf("the transport queue is %.0f%% full", usage*100)
+	}
+
+	rateLimit := HealthCheck{Name: "rate_limit", Healthy: !transport.RateLimited("statsd")}
+	if !rateLimit.Healthy {
+		deadline := transport.RateLimits["statsd"]
+		if all, ok := transport.RateLimits["all"]; ok && all.After(deadline) {
+			deadline = all
+		}
+		rateLimit.Message = fmt.Sprintf("Sentry rate limits metrics until %s", deadline.Format(time.RFC3339))
+	}
+
+	return []HealthCheck{configured, queue, rateLimit}
+}
+
+// configurationHash returns the SHA-256 of the JSON encoding of the configuration.
+func configurationHash(configuration *Configuration) string {
+	content, err := json.Marshal(configuration)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// registerProbes adds the liveness and readiness endpoints to the router.
+func (h *Health) registerProbes(router *http.ServeMux) {
+	router.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Healthy"))
+	})
+
+	router.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		status := h.Status()
+		if status.Ready {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Ready"))
+			return
+		}
+
+		var failures []string
+		for _, check := range status.Checks {
+			if !check.Healthy {
+				failures = append(failures, fmt.Sprintf("%s: %s", check.Name, check.Message))
+			}
+		}
+		http.Error(w, "Not ready\n"+strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+	})
+}
+
+// registerStatus adds the JSON status endpoint to the router.
+func (h *Health) registerStatus(router *http.ServeMux) {
+	router.HandleFunc("/api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(h.Status())
+	})
+}
@@ -0,0 +1,158 @@
+package promsentry
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry"
+)
+
+// statusTransport is a transport that reports a fixed status.
+type statusTransport struct {
+	status sentry.TransportStatus
+}
+
+func (t *statusTransport) Flush(timeout time.Duration) bool       { return true }
+func (t *statusTransport) Configure(options sentry.ClientOptions) {}
+func (t *statusTransport) SendEvent(event *sentry.Event)          {}
+func (t *statusTransport) Status() sentry.TransportStatus         { return t.status }
+
+// healthTestServer starts the remote write server and the admin server, with a Health that checks the transport.
+func healthTestServer(t *testing.T, transport *statusTransport, configuration *Configuration) (*httptest.Server, *httptest.Server) {
+	t.Helper()
+
+	client, err := sentry.NewClient(sentry.ClientOptions{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := NewHealth(HealthOptions{
+		Hub:           sentry.NewHub(client, sentry.NewScope()),
+		Configuration: func() *Configuration { return configuration },
+	})
+
+	server, err := NewServer(ServerOptions{Sink: discardSink{}, Health: health})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminServer, err := NewAdminServer(AdminServerOptions{Health: health})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testServer := httptest.NewServer(server.Handler)
+	t.Cleanup(testServer.Close)
+	adminTestServer := httptest.NewServer(adminServer.Handler)
+	t.Cleanup(adminTestServer.Close)
+	return testServer, adminTestServer
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	transport := &statusTransport{status: sentry.TransportStatus{
+		Transport:     "http",
+		Configured:    true,
+		QueueLength:   10,
+		QueueCapacity: 100,
+	}}
+	configuration := &Configuration{SentryDsn: "https://public@sentry.example.com/1"}
+	server, adminServer := healthTestServer(t, transport, configuration)
+
+	for _, path := range []string{"/-/healthy", "/-/ready"} {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("expected %s to return 200, got %d", path, response.StatusCode)
+		}
+	}
+
+	response, err := http.Get(adminServer.URL + "/api/v1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var status Status
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Ready || status.ConfigurationHash != configurationHash(configuration) || status.Transport == nil ||
+		status.Transport.QueueLength != 10 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestHealthNotReady(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        sentry.TransportStatus
+		configuration *Configuration
+		failure       string
+	}{
+		{
+			name:    "no configuration",
+			status:  sentry.TransportStatus{Configured: true},
+			failure: "configuration: no configuration is loaded",
+		},
+		{
+			name:          "transport not configured",
+			status:        sentry.TransportStatus{},
+			configuration: &Configuration{SentryDsn: "https://public@sentry.example.com/1"},
+			failure:       "transport: the Sentry transport is not configured",
+		},
+		{
+			name:          "queue full",
+			status:        sentry.TransportStatus{Configured: true, QueueBytes: 95, QueueMaxBytes: 100},
+			configuration: &Configuration{SentryDsn: "https://public@sentry.example.com/1"},
+			failure:       "queue: the transport queue is 95% full",
+		},
+		{
+			name: "rate limited",
+			status: sentry.TransportStatus{
+				Configured: true,
+				RateLimits: map[string]time.Time{"statsd": time.Now().Add(time.Minute)},
+			},
+			configuration: &Configuration{SentryDsn: "https://public@sentry.example.com/1"},
+			failure:       "rate_limit: Sentry rate limits metrics until",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := healthTestServer(t, &statusTransport{status: test.status}, test.configuration)
+
+			response, err := http.Get(server.URL + "/-/ready")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), test.failure) {
+				t.Errorf("expected a 503 with %q, got %d %q", test.failure, response.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestHealthIgnoresTransportWithoutSentry(t *testing.T) {
+	configuration := &Configuration{}
+	configuration.Sinks.Stdout.Enabled = true
+	health := NewHealth(HealthOptions{
+		Hub:           sentry.NewHub(nil, sentry.NewScope()),
+		Configuration: func() *Configuration { return configuration },
+	})
+
+	if status := health.Status(); !status.Ready || len(status.Checks) != 1 {
+		t.Errorf("expected only the configuration to be checked, got %+v", status)
+	}
+}
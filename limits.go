@@ -0,0 +1,271 @@
+package promsentry
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aldy505/promsentry/telemetry"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxRequestBytes   = 16 * 1024 * 1024
+	defaultMaxDecodedBytes   = 64 * 1024 * 1024
+	defaultReadHeaderTimeout = time.Second * 10
+	defaultReadTimeout       = time.Minute
+
+	// clientLimiterIdleTimeout is how long the rate limiter of a client is kept after its last request.
+	clientLimiterIdleTimeout = time.Minute * 10
+)
+
+// NewServerLimits returns the limits of the configuration.
+func NewServerLimits(configuration *Configuration) ServerLimits {
+	limits := configuration.Limits
+	return ServerLimits{
+		MaxRequestBytes:      limits.MaxRequestBytes,
+		MaxDecodedBytes:      limits.MaxDecodedBytes,
+		MaxSeriesPerRequest:  limits.MaxSeriesPerRequest,
+		MaxSamplesPerRequest: limits.MaxSamplesPerRequest,
+		RateLimit:            limits.RateLimit,
+		RateLimitBurst:       limits.RateLimitBurst,
+		ReadHeaderTimeout:    time.Duration(limits.ReadHeaderTimeout),
+		ReadTimeout:          time.Duration(limits.ReadTimeout),
+		WriteTimeout:         time.Duration(limits.WriteTimeout),
+		IdleTimeout:          time.Duration(limits.IdleTimeout),
+	}
+}
+
+// RequestLimiter enforces the size, series, samples and rate limits of the remote write requests. The limits can be
+// replaced while the server runs.
+type RequestLimiter struct {
+	limits atomic.Pointer[requestLimits]
+}
+
+// requestLimits holds the limits of a RequestLimiter, which are replaced as a whole.
+type requestLimits struct {
+	limits  ServerLimits
+	limiter *clientLimiter
+}
+
+// NewRequestLimiter creates a RequestLimiter with the limits. The timeouts don't apply to requests, they are ignored.
+func NewRequestLimiter(limits ServerLimits) *RequestLimiter {
+	l := &RequestLimiter{}
+	l.SetLimits(limits)
+	return l
+}
+
+// SetLimits replaces the limits. The requests in progress keep the previous limits. While the rate limit stays
+// enabled, the clients keep the tokens they have left.
+func (l *RequestLimiter) SetLimits(limits ServerLimits) {
+	var limiter *clientLimiter
+	if limits.RateLimit > 0 {
+		if previous := l.limits.Load(); previous != nil && previous.limiter != nil {
+			limiter = previous.limiter
+			limiter.set(limits.RateLimit, limits.RateLimitBurst)
+		} else {
+			limiter = newClientLimiter(limits.RateLimit, limits.RateLimitBurst)
+		}
+	}
+
+	l.limits.Store(&requestLimits{limits: limits, limiter: limiter})
+}
+
+// current returns the limits in effect.
+func (l *RequestLimiter) current() *requestLimits {
+	return l.limits.Load()
+}
+
+// limitError is a request that exceeds a limit. It is answered with its status code.
+type limitError struct {
+	status  int
+	reason  string
+	message string
+	// retryAfter is how long the client should wait before sending the request again.
+	retryAfter time.Duration
+}
+
+func (e *limitError) Error() string {
+	return e.message
+}
+
+// withDefault returns the value, or the default when it is zero. Negative values disable the limit.
+func withDefault[T int | int64 | time.Duration](value T, defaultValue T) T {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// decodeWriteRequest decodes a snappy compressed remote write request, after checking that it doesn't exceed the
+// decoded size limit.
+func decodeWriteRequest(compressed []byte, maxDecodedBytes int64) (*prompb.WriteRequest, error) {
+	decodedLength, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxDecodedBytes > 0 && int64(decodedLength) > maxDecodedBytes {
+		return nil, &limitError{
+			status:  http.StatusRequestEntityTooLarge,
+			reason:  "decoded_too_large",
+			message: fmt.Sprintf("decompressed request body is %d bytes, the limit is %d bytes", decodedLength, maxDecodedBytes),
+		}
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	var writeRequest prompb.WriteRequest
+	if err := writeRequest.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return &writeRequest, nil
+}
+
+// checkWriteRequest checks the number of series and samples of the request. Exemplars and histograms count as
+// samples.
+func checkWriteRequest(writeRequest *prompb.WriteRequest, limits ServerLimits) error {
+	series := len(writeRequest.GetTimeseries())
+	if limits.MaxSeriesPerRequest > 0 && series > limits.MaxSeriesPerRequest {
+		return &limitError{
+			status:  http.StatusRequestEntityTooLarge,
+			reason:  "too_many_series",
+			message: fmt.Sprintf("request has %d series, the limit is %d", series, limits.MaxSeriesPerRequest),
+		}
+	}
+
+	if limits.MaxSamplesPerRequest > 0 {
+		samples := 0
+		for _, ts := range writeRequest.GetTimeseries() {
+			samples += len(ts.GetSamples()) + len(ts.GetExemplars()) + len(ts.GetHistograms())
+		}
+		if samples > limits.MaxSamplesPerRequest {
+			return &limitError{
+				status:  http.StatusRequestEntityTooLarge,
+				reason:  "too_many_samples",
+				message: fmt.Sprintf("request has %d samples, the limit is %d", samples, limits.MaxSamplesPerRequest),
+			}
+		}
+	}
+
+	return nil
+}
+
+// clientLimiter is a token bucket rate limiter for each client. Clients are identified by their authenticated
+// identity, or by their IP address.
+type clientLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	limiters  map[string]*clientRateLimiter
+	lastSweep time.Time
+}
+
+type clientRateLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newClientLimiter(requestsPerSecond float64, burst int) *clientLimiter {
+	return &clientLimiter{
+		limit:    rate.Limit(requestsPerSecond),
+		burst:    clientLimiterBurst(requestsPerSecond, burst),
+		limiters: make(map[string]*clientRateLimiter),
+	}
+}
+
+// clientLimiterBurst returns the burst, or its default of the rate limit rounded up.
+func clientLimiterBurst(requestsPerSecond float64, burst int) int {
+	if burst <= 0 {
+		return int(math.Max(1, math.Ceil(requestsPerSecond)))
+	}
+	return burst
+}
+
+// set replaces the rate limit and the burst of every client.
+func (l *clientLimiter) set(requestsPerSecond float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = rate.Limit(requestsPerSecond)
+	l.burst = clientLimiterBurst(requestsPerSecond, burst)
+	for _, limiter := range l.limiters {
+		limiter.limiter.SetLimit(l.limit)
+		limiter.limiter.SetBurst(l.burst)
+	}
+}
+
+// allow consumes a token for the client of the request. When the bucket is empty, it returns an error telling how
+// long to wait for the next token.
+func (l *clientLimiter) allow(r *http.Request) *limitError {
+	client := clientKey(r)
+	now := time.Now()
+
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > clientLimiterIdleTimeout {
+		for key, limiter := range l.limiters {
+			if now.Sub(limiter.lastSeen) > clientLimiterIdleTimeout {
+				delete(l.limiters, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	limiter, ok := l.limiters[client]
+	if !ok {
+		limiter = &clientRateLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[client] = limiter
+	}
+	limiter.lastSeen = now
+	limit := l.limit
+	l.mu.Unlock()
+
+	reservation := limiter.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	reservation.CancelAt(now)
+
+	return &limitError{
+		status:     http.StatusTooManyRequests,
+		reason:     "rate_limited",
+		message:    fmt.Sprintf("rate limit of %g requests per second exceeded for %s, retry in %s", float64(limit), client, delay.Round(time.Millisecond)),
+		retryAfter: delay,
+	}
+}
+
+// writeLimitError answers a request that exceeds a limit.
+func writeLimitError(w http.ResponseWriter, err *limitError) {
+	telemetry.WriteRequestsRejected.WithLabelValues(err.reason).Inc()
+	telemetry.Drops.Record("server", err.reason, 1)
+	if err.retryAfter > 0 {
+		// Retry-After is in whole seconds, rounded up so that the client doesn't come back too early.
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.retryAfter.Seconds()))))
+	}
+	http.Error(w, err.message, err.status)
+}
+
+// clientKey identifies the client of the request.
+func clientKey(r *http.Request) string {
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		return identity.Name
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
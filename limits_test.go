@@ -0,0 +1,129 @@
+package promsentry
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// discardSink drops the converted metrics.
+type discardSink struct{}
+
+func (discardSink) Write(ctx context.Context, metric []byte) error {
+	return nil
+}
+
+func limitsTestRequest(t *testing.T, series int) []byte {
+	t.Helper()
+
+	writeRequest := &prompb.WriteRequest{}
+	for i := 0; i < series; i++ {
+		writeRequest.Timeseries = append(writeRequest.Timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: strings.Repeat("a", i+1)}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1700000000000}, {Value: 1, Timestamp: 1700000015000}},
+		})
+	}
+
+	data, err := writeRequest.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snappy.Encode(nil, data)
+}
+
+func TestServerLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  ServerLimits
+		series  int
+		code    int
+		message string
+	}{
+		{"within limits", ServerLimits{MaxSeriesPerRequest: 2, MaxSamplesPerRequest: 4}, 2, http.StatusOK, ""},
+		{"compressed size", ServerLimits{MaxRequestBytes: 10}, 2, http.StatusRequestEntityTooLarge, "exceeds the limit of 10 bytes"},
+		{"decompressed size", ServerLimits{MaxDecodedBytes: 10}, 2, http.StatusRequestEntityTooLarge, "the limit is 10 bytes"},
+		{"series", ServerLimits{MaxSeriesPerRequest: 2}, 3, http.StatusRequestEntityTooLarge, "request has 3 series, the limit is 2"},
+		{"samples", ServerLimits{MaxSamplesPerRequest: 5}, 3, http.StatusRequestEntityTooLarge, "request has 6 samples, the limit is 5"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := NewServer(ServerOptions{Sink: discardSink{}, Limits: test.limits})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(limitsTestRequest(t, test.series)))
+			response := httptest.NewRecorder()
+			server.Handler.ServeHTTP(response, request)
+
+			if response.Code != test.code {
+				t.Errorf("expected status %d, got %d: %s", test.code, response.Code, response.Body.String())
+			}
+			if !strings.Contains(response.Body.String(), test.message) {
+				t.Errorf("expected %q in the response, got %q", test.message, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	server, err := NewServer(ServerOptions{
+		Sink:   discardSink{},
+		Limits: ServerLimits{RateLimit: 0.5, RateLimitBurst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(remoteAddr string, identity string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(limitsTestRequest(t, 1)))
+		request.RemoteAddr = remoteAddr
+		if identity != "" {
+			request = request.WithContext(ContextWithIdentity(request.Context(), Identity{Name: identity}))
+		}
+		response := httptest.NewRecorder()
+		server.Handler.ServeHTTP(response, request)
+		return response
+	}
+
+	for i := 0; i < 2; i++ {
+		if response := send("192.0.2.1:1234", ""); response.Code != http.StatusOK {
+			t.Fatalf("expected the burst to be accepted, got %d", response.Code)
+		}
+	}
+
+	response := send("192.0.2.1:5678", "")
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", response.Code)
+	}
+	if response.Header().Get("Retry-After") != "2" {
+		t.Errorf("unexpected Retry-After header %q", response.Header().Get("Retry-After"))
+	}
+
+	// Other clients have their own bucket.
+	if response := send("192.0.2.2:1234", ""); response.Code != http.StatusOK {
+		t.Errorf("expected another address to be accepted, got %d", response.Code)
+	}
+	if response := send("192.0.2.1:1234", "prometheus"); response.Code != http.StatusOK {
+		t.Errorf("expected an authenticated client to be accepted, got %d", response.Code)
+	}
+}
+
+func TestServerDefaultTimeouts(t *testing.T) {
+	server, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.ReadHeaderTimeout != time.Second*10 || server.ReadTimeout != time.Minute {
+		t.Errorf("unexpected read timeouts %s and %s", server.ReadHeaderTimeout, server.ReadTimeout)
+	}
+}
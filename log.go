@@ -0,0 +1,193 @@
+package promsentry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldy505/promsentry/telemetry"
+)
+
+const (
+	defaultLogSamplingInterval   = time.Second
+	defaultLogSamplingFirst      = 10
+	defaultLogSamplingThereafter = 100
+
+	// maxRequestIDLength is the length above which the X-Request-ID header of a request is replaced.
+	maxRequestIDLength = 128
+)
+
+// NewLogger creates the logger described by the log section of the configuration, writing to w. Records logged with
+// the context of a request carry its request ID. Repetitive warnings and errors are sampled.
+func NewLogger(configuration *Configuration, w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLogLevel(configuration.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	if configuration.Log.Level == "" && configuration.Debug {
+		level = slog.LevelDebug
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch configuration.Log.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, handlerOptions)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOptions)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", configuration.Log.Format)
+	}
+
+	handler = contextHandler{Handler: handler}
+
+	sampling := configuration.Log.Sampling
+	first := withDefault(sampling.First, defaultLogSamplingFirst)
+	if first > 0 {
+		handler = &samplingHandler{
+			Handler: handler,
+			sampler: &sampler{
+				interval:   withDefault(time.Duration(sampling.Interval), defaultLogSamplingInterval),
+				first:      first,
+				thereafter: withDefault(sampling.Thereafter, defaultLogSamplingThereafter),
+				counts:     make(map[samplingKey]int),
+			},
+		}
+	}
+
+	return slog.New(handler), nil
+}
+
+// ParseLogLevel parses a log level: debug, info, warn or error. An empty string selects info.
+func ParseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", s)
+	}
+}
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the ID of the request being handled, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// ContextWithRequestID returns a copy of the context that holds the ID of the request.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// requestIDMiddleware gives every request an ID, taken from its X-Request-ID header or generated, and sends it back
+// in the X-Request-ID header of the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLength || strings.ContainsFunc(id, func(r rune) bool { return r < ' ' || r > '~' }) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// contextHandler adds the request ID of the context to the records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// samplingHandler drops repetitive warnings and errors: in every interval, the first records with the same level and
+// message are kept, then one in every thereafter. Debug and info records are never dropped.
+type samplingHandler struct {
+	slog.Handler
+	sampler *sampler
+}
+
+type samplingKey struct {
+	level   slog.Level
+	message string
+}
+
+// sampler counts the records of the current interval. It is shared by the handlers derived from the same logger.
+type sampler struct {
+	interval   time.Duration
+	first      int
+	thereafter int
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[samplingKey]int
+}
+
+func (s *sampler) allow(record slog.Record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.start) >= s.interval {
+		s.start = now
+		clear(s.counts)
+	}
+
+	key := samplingKey{level: record.Level, message: record.Message}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelWarn && !h.sampler.allow(record) {
+		telemetry.LogRecordsSampled.WithLabelValues(strings.ToLower(record.Level.String())).Inc()
+		return nil
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), sampler: h.sampler}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), sampler: h.sampler}
+}
@@ -0,0 +1,109 @@
+package promsentry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLoggerRequestID(t *testing.T) {
+	configuration := &Configuration{}
+	configuration.Log.Format = "json"
+
+	var output bytes.Buffer
+	logger, err := NewLogger(configuration, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handled")
+	}))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+	request.Header.Set("X-Request-ID", "abc123")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	if id := response.Header().Get("X-Request-ID"); id != "abc123" {
+		t.Errorf("expected the request ID to be sent back, got %q", id)
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+		t.Fatalf("expected a JSON record, got %q: %v", output.String(), err)
+	}
+	if record["request_id"] != "abc123" {
+		t.Errorf("expected the request ID in the record, got %v", record)
+	}
+
+	// Invalid IDs are replaced.
+	request = httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+	request.Header.Set("X-Request-ID", strings.Repeat("a", maxRequestIDLength+1))
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if id := response.Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Errorf("expected a generated request ID, got %q", id)
+	}
+}
+
+func TestNewLoggerLevel(t *testing.T) {
+	configuration := &Configuration{}
+	configuration.Log.Level = "warn"
+
+	var output bytes.Buffer
+	logger, err := NewLogger(configuration, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if got := output.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "shown") {
+		t.Errorf("expected only the warning, got %q", got)
+	}
+}
+
+func TestNewLoggerSampling(t *testing.T) {
+	configuration := &Configuration{}
+	configuration.Log.Sampling.Interval = Duration(time.Hour)
+	configuration.Log.Sampling.First = 2
+	configuration.Log.Sampling.Thereafter = 3
+
+	var output bytes.Buffer
+	logger, err := NewLogger(configuration, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		logger.Error("repeated")
+		logger.Info("not sampled")
+	}
+
+	// The first 2, then the 5th and the 8th.
+	if n := strings.Count(output.String(), "msg=repeated"); n != 4 {
+		t.Errorf("expected 4 sampled errors, got %d", n)
+	}
+	if n := strings.Count(output.String(), `msg="not sampled"`); n != 8 {
+		t.Errorf("expected every info record, got %d", n)
+	}
+}
+
+func TestNewLoggerErrors(t *testing.T) {
+	configuration := &Configuration{}
+	configuration.Log.Level = "verbose"
+	if _, err := NewLogger(configuration, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "unknown log level") {
+		t.Errorf("expected an unknown log level error, got %v", err)
+	}
+
+	configuration = &Configuration{}
+	configuration.Log.Format = "xml"
+	if _, err := NewLogger(configuration, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "unknown log format") {
+		t.Errorf("expected an unknown log format error, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is the format a sink writes statsd payloads in.
+type Format string
+
+const (
+	// FormatStatsd writes the statsd lines as they are sent to Sentry.
+	FormatStatsd Format = "statsd"
+	// FormatJSONL writes one JSON object per statsd line.
+	FormatJSONL Format = "jsonl"
+)
+
+// ParseFormat parses the name of a format. An empty name selects FormatStatsd.
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case "", FormatStatsd:
+		return FormatStatsd, nil
+	case FormatJSONL:
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, use %q or %q", s, FormatStatsd, FormatJSONL)
+	}
+}
+
+// Line is a single statsd line.
+type Line struct {
+	Name      string            `json:"name"`
+	Value     string            `json:"value"`
+	Type      string            `json:"type"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+}
+
+// ParseLine parses a statsd line, in the name:value|type|#tags|Ttimestamp
+// form that the Converter produces.
+func ParseLine(s string) (Line, error) {
+	sections := strings.Split(s, "|")
+	name, value, ok := strings.Cut(sections[0], ":")
+	if !ok || name == "" || len(sections) < 2 {
+		return Line{}, fmt.Errorf("invalid statsd line %q", s)
+	}
+
+	line := Line{
+		Name:  name,
+		Value: value,
+		Type:  sections[1],
+	}
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "#"):
+			line.Tags = make(map[string]string)
+			for _, tag := range strings.Split(section[1:], ",") {
+				key, value, _ := strings.Cut(tag, ":")
+				line.Tags[key] = value
+			}
+		case strings.HasPrefix(section, "T"):
+			timestamp, err := strconv.ParseInt(section[1:], 10, 64)
+			if err != nil {
+				return Line{}, fmt.Errorf("invalid statsd line %q: %w", s, err)
+			}
+			line.Timestamp = timestamp
+		}
+	}
+
+	return line, nil
+}
+
+// String returns the statsd line, without the empty sections. Tags are sorted
+// by key.
+func (l Line) String() string {
+	var b strings.Builder
+	b.WriteString(l.Name)
+	b.WriteString(":")
+	b.WriteString(l.Value)
+	b.WriteString("|")
+	b.WriteString(l.Type)
+
+	if len(l.Tags) > 0 {
+		keys := make([]string, 0, len(l.Tags))
+		for key := range l.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b.WriteString("|#")
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(key)
+			b.WriteString(":")
+			b.WriteString(l.Tags[key])
+		}
+	}
+
+	if l.Timestamp != 0 {
+		b.WriteString("|T")
+		b.WriteString(strconv.FormatInt(l.Timestamp, 10))
+	}
+
+	return b.String()
+}
+
+// lines returns the non empty lines of a statsd payload.
+func lines(metric []byte) []string {
+	var result []string
+	for _, line := range strings.Split(string(metric), "\n") {
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// encode returns the payload in the given format, with a trailing newline.
+func encode(format Format, metric []byte) ([]byte, error) {
+	b := &bytes.Buffer{}
+	for _, s := range lines(metric) {
+		if format != FormatJSONL {
+			b.WriteString(s)
+			b.WriteString("\n")
+			continue
+		}
+
+		line, err := ParseLine(s)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// Content types of the exposition formats understood by ParseText.
+const (
+	ContentTypeText        = "text/plain; version=0.0.4"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0"
+	ContentTypeProtobuf    = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+)
+
+// ParseText parses metrics in the Prometheus text, the OpenMetrics or the
+// protobuf exposition format, depending on the content type, into one time
+// series per sample or native histogram. Samples without a timestamp are
+// timestamped with now.
+func ParseText(b []byte, contentType string, now time.Time) ([]prompb.TimeSeries, error) {
+	parser, err := textparse.New(b, contentType, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeseries []prompb.TimeSeries
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var series prompb.TimeSeries
+		timestamp := now.UnixMilli()
+		switch entry {
+		case textparse.EntrySeries:
+			_, ts, value := parser.Series()
+			if ts != nil {
+				timestamp = *ts
+			}
+			series.Samples = []prompb.Sample{{Value: value, Timestamp: timestamp}}
+		case textparse.EntryHistogram:
+			_, ts, h, fh := parser.Histogram()
+			if ts != nil {
+				timestamp = *ts
+			}
+			if h != nil {
+				series.Histograms = []prompb.Histogram{remote.HistogramToHistogramProto(timestamp, h)}
+			} else {
+				series.Histograms = []prompb.Histogram{remote.FloatHistogramToHistogramProto(timestamp, fh)}
+			}
+		default:
+			continue
+		}
+
+		var lset labels.Labels
+		parser.Metric(&lset)
+		series.Labels = labelsToProto(lset)
+
+		var e exemplar.Exemplar
+		for parser.Exemplar(&e) {
+			exemplarTimestamp := timestamp
+			if e.HasTs {
+				exemplarTimestamp = e.Ts
+			}
+			series.Exemplars = append(series.Exemplars, prompb.Exemplar{
+				Labels:    labelsToProto(e.Labels),
+				Value:     e.Value,
+				Timestamp: exemplarTimestamp,
+			})
+			e = exemplar.Exemplar{}
+		}
+
+		timeseries = append(timeseries, series)
+	}
+
+	return timeseries, nil
+}
+
+func labelsToProto(lset labels.Labels) []prompb.Label {
+	result := make([]prompb.Label, 0, lset.Len())
+	lset.Range(func(l labels.Label) {
+		result = append(result, prompb.Label{Name: l.Name, Value: l.Value})
+	})
+	return result
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseText(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	input := `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200"} 1027 1395066363000
+http_requests_total{code="400"} 3
+`
+
+	got, err := ParseText([]byte(input), ContentTypeText, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+			Samples: []prompb.Sample{{Value: 1027, Timestamp: 1395066363000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "400"}},
+			Samples: []prompb.Sample{{Value: 3, Timestamp: now.UnixMilli()}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected time series (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseOpenMetrics(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	input := `# TYPE foo counter
+foo_total 17.0 # {trace_id="KOO5S4vxi0o"} 0.67
+# EOF
+`
+
+	got, err := ParseText([]byte(input), ContentTypeOpenMetrics, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "foo_total"}},
+		Samples: []prompb.Sample{{Value: 17, Timestamp: now.UnixMilli()}},
+		Exemplars: []prompb.Exemplar{{
+			Labels:    []prompb.Label{{Name: "trace_id", Value: "KOO5S4vxi0o"}},
+			Value:     0.67,
+			Timestamp: now.UnixMilli(),
+		}},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected time series (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseProtobuf(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	families := []*dto.MetricFamily{
+		{
+			Name: proto.String("requests_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Label:   []*dto.LabelPair{{Name: proto.String("code"), Value: proto.String("200")}},
+				Counter: &dto.Counter{Value: proto.Float64(42)},
+			}},
+		},
+		{
+			Name: proto.String("latency_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount:   proto.Uint64(3),
+					SampleSum:     proto.Float64(1.5),
+					Schema:        proto.Int32(0),
+					ZeroThreshold: proto.Float64(0.001),
+					ZeroCount:     proto.Uint64(1),
+					PositiveSpan:  []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(1)}},
+					PositiveDelta: []int64{2},
+				},
+			}},
+		},
+	}
+
+	var input bytes.Buffer
+	encoder := expfmt.NewEncoder(&input, expfmt.FmtProtoDelim)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ParseText(input.Bytes(), ContentTypeProtobuf, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 time series, got %d: %+v", len(got), got)
+	}
+	want := prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "code", Value: "200"}},
+		Samples: []prompb.Sample{{Value: 42, Timestamp: now.UnixMilli()}},
+	}
+	if diff := cmp.Diff(want, got[0]); diff != "" {
+		t.Errorf("unexpected counter (-want +got):\n%s", diff)
+	}
+	if histograms := got[1].GetHistograms(); len(histograms) != 1 || histograms[0].GetCountInt() != 3 ||
+		histograms[0].GetTimestamp() != now.UnixMilli() {
+		t.Errorf("expected a native histogram of 3 observations, got %+v", got[1])
+	}
+}
+
+func TestParseTextError(t *testing.T) {
+	if _, err := ParseText([]byte("foo{bar 1\n"), ContentTypeText, time.Now()); err == nil {
+		t.Error("expected a parse error")
+	}
+}
@@ -0,0 +1,205 @@
+// Package pipeline converts Prometheus time series into the statsd payloads
+// that promsentry sends to Sentry. The remote write handler, the convert
+// command and the tests all go through the same code.
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/aldy505/promsentry/statsd"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// Sink receives the statsd payloads produced by a Converter.
+type Sink interface {
+	// Write delivers a statsd payload, with one metric per line.
+	Write(ctx context.Context, metric []byte) error
+}
+
+// Options configures a Converter.
+type Options struct {
+	// Prefix is added to the name of every metric. No delimiter is added, so
+	// use "foo." rather than "foo".
+	Prefix string
+	// Tags are added to every metric.
+	Tags Tags
+	// LabelTags renames labels into tags, like "env" into "environment".
+	// Labels renamed to an empty string are dropped.
+	LabelTags map[string]string
+	// Routes override the tags of the time series that match them. The first
+	// matching route is used.
+	Routes []Route
+	// Identity returns the client that sent the time series written with the
+	// context, for the routes that match on the identity.
+	Identity func(ctx context.Context) string
+	// Logger receives the conversion errors. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Metrics receives the measurements of the conversion. They are
+	// discarded when it is nil.
+	Metrics Metrics
+}
+
+// Converter converts Prometheus time series into statsd payloads and writes
+// them to a Sink. It is safe for concurrent use.
+type Converter struct {
+	sink       Sink
+	conversion atomic.Pointer[conversion]
+}
+
+// conversion holds the options of a Converter, which are replaced as a whole.
+type conversion struct {
+	options Options
+	tagger  *tagger
+}
+
+// NewConverter creates a Converter that writes to the given sink.
+func NewConverter(sink Sink, options Options) *Converter {
+	c := &Converter{sink: sink}
+	c.SetOptions(options)
+	return c
+}
+
+// Options returns the options of the Converter.
+func (c *Converter) Options() Options {
+	return c.current().options
+}
+
+// SetOptions replaces the options of the Converter. The time series that are
+// being converted keep the previous options.
+func (c *Converter) SetOptions(options Options) {
+	c.conversion.Store(&conversion{
+		options: options,
+		tagger:  newTagger(options),
+	})
+}
+
+// current returns the options in effect, or the default ones for a zero
+// Converter.
+func (c *Converter) current() *conversion {
+	if current := c.conversion.Load(); current != nil {
+		return current
+	}
+	return &conversion{tagger: newTagger(Options{})}
+}
+
+// Write converts the time series of a remote write request and writes them
+// to the sink.
+func (c *Converter) Write(ctx context.Context, request *prompb.WriteRequest) error {
+	return c.WriteSeries(ctx, request.GetTimeseries())
+}
+
+// WriteSeries converts the time series and writes them to the sink. Nothing
+// is written if no statsd line was produced.
+func (c *Converter) WriteSeries(ctx context.Context, timeseries []prompb.TimeSeries) error {
+	metric := c.convert(ctx, timeseries)
+	if len(metric) == 0 {
+		return nil
+	}
+
+	return c.sink.Write(ctx, metric)
+}
+
+// Convert returns the statsd lines of the time series, separated by newlines.
+//
+// Samples are written as gauges, exemplars as durations and native histograms
+// as histograms of their observation count. The __name__ label is the name of
+// the metric, the other labels are its tags, along with the configured tags.
+// Routes that match on the identity of the client don't apply.
+func (c *Converter) Convert(timeseries []prompb.TimeSeries) []byte {
+	return c.convert(context.Background(), timeseries)
+}
+
+func (c *Converter) convert(ctx context.Context, timeseries []prompb.TimeSeries) []byte {
+	conversion := c.current()
+	identity := conversion.identity(ctx)
+
+	b := &bytes.Buffer{}
+	client := statsd.NewClient(b)
+	client.Prefix(conversion.options.Prefix)
+	metrics := conversion.metrics()
+	client.SetHooks(statsd.Hooks{
+		Emitted: metrics.LineEmitted,
+		Dropped: metrics.LineDropped,
+	})
+
+	metrics.SeriesReceived(len(timeseries))
+
+	for _, series := range timeseries {
+		name, tags := conversion.tagger.seriesTags(identity, series.GetLabels())
+		if conversion.convertSeries(ctx, client, name, tags, series) {
+			metrics.SeriesConverted()
+		}
+	}
+
+	if err := client.Flush(); err != nil {
+		conversion.logger().ErrorContext(ctx, "Unable to flush the statsd lines", "error", err)
+	}
+
+	return b.Bytes()
+}
+
+// logger returns the logger of the conversion errors.
+func (c *conversion) logger() *slog.Logger {
+	if c.options.Logger != nil {
+		return c.options.Logger
+	}
+	return slog.Default()
+}
+
+// metrics returns the Metrics of the options.
+func (c *conversion) metrics() Metrics {
+	return metricsOrNoop(c.options.Metrics)
+}
+
+// Convert returns the statsd lines of the time series with the default
+// options.
+func Convert(timeseries []prompb.TimeSeries) []byte {
+	return (&Converter{}).Convert(timeseries)
+}
+
+// convertSeries writes the statsd lines of a single time series. It returns
+// true if at least one line was written.
+func (c *conversion) convertSeries(ctx context.Context, client *statsd.Client, name string, tags map[string]string, timeseries prompb.TimeSeries) bool {
+	converted := false
+
+	for _, s := range timeseries.GetSamples() {
+		c.metrics().SampleReceived("sample")
+		err := client.Gauge(name, int64(s.GetValue()), tags)
+		if err != nil {
+			c.logger().WarnContext(ctx, "Unable to convert a sample", "metric", name, "error", err)
+			continue
+		}
+		converted = true
+	}
+
+	for _, e := range timeseries.GetExemplars() {
+		c.metrics().SampleReceived("exemplar")
+		err := client.Duration(name, time.Duration(e.GetValue()), tags)
+		if err != nil {
+			c.logger().WarnContext(ctx, "Unable to convert an exemplar", "metric", name, "error", err)
+		} else {
+			converted = true
+		}
+		for _, l := range e.GetLabels() {
+			tags[l.GetName()] = l.GetValue()
+		}
+	}
+
+	for _, hp := range timeseries.GetHistograms() {
+		c.metrics().SampleReceived("histogram")
+		h := remote.HistogramProtoToHistogram(hp)
+		err := client.Histogram(name, h.Count, tags)
+		if err != nil {
+			c.logger().WarnContext(ctx, "Unable to convert a histogram", "metric", name, "error", err)
+			continue
+		}
+		converted = true
+	}
+
+	return converted
+}
@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// timestamps matches the statsd timestamps, which are the conversion time.
+var timestamps = regexp.MustCompile(`\|T\d+`)
+
+func TestConvert(t *testing.T) {
+	timeseries := []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "up"},
+				{Name: "job", Value: "prometheus"},
+			},
+			Samples: []prompb.Sample{{Value: 1}, {Value: 0}},
+		},
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "request_duration"},
+				{Name: "job", Value: "api"},
+			},
+			Exemplars: []prompb.Exemplar{{Value: 1500}},
+		},
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "latency"},
+			},
+			Histograms: []prompb.Histogram{{Count: &prompb.Histogram_CountInt{CountInt: 42}}},
+		},
+	}
+
+	got := timestamps.ReplaceAllString(string(Convert(timeseries)), "")
+	want := strings.Join([]string{
+		"up:1|g|#job:prometheus",
+		"up:0|g|#job:prometheus",
+		"request_duration:0|d|#job:api",
+		"latency:42|h|",
+	}, "\n")
+	if got != want {
+		t.Errorf("unexpected statsd lines:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConvertEmpty(t *testing.T) {
+	if got := Convert(nil); len(got) != 0 {
+		t.Errorf("expected no statsd lines, got %q", got)
+	}
+}
+
+// recordingSink keeps the payloads written to it.
+type recordingSink struct {
+	metrics []string
+}
+
+func (s *recordingSink) Write(ctx context.Context, metric []byte) error {
+	s.metrics = append(s.metrics, string(metric))
+	return nil
+}
+
+func TestConverterWrite(t *testing.T) {
+	sink := &recordingSink{}
+	converter := NewConverter(sink, Options{Prefix: "prometheus."})
+
+	err := converter.Write(context.Background(), &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is written for a request without samples.
+	err = converter.Write(context.Background(), &prompb.WriteRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.metrics) != 1 {
+		t.Fatalf("expected a single payload, got %d", len(sink.metrics))
+	}
+	if got := timestamps.ReplaceAllString(sink.metrics[0], ""); got != "prometheus.up:1|g|" {
+		t.Errorf("unexpected statsd lines: %q", got)
+	}
+}
+
+// recordingMetrics records the measurements of the conversion and of the sinks.
+type recordingMetrics struct {
+	noopMetrics
+
+	mu        sync.Mutex
+	series    int
+	converted int
+	samples   []string
+	emitted   int
+	writes    []string
+}
+
+func (m *recordingMetrics) SeriesReceived(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series += n
+}
+
+func (m *recordingMetrics) SeriesConverted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.converted++
+}
+
+func (m *recordingMetrics) SampleReceived(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, kind)
+}
+
+func (m *recordingMetrics) LineEmitted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emitted++
+}
+
+func (m *recordingMetrics) SinkWritten(sink string, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if success {
+		m.writes = append(m.writes, sink+":success")
+	} else {
+		m.writes = append(m.writes, sink+":failure")
+	}
+}
+
+func TestConverterMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	converter := NewConverter(&recordingSink{}, Options{Metrics: metrics})
+
+	converter.Convert([]prompb.TimeSeries{
+		{
+			Labels:    []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples:   []prompb.Sample{{Value: 1}, {Value: 0}},
+			Exemplars: []prompb.Exemplar{{Value: 1}},
+		},
+		{
+			Labels: []prompb.Label{{Name: "__name__", Value: "empty"}},
+		},
+	})
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.series != 2 || metrics.converted != 1 {
+		t.Errorf("expected 2 series received and 1 converted, got %d and %d", metrics.series, metrics.converted)
+	}
+	if got := strings.Join(metrics.samples, ","); got != "sample,sample,exemplar" {
+		t.Errorf("unexpected samples %q", got)
+	}
+	if metrics.emitted != 3 {
+		t.Errorf("expected 3 statsd lines, got %d", metrics.emitted)
+	}
+}
@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultRecorderTailSize = 100
+	// recorderMaxMetrics bounds the number of metric names counted, and
+	// recorderMaxTagValues the number of distinct values counted per tag key,
+	// so that a high cardinality doesn't grow the recorder without limit.
+	recorderMaxMetrics   = 10000
+	recorderMaxTagValues = 10000
+)
+
+// RecorderOptions configures a Recorder.
+type RecorderOptions struct {
+	// TailSize is the number of statsd lines kept. Defaults to 100.
+	TailSize int
+}
+
+// Recorder keeps statistics about the statsd lines written to a sink, for the
+// status page: the number of lines of each metric, the number of distinct
+// values of each tag key, and the last lines. It is safe for concurrent use.
+type Recorder struct {
+	tailSize int
+
+	mu        sync.Mutex
+	lines     int
+	metrics   map[string]int
+	tagValues map[string]map[string]struct{}
+	// tail is a ring buffer, next is the index of the next line.
+	tail []string
+	next int
+}
+
+// RecorderStats is a snapshot of the statistics of a Recorder.
+type RecorderStats struct {
+	// Lines is the number of statsd lines recorded.
+	Lines int `json:"lines"`
+	// Metrics are the metrics with the most lines, the largest first.
+	Metrics []Count `json:"metrics"`
+	// TagKeys are the tag keys with the most distinct values, the largest
+	// first.
+	TagKeys []Count `json:"tag_keys"`
+	// Tail are the last statsd lines, the most recent last.
+	Tail []string `json:"tail"`
+}
+
+// Count is the count of a metric or a tag key.
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NewRecorder creates an empty Recorder.
+func NewRecorder(options RecorderOptions) *Recorder {
+	tailSize := options.TailSize
+	if tailSize <= 0 {
+		tailSize = defaultRecorderTailSize
+	}
+
+	return &Recorder{
+		tailSize:  tailSize,
+		metrics:   make(map[string]int),
+		tagValues: make(map[string]map[string]struct{}),
+		tail:      make([]string, 0, tailSize),
+	}
+}
+
+// Write records the lines of the payload. It never fails, lines that can't
+// be parsed are only added to the tail. The lines are parsed before taking
+// the lock, which is only held to update the counts.
+func (r *Recorder) Write(ctx context.Context, metric []byte) error {
+	raw := lines(metric)
+	parsed := make([]Line, len(raw))
+	valid := make([]bool, len(raw))
+	for i, s := range raw {
+		line, err := ParseLine(s)
+		parsed[i], valid[i] = line, err == nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, s := range raw {
+		r.lines++
+		if len(r.tail) < r.tailSize {
+			r.tail = append(r.tail, s)
+		} else {
+			r.tail[r.next] = s
+		}
+		r.next = (r.next + 1) % r.tailSize
+
+		if !valid[i] {
+			continue
+		}
+		line := parsed[i]
+
+		if _, ok := r.metrics[line.Name]; ok || len(r.metrics) < recorderMaxMetrics {
+			r.metrics[line.Name]++
+		}
+		for key, value := range line.Tags {
+			values, ok := r.tagValues[key]
+			if !ok {
+				values = make(map[string]struct{})
+				r.tagValues[key] = values
+			}
+			if len(values) < recorderMaxTagValues {
+				values[value] = struct{}{}
+			}
+		}
+	}
+	return nil
+}
+
+// Wrap returns a Sink that records the payloads before writing them to sink.
+func (r *Recorder) Wrap(sink Sink) Sink {
+	return &recorderSink{sink: sink, recorder: r}
+}
+
+// Stats returns the statistics, with the top metrics and tag keys.
+func (r *Recorder) Stats(top int) RecorderStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := RecorderStats{
+		Lines:   r.lines,
+		Metrics: topCounts(r.metrics, top),
+		Tail:    make([]string, 0, len(r.tail)),
+	}
+
+	cardinality := make(map[string]int, len(r.tagValues))
+	for key, values := range r.tagValues {
+		cardinality[key] = len(values)
+	}
+	stats.TagKeys = topCounts(cardinality, top)
+
+	if len(r.tail) < r.tailSize {
+		stats.Tail = append(stats.Tail, r.tail...)
+	} else {
+		stats.Tail = append(stats.Tail, r.tail[r.next:]...)
+		stats.Tail = append(stats.Tail, r.tail[:r.next]...)
+	}
+	return stats
+}
+
+// topCounts returns the largest counts, sorted by decreasing count then name.
+func topCounts(counts map[string]int, top int) []Count {
+	result := make([]Count, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, Count{Name: name, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	if top > 0 && len(result) > top {
+		result = result[:top]
+	}
+	return result
+}
+
+// recorderSink records the payloads with a Recorder, then writes them to
+// another sink.
+type recorderSink struct {
+	sink     Sink
+	recorder *Recorder
+}
+
+func (s *recorderSink) Write(ctx context.Context, metric []byte) error {
+	_ = s.recorder.Write(ctx, metric)
+	return s.sink.Write(ctx, metric)
+}
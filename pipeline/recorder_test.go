@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder(RecorderOptions{TailSize: 3})
+	var output bytes.Buffer
+	sink := recorder.Wrap(NewWriterSink(&output, FormatStatsd))
+
+	payloads := []string{
+		"up:1|g|#instance:a,job:node\nup:0|g|#instance:b,job:node",
+		"requests:5|g|#instance:c,job:api",
+		"up:1|g|#instance:d,job:node\ninvalid",
+	}
+	for _, payload := range payloads {
+		if err := sink.Write(context.Background(), []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := RecorderStats{
+		Lines:   5,
+		Metrics: []Count{{Name: "up", Count: 3}},
+		TagKeys: []Count{{Name: "instance", Count: 4}},
+		Tail:    []string{"requests:5|g|#instance:c,job:api", "up:1|g|#instance:d,job:node", "invalid"},
+	}
+	if diff := cmp.Diff(want, recorder.Stats(1)); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+
+	if got := recorder.Stats(0); len(got.Metrics) != 2 || got.TagKeys[1] != (Count{Name: "job", Count: 2}) {
+		t.Errorf("expected every metric and tag key, got %+v", got)
+	}
+
+	// The payloads still reach the wrapped sink.
+	if n := bytes.Count(output.Bytes(), []byte("\n")); n != 5 {
+		t.Errorf("expected 5 lines written to the sink, got %d", n)
+	}
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/aldy505/promsentry/sentry"
+)
+
+// SentrySink captures statsd payloads with a Sentry hub.
+type SentrySink struct {
+	hub *sentry.Hub
+}
+
+// NewSentrySink creates a SentrySink that captures payloads with the given
+// hub. When hub is nil, the hub of the context is used if there is one, and
+// the current hub otherwise.
+func NewSentrySink(hub *sentry.Hub) *SentrySink {
+	return &SentrySink{hub: hub}
+}
+
+// Write captures the payload. Delivery to Sentry happens in the background,
+// according to the transport of the hub's client.
+func (s *SentrySink) Write(ctx context.Context, metric []byte) error {
+	hub := s.hub
+	if hub == nil {
+		hub = sentry.GetHubFromContext(ctx)
+	}
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+
+	hub.CaptureMetric(metric)
+	return nil
+}
+
+// WriterSink writes statsd payloads to an io.Writer, like os.Stdout. Each
+// payload is written with a single call to the writer.
+type WriterSink struct {
+	format Format
+
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewWriterSink creates a WriterSink that writes to w in the given format.
+func NewWriterSink(w io.Writer, format Format) *WriterSink {
+	return &WriterSink{
+		format: format,
+		writer: w,
+	}
+}
+
+// Write writes the payload, one line per statsd line.
+func (s *WriterSink) Write(ctx context.Context, metric []byte) error {
+	data, err := encode(s.format, metric)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.writer.Write(data)
+	return err
+}
+
+// Fanout writes statsd payloads to several sinks.
+type Fanout struct {
+	// Logger receives the errors of the sinks that failed while others
+	// accepted the payload. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Metrics receives the outcome of the writes to every sink. They are
+	// discarded when it is nil.
+	Metrics Metrics
+
+	names []string
+	sinks []Sink
+}
+
+// NewFanout creates an empty Fanout. Sinks are added with Add.
+func NewFanout() *Fanout {
+	return &Fanout{}
+}
+
+// Add adds a sink. The name identifies the sink in errors and in Metrics.
+func (f *Fanout) Add(name string, sink Sink) {
+	f.names = append(f.names, name)
+	f.sinks = append(f.sinks, sink)
+}
+
+// Len returns the number of sinks.
+func (f *Fanout) Len() int {
+	return len(f.sinks)
+}
+
+// Write writes the payload to every sink, in the order they were added. A
+// sink that fails doesn't prevent the next ones from receiving the payload.
+// The errors are only returned when no sink accepted the payload: otherwise,
+// a retry would duplicate it in the sinks that accepted it, so the errors are
+// logged instead. Failures are reported to Metrics either way.
+func (f *Fanout) Write(ctx context.Context, metric []byte) error {
+	metrics := metricsOrNoop(f.Metrics)
+
+	var errs []error
+	for i, sink := range f.sinks {
+		err := sink.Write(ctx, metric)
+		metrics.SinkWritten(f.names[i], err == nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %w", f.names[i], err))
+		}
+	}
+
+	if len(errs) == len(f.sinks) {
+		return errors.Join(errs...)
+	}
+
+	for _, err := range errs {
+		f.logger().WarnContext(ctx, "Unable to write the statsd lines to a sink", "error", err)
+	}
+	return nil
+}
+
+func (f *Fanout) logger() *slog.Logger {
+	if f.Logger != nil {
+		return f.Logger
+	}
+	return slog.Default()
+}
+
+// Close closes the sinks that implement io.Closer.
+func (f *Fanout) Close() error {
+	var errs []error
+	for i, sink := range f.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s sink: %w", f.names[i], err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
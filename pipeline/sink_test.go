@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLine(t *testing.T) {
+	line, err := ParseLine("up:1|g|#job:prometheus,instance:localhost:9090|T1700000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Line{
+		Name:      "up",
+		Value:     "1",
+		Type:      "g",
+		Tags:      map[string]string{"job": "prometheus", "instance": "localhost:9090"},
+		Timestamp: 1700000000,
+	}
+	if diff := cmp.Diff(want, line); diff != "" {
+		t.Errorf("unexpected line (-want +got):\n%s", diff)
+	}
+
+	if got := line.String(); got != "up:1|g|#instance:localhost:9090,job:prometheus|T1700000000" {
+		t.Errorf("unexpected statsd line %q", got)
+	}
+
+	// The empty tags section is removed.
+	line, err = ParseLine("latency:42|h||T1700000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := line.String(); got != "latency:42|h|T1700000000" {
+		t.Errorf("unexpected statsd line %q", got)
+	}
+
+	if _, err := ParseLine("invalid"); err == nil {
+		t.Error("expected an error for a line without a value")
+	}
+}
+
+func TestWriterSinkJSONL(t *testing.T) {
+	b := &bytes.Buffer{}
+	sink := NewWriterSink(b, FormatJSONL)
+
+	err := sink.Write(context.Background(), []byte("up:1|g|#job:prometheus|T1700000000\nlatency:42|h||T1700000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"name":"up","value":"1","type":"g","tags":{"job":"prometheus"},"timestamp":1700000000}
+{"name":"latency","value":"42","type":"h","timestamp":1700000000}
+`
+	if got := b.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.statsd")
+	sink, err := NewFileSink(path, FileSinkOptions{MaxSize: 20, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for _, metric := range []string{"a:1|g|T1700000000", "b:1|g|T1700000000", "c:1|g|T1700000000", "d:1|g|T1700000000"} {
+		if err := sink.Write(context.Background(), []byte(metric)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, want := range map[string]string{
+		path:        "d:1|g|T1700000000\n",
+		path + ".1": "c:1|g|T1700000000\n",
+		path + ".2": "b:1|g|T1700000000\n",
+	} {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("unexpected content of %s: %q", file, got)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected the oldest backup to be removed, got %v", err)
+	}
+}
+
+func TestUDPSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewUDPSink(conn.LocalAddr().String(), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	err = sink.Write(context.Background(), []byte("a:1|g||T1700000000\ninvalid\nb:1|g||T1700000000\nc:1|g||T1700000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []string
+	buf := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+
+	want := []string{"a:1|g|T1700000000\nb:1|g|T1700000000", "c:1|g|T1700000000"}
+	if diff := cmp.Diff(want, packets); diff != "" {
+		t.Errorf("unexpected packets (-want +got):\n%s", diff)
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Write(ctx context.Context, metric []byte) error {
+	return errors.New("unavailable")
+}
+
+func TestFanout(t *testing.T) {
+	first := &recordingSink{}
+	second := &recordingSink{}
+
+	metrics := &recordingMetrics{}
+	fanout := NewFanout()
+	fanout.Metrics = metrics
+	fanout.Add("first", first)
+	fanout.Add("failing", failingSink{})
+	fanout.Add("second", second)
+
+	if err := fanout.Write(context.Background(), []byte("up:1|g")); err != nil {
+		t.Errorf("expected the failure to be logged while other sinks accepted the payload, got %v", err)
+	}
+
+	if len(first.metrics) != 1 || len(second.metrics) != 1 {
+		t.Errorf("expected every sink to receive the payload, got %d and %d", len(first.metrics), len(second.metrics))
+	}
+	if got := strings.Join(metrics.writes, ","); got != "first:success,failing:failure,second:success" {
+		t.Errorf("unexpected sink writes %q", got)
+	}
+
+	failing := NewFanout()
+	failing.Add("failing", failingSink{})
+	err := failing.Write(context.Background(), []byte("up:1|g"))
+	if err == nil || !strings.Contains(err.Error(), "failing sink: unavailable") {
+		t.Errorf("expected the error when no sink accepted the payload, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Tags are added to the statsd lines of the converted metrics.
+type Tags struct {
+	// Environment and Release are sent as the environment and release tags,
+	// which Sentry uses to filter metrics.
+	Environment string
+	Release     string
+	// Static tags, like the region or the cluster.
+	Static map[string]string
+}
+
+// Route overrides the tags of the time series that match it.
+type Route struct {
+	// Identity matches the client that sent the time series, as returned by
+	// Options.Identity. Empty matches every client.
+	Identity string
+	// Labels must all have the given value on the time series. Empty matches
+	// every time series.
+	Labels map[string]string
+	// Tags replace the global ones. Empty values keep the global environment
+	// and release, static tags are merged.
+	Tags Tags
+}
+
+// merge returns the tags, overridden by the non-empty values of the other
+// tags.
+func (t Tags) merge(other Tags) Tags {
+	merged := Tags{
+		Environment: t.Environment,
+		Release:     t.Release,
+		Static:      make(map[string]string, len(t.Static)+len(other.Static)),
+	}
+	if other.Environment != "" {
+		merged.Environment = other.Environment
+	}
+	if other.Release != "" {
+		merged.Release = other.Release
+	}
+	for key, value := range t.Static {
+		merged.Static[key] = value
+	}
+	for key, value := range other.Static {
+		merged.Static[key] = value
+	}
+	return merged
+}
+
+// statsdTags returns the tags as they are written on statsd lines.
+func (t Tags) statsdTags() map[string]string {
+	tags := make(map[string]string, len(t.Static)+2)
+	for key, value := range t.Static {
+		tags[key] = value
+	}
+	if t.Environment != "" {
+		tags["environment"] = t.Environment
+	}
+	if t.Release != "" {
+		tags["release"] = t.Release
+	}
+	return tags
+}
+
+// matches reports whether the route applies to the time series of the
+// client.
+func (r Route) matches(identity string, labels []prompb.Label) bool {
+	if r.Identity != "" && r.Identity != identity {
+		return false
+	}
+
+	for name, value := range r.Labels {
+		found := false
+		for _, l := range labels {
+			if l.GetName() == name {
+				found = l.GetValue() == value
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tagger computes the tags of each time series.
+type tagger struct {
+	global map[string]string
+	routes []route
+	// labelTags renames labels into tags.
+	labelTags map[string]string
+}
+
+type route struct {
+	Route
+	tags map[string]string
+}
+
+func newTagger(options Options) *tagger {
+	t := &tagger{
+		global:    options.Tags.statsdTags(),
+		labelTags: options.LabelTags,
+	}
+	for _, r := range options.Routes {
+		t.routes = append(t.routes, route{Route: r, tags: options.Tags.merge(r.Tags).statsdTags()})
+	}
+	return t
+}
+
+// seriesTags returns the name of the time series and its tags. The labels of
+// the time series take precedence over the tags of the first matching route,
+// or the global tags when no route matches.
+func (t *tagger) seriesTags(identity string, labels []prompb.Label) (string, map[string]string) {
+	defaults := t.global
+	for _, r := range t.routes {
+		if r.matches(identity, labels) {
+			defaults = r.tags
+			break
+		}
+	}
+
+	var name string
+	tags := make(map[string]string, len(defaults)+len(labels))
+	for key, value := range defaults {
+		tags[key] = value
+	}
+	for _, l := range labels {
+		if l.GetName() == "__name__" {
+			name = l.GetValue()
+			continue
+		}
+
+		key := l.GetName()
+		if renamed, ok := t.labelTags[key]; ok {
+			if renamed == "" {
+				continue
+			}
+			key = renamed
+		}
+		tags[key] = l.GetValue()
+	}
+	return name, tags
+}
+
+// identity returns the client that sent the time series written with the
+// context.
+func (c *conversion) identity(ctx context.Context) string {
+	if c.options.Identity == nil {
+		return ""
+	}
+	return c.options.Identity(ctx)
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type identityContextKey struct{}
+
+func TestConverterTags(t *testing.T) {
+	sink := &recordingSink{}
+	converter := NewConverter(sink, Options{
+		Tags: Tags{
+			Environment: "production",
+			Release:     "1.0.0",
+			Static:      map[string]string{"region": "eu"},
+		},
+		LabelTags: map[string]string{"env": "environment", "instance": ""},
+		Routes: []Route{
+			{Identity: "staging-prometheus", Tags: Tags{Environment: "staging"}},
+			{Labels: map[string]string{"job": "canary"}, Tags: Tags{Release: "1.1.0-rc.1", Static: map[string]string{"canary": "true"}}},
+		},
+		Identity: func(ctx context.Context) string {
+			identity, _ := ctx.Value(identityContextKey{}).(string)
+			return identity
+		},
+	})
+
+	series := func(labels ...string) prompb.TimeSeries {
+		ts := prompb.TimeSeries{Samples: []prompb.Sample{{Value: 1}}}
+		for i := 0; i < len(labels); i += 2 {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: labels[i], Value: labels[i+1]})
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name     string
+		identity string
+		series   prompb.TimeSeries
+		want     string
+	}{
+		{
+			name:   "global tags",
+			series: series("__name__", "up", "job", "api", "instance", "10.0.0.1:9090"),
+			want:   "up:1|g|#environment:production,job:api,region:eu,release:1.0.0",
+		},
+		{
+			name:   "label renamed into a tag",
+			series: series("__name__", "up", "env", "development"),
+			want:   "up:1|g|#environment:development,region:eu,release:1.0.0",
+		},
+		{
+			name:     "route matching the identity",
+			identity: "staging-prometheus",
+			series:   series("__name__", "up"),
+			want:     "up:1|g|#environment:staging,region:eu,release:1.0.0",
+		},
+		{
+			name:   "route matching the labels",
+			series: series("__name__", "up", "job", "canary"),
+			want:   "up:1|g|#canary:true,environment:production,job:canary,region:eu,release:1.1.0-rc.1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink.metrics = nil
+			ctx := context.WithValue(context.Background(), identityContextKey{}, test.identity)
+			if err := converter.WriteSeries(ctx, []prompb.TimeSeries{test.series}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sink.metrics) != 1 {
+				t.Fatalf("expected 1 payload, got %d", len(sink.metrics))
+			}
+			if got := timestamps.ReplaceAllString(strings.TrimSpace(sink.metrics[0]), ""); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConverterSetOptions(t *testing.T) {
+	sink := &recordingSink{}
+	converter := NewConverter(sink, Options{Tags: Tags{Environment: "production"}})
+
+	options := converter.Options()
+	options.Tags = Tags{Environment: "staging"}
+	converter.SetOptions(options)
+
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1}},
+	}}
+	if err := converter.WriteSeries(context.Background(), series); err != nil {
+		t.Fatal(err)
+	}
+	if got := timestamps.ReplaceAllString(strings.TrimSpace(sink.metrics[0]), ""); got != "up:1|g|#environment:staging" {
+		t.Errorf("expected the new tags, got %q", got)
+	}
+}
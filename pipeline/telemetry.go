@@ -0,0 +1,47 @@
+package pipeline
+
+// Metrics receives the measurements of the conversion and of the sinks, so
+// that they can be exposed as metrics. The methods must be safe for
+// concurrent use.
+type Metrics interface {
+	// SeriesReceived is called with the number of time series of every
+	// conversion.
+	SeriesReceived(n int)
+	// SeriesConverted is called for every time series that produced at least
+	// one statsd line.
+	SeriesConverted()
+	// SampleReceived is called for every sample, with its kind: sample,
+	// exemplar or histogram.
+	SampleReceived(kind string)
+	// LineEmitted is called for every statsd line written by the conversion.
+	LineEmitted()
+	// LineDropped is called for every statsd line that the conversion didn't
+	// write, with the reason: sampled or write_error.
+	LineDropped(reason string)
+	// SinkWritten is called for every write to a sink of a Fanout, with the
+	// name of the sink and whether it succeeded.
+	SinkWritten(sink string, success bool)
+	// SinkLineDropped is called for every line that a sink skipped, with the
+	// name of the sink and the reason.
+	SinkLineDropped(sink string, reason string)
+}
+
+// noopMetrics discards the measurements. It is used when no Metrics is set.
+type noopMetrics struct{}
+
+func (noopMetrics) SeriesReceived(int)             {}
+func (noopMetrics) SeriesConverted()               {}
+func (noopMetrics) SampleReceived(string)          {}
+func (noopMetrics) LineEmitted()                   {}
+func (noopMetrics) LineDropped(string)             {}
+func (noopMetrics) SinkWritten(string, bool)       {}
+func (noopMetrics) SinkLineDropped(string, string) {}
+
+// metricsOrNoop returns the metrics, or a Metrics that discards the
+// measurements when it is nil.
+func metricsOrNoop(metrics Metrics) Metrics {
+	if metrics == nil {
+		return noopMetrics{}
+	}
+	return metrics
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxPacketSize is the default size of the UDP packets sent by a
+// UDPSink, it fits in the MTU of an Ethernet network.
+const DefaultMaxPacketSize = 1432
+
+// UDPSink forwards statsd payloads to a statsd server, like the Datadog
+// agent, over UDP. Several lines are sent in the same packet when they fit.
+type UDPSink struct {
+	// Metrics receives the lines that can't be parsed, as lines dropped by
+	// the statsd sink. They are discarded when it is nil.
+	Metrics Metrics
+
+	maxPacketSize int
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewUDPSink creates a UDPSink that sends to address, a host:port pair.
+// maxPacketSize defaults to DefaultMaxPacketSize when it is zero.
+func NewUDPSink(address string, maxPacketSize int) (*UDPSink, error) {
+	if maxPacketSize <= 0 {
+		maxPacketSize = DefaultMaxPacketSize
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to statsd server %s: %w", address, err)
+	}
+
+	return &UDPSink{
+		maxPacketSize: maxPacketSize,
+		conn:          conn,
+	}, nil
+}
+
+// Write sends the statsd lines of the payload. Empty sections, which some
+// statsd servers reject, are removed from the lines. A line that is larger
+// than the maximum packet size is sent in a packet of its own. Lines that
+// can't be parsed are skipped and reported to Metrics.
+func (s *UDPSink) Write(ctx context.Context, metric []byte) error {
+	var packets []string
+	var packet strings.Builder
+	for _, l := range lines(metric) {
+		line, err := ParseLine(l)
+		if err != nil {
+			metricsOrNoop(s.Metrics).SinkLineDropped("statsd", "invalid_line")
+			continue
+		}
+		l = line.String()
+
+		if packet.Len() > 0 && packet.Len()+1+len(l) > s.maxPacketSize {
+			packets = append(packets, packet.String())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteString("\n")
+		}
+		packet.WriteString(l)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.String())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range packets {
+		if _, err := s.conn.Write([]byte(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (s *UDPSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conn.Close()
+}
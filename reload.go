@@ -0,0 +1,399 @@
+package promsentry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aldy505/promsentry/forward"
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/sentry"
+	"github.com/aldy505/promsentry/telemetry"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+// ReloadFunc prepares a running component for a new configuration. It receives the configuration that is currently
+// applied and the new one, and returns an error if the component can't use the new one. Otherwise, it returns the
+// ReloadCommit that applies it, or nil when the component doesn't change.
+type ReloadFunc func(previous *Configuration, configuration *Configuration) (ReloadCommit, error)
+
+// ReloadCommit applies the configuration prepared by a ReloadFunc. It is called with apply set to false when another
+// component rejected the configuration, to release what was prepared. It can't fail, so that a configuration is
+// applied to every component or to none of them.
+type ReloadCommit func(apply bool)
+
+// Reloader reads the configuration file again and applies it to the running components, on demand, on SIGHUP or
+// when the file changes. A configuration that is invalid, or that a component rejects, is discarded and the previous
+// one is kept.
+type Reloader struct {
+	filePath string
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	current *Configuration
+	reloads []ReloadFunc
+}
+
+// NewReloader creates a Reloader for the configuration that was read from filePath. The reloads are logged through
+// the logger, slog.Default() when it is nil.
+func NewReloader(filePath string, configuration *Configuration, logger *slog.Logger) *Reloader {
+	telemetry.ConfigLastReloadSuccessful.Set(1)
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Reloader{
+		filePath: filePath,
+		logger:   logger,
+		current:  configuration,
+	}
+}
+
+// Register adds a component to apply new configurations to. Components are applied in the order they were
+// registered.
+func (r *Reloader) Register(reload ReloadFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reloads = append(r.reloads, reload)
+}
+
+// Configuration returns the configuration that is currently applied. The settings that require a restart keep the
+// values the process was started with.
+func (r *Reloader) Configuration() *Configuration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+// Reload reads and validates the configuration, then applies it to every registered component.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.reload()
+	if err != nil {
+		r.logger.Error("Unable to reload the configuration", "error", err)
+		telemetry.ConfigReloads.WithLabelValues("failure").Inc()
+		telemetry.ConfigLastReloadSuccessful.Set(0)
+		return err
+	}
+
+	r.logger.Info("Configuration reloaded")
+	telemetry.ConfigReloads.WithLabelValues("success").Inc()
+	telemetry.ConfigLastReloadSuccessful.Set(1)
+	return nil
+}
+
+func (r *Reloader) reload() error {
+	configuration, err := ParseConfiguration(r.filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := configuration.Validate(); err != nil {
+		return err
+	}
+
+	// The sinks and forwarders skipped in dry run are only created on start.
+	if configuration.DryRun != r.current.DryRun && (len(r.current.Forward) > 0 ||
+		r.current.Sinks.File.Path != "" || r.current.Sinks.Statsd.Address != "") {
+		return fmt.Errorf("changing dry_run requires a restart when forward targets, a file or a statsd sink are configured")
+	}
+
+	// Every component validates the configuration before any applies it.
+	commits := make([]ReloadCommit, 0, len(r.reloads))
+	for _, reload := range r.reloads {
+		commit, err := reload(r.current, configuration)
+		if err != nil {
+			for _, commit := range commits {
+				commit(false)
+			}
+			return err
+		}
+		if commit != nil {
+			commits = append(commits, commit)
+		}
+	}
+
+	for _, commit := range commits {
+		commit(true)
+	}
+
+	r.current = r.applied(configuration)
+	return nil
+}
+
+// applied returns the configuration that is in effect once the configuration is applied: the settings that require
+// a restart keep their current values.
+func (r *Reloader) applied(configuration *Configuration) *Configuration {
+	applied := *configuration
+
+	if configuration.ListenAddress != r.current.ListenAddress {
+		r.logger.Warn("Changing listen_address requires a restart, keeping the previous address")
+		applied.ListenAddress = r.current.ListenAddress
+	}
+
+	if configuration.AdminListenAddress != r.current.AdminListenAddress {
+		r.logger.Warn("Changing admin_listen_address requires a restart, keeping the previous address")
+		applied.AdminListenAddress = r.current.AdminListenAddress
+	}
+
+	limits := configuration.Limits
+	limits.ReadHeaderTimeout, limits.ReadTimeout = r.current.Limits.ReadHeaderTimeout, r.current.Limits.ReadTimeout
+	limits.WriteTimeout, limits.IdleTimeout = r.current.Limits.WriteTimeout, r.current.Limits.IdleTimeout
+	if limits != configuration.Limits {
+		r.logger.Warn("Changing the timeouts of limits requires a restart, keeping the previous timeouts")
+		applied.Limits = limits
+	}
+
+	if !reflect.DeepEqual(configuration.Sinks, r.current.Sinks) {
+		r.logger.Warn("Changing sinks requires a restart, keeping the previous sinks")
+		applied.Sinks = r.current.Sinks
+	}
+
+	if !reflect.DeepEqual(configuration.Log, r.current.Log) {
+		r.logger.Warn("Changing log requires a restart, keeping the previous logger")
+		applied.Log = r.current.Log
+	}
+
+	if configuration.StatusPage != r.current.StatusPage {
+		r.logger.Warn("Changing status_page requires a restart, keeping the previous status page")
+		applied.StatusPage = r.current.StatusPage
+	}
+
+	if configuration.Health != r.current.Health {
+		r.logger.Warn("Changing health requires a restart, keeping the previous threshold")
+		applied.Health = r.current.Health
+	}
+
+	if !reflect.DeepEqual(configuration.Forward, r.current.Forward) {
+		targets := appliedForwardTargets(r.current.Forward, configuration.Forward)
+		if !reflect.DeepEqual(targets, configuration.Forward) {
+			r.logger.Warn("Changing forward targets, other than their write_relabel_configs, requires a restart, keeping the previous targets")
+			applied.Forward = targets
+		}
+	}
+
+	if !reflect.DeepEqual(configuration.ScrapeConfigs, r.current.ScrapeConfigs) {
+		r.logger.Warn("Changing scrape_configs requires a restart, keeping the previous scrape targets")
+		applied.ScrapeConfigs = r.current.ScrapeConfigs
+	}
+
+	// Without certificates at startup, the server doesn't use TLS and the tls section is not reloaded.
+	if r.current.TLS.ServerCertificatePath == "" && !reflect.DeepEqual(configuration.TLS, r.current.TLS) {
+		r.logger.Warn("Enabling tls requires a restart, keeping the server without TLS")
+		applied.TLS = r.current.TLS
+	}
+
+	return &applied
+}
+
+// Watch reloads the configuration when the process receives SIGHUP, or when the configuration file changes, until
+// the context is done.
+func (r *Reloader) Watch(ctx context.Context) error {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var changes <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if r.filePath != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("watching the configuration file: %w", err)
+		}
+		defer func() {
+			_ = watcher.Close()
+		}()
+
+		// The directory is watched rather than the file, editors and Kubernetes replace the file instead of
+		// writing to it.
+		if err := watcher.Add(filepath.Dir(r.filePath)); err != nil {
+			return fmt.Errorf("watching the configuration file: %w", err)
+		}
+		changes = watcher.Events
+		watchErrors = watcher.Errors
+	}
+
+	lastModified := modificationTime(r.filePath)
+
+	// Files are usually written in several steps, wait for them to settle.
+	debounce := time.NewTimer(0)
+	<-debounce.C
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hangup:
+			_ = r.Reload()
+		case <-changes:
+			debounce.Reset(time.Millisecond * 100)
+		case <-debounce.C:
+			modified := modificationTime(r.filePath)
+			if modified.IsZero() || modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+			_ = r.Reload()
+		case err := <-watchErrors:
+			r.logger.Error("Unable to watch the configuration file", "error", err)
+		}
+	}
+}
+
+func modificationTime(filePath string) time.Time {
+	if filePath == "" {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// ReloadSentryClient returns a ReloadFunc that binds a new Sentry client to the hub when the DSN, the dry run or the
+// transport settings change. The new client is created, and its transport validated, before any component applies
+// the configuration. The previous client is flushed in the background, for at most flushTimeout.
+func ReloadSentryClient(hub *sentry.Hub, flushTimeout time.Duration) ReloadFunc {
+	return func(previous *Configuration, configuration *Configuration) (ReloadCommit, error) {
+		if previous.SentryDsn == configuration.SentryDsn &&
+			previous.DryRun == configuration.DryRun &&
+			previous.Debug == configuration.Debug &&
+			reflect.DeepEqual(previous.Transport, configuration.Transport) {
+			return nil, nil
+		}
+
+		previousClient := hub.Client()
+
+		var previousTransport sentry.Transport
+		if previousClient != nil {
+			previousTransport = previousClient.Transport
+		}
+
+		client, err := newSentryClient(configuration, previousTransport)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(apply bool) {
+			if !apply {
+				closeTransport(client.Transport)
+				return
+			}
+
+			// A persistent queue in the same directory is taken over before the new client is bound. Meanwhile, the
+			// events sent to the previous client are passed on to the new one.
+			if disk, ok := client.Transport.(*sentry.DiskTransport); ok {
+				disk.TakeOver()
+			}
+			hub.BindClient(client)
+
+			// The previous transport is closed once flushed, releasing its compression encoder and its queue.
+			if previousClient != nil {
+				go func() {
+					previousClient.Flush(flushTimeout)
+					closeTransport(previousClient.Transport)
+				}()
+			}
+		}, nil
+	}
+}
+
+// ReloadConversion returns a ReloadFunc that replaces the environment, the release, the tags and the tag routes of
+// the converters.
+func ReloadConversion(converters ...*pipeline.Converter) ReloadFunc {
+	return func(previous *Configuration, configuration *Configuration) (ReloadCommit, error) {
+		if previous.Environment == configuration.Environment && previous.Release == configuration.Release &&
+			reflect.DeepEqual(previous.Tags, configuration.Tags) {
+			return nil, nil
+		}
+
+		// The tags are checked by Validate.
+		conversion := NewConversionOptions(configuration)
+		return func(apply bool) {
+			if !apply {
+				return
+			}
+
+			for _, converter := range converters {
+				options := converter.Options()
+				options.Tags = conversion.Tags
+				options.LabelTags = conversion.LabelTags
+				options.Routes = conversion.Routes
+				converter.SetOptions(options)
+			}
+		}, nil
+	}
+}
+
+// ReloadLimits returns a ReloadFunc that replaces the limits of the remote write requests. The timeouts are settings
+// of the server, they require a restart.
+func ReloadLimits(limiter *RequestLimiter) ReloadFunc {
+	return func(previous *Configuration, configuration *Configuration) (ReloadCommit, error) {
+		limits := NewServerLimits(configuration)
+		current := NewServerLimits(previous)
+		current.ReadHeaderTimeout, current.ReadTimeout = limits.ReadHeaderTimeout, limits.ReadTimeout
+		current.WriteTimeout, current.IdleTimeout = limits.WriteTimeout, limits.IdleTimeout
+		if limits == current {
+			return nil, nil
+		}
+
+		return func(apply bool) {
+			if apply {
+				limiter.SetLimits(limits)
+			}
+		}, nil
+	}
+}
+
+// ReloadForwarders returns a ReloadFunc that replaces the write_relabel_configs of the forwarders. The other settings
+// of the forward targets require a restart.
+func ReloadForwarders(forwarders []*forward.Forwarder) ReloadFunc {
+	return func(previous *Configuration, configuration *Configuration) (ReloadCommit, error) {
+		relabelConfigs := make(map[*forward.Forwarder][]*relabel.Config)
+		for _, forwarder := range forwarders {
+			previousTarget, ok := findForwardTarget(previous.Forward, forwarder.Name())
+			if !ok {
+				continue
+			}
+			target, ok := findForwardTarget(configuration.Forward, forwarder.Name())
+			if !ok || reflect.DeepEqual(previousTarget.WriteRelabelConfigs, target.WriteRelabelConfigs) {
+				continue
+			}
+
+			options, err := forwardOptions(target)
+			if err != nil {
+				return nil, fmt.Errorf("invalid forward target %s: %w", forwarder.Name(), err)
+			}
+			relabelConfigs[forwarder] = options.RelabelConfigs
+		}
+
+		if len(relabelConfigs) == 0 {
+			return nil, nil
+		}
+
+		return func(apply bool) {
+			if !apply {
+				return
+			}
+
+			for forwarder, configs := range relabelConfigs {
+				forwarder.SetRelabelConfigs(configs)
+			}
+		}, nil
+	}
+}
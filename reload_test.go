@@ -0,0 +1,190 @@
+package promsentry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/sentry"
+)
+
+// newTestReloader starts a Reloader for the configuration file, with a Sentry client bound to the returned hub.
+func newTestReloader(t *testing.T, filePath string) (*Reloader, *sentry.Hub) {
+	t.Helper()
+
+	configuration, err := ParseConfiguration(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewSentryClient(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := sentry.NewHub(client, sentry.NewScope())
+	t.Cleanup(func() {
+		closeTransport(hub.Client().Transport)
+	})
+
+	reloader := NewReloader(filePath, configuration, nil)
+	reloader.Register(ReloadSentryClient(hub, time.Second))
+	return reloader, hub
+}
+
+func rewriteConfigurationFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadInvalidConfigurationKeepsClient(t *testing.T) {
+	filePath := writeConfigurationFile(t, "config.yaml", "sentry_dsn: https://public@example.com/1\n")
+	reloader, hub := newTestReloader(t, filePath)
+	configuration, client := reloader.Configuration(), hub.Client()
+
+	rewriteConfigurationFile(t, filePath, "sentry_dsn: https://public@example.com/2\n"+
+		"transport:\n  compression: gzip\n  compression_level: 42\n")
+	if err := reloader.Reload(); err == nil || !strings.Contains(err.Error(), "compression") {
+		t.Fatalf("expected the compression level to be rejected, got %v", err)
+	}
+
+	if reloader.Configuration() != configuration {
+		t.Error("expected the previous configuration to be kept")
+	}
+	if hub.Client() != client {
+		t.Error("expected the previous client to stay bound")
+	}
+}
+
+func TestReloadDryRunWithForwardTargets(t *testing.T) {
+	filePath := writeConfigurationFile(t, "config.yaml", "dry_run: true\n"+
+		"forward:\n  - url: http://mimir:9009/api/v1/push\n")
+	reloader, _ := newTestReloader(t, filePath)
+
+	rewriteConfigurationFile(t, filePath, "dry_run: false\n"+
+		"forward:\n  - url: http://mimir:9009/api/v1/push\n")
+	if err := reloader.Reload(); err == nil || !strings.Contains(err.Error(), "dry_run requires a restart") {
+		t.Fatalf("expected the dry run change to be rejected, got %v", err)
+	}
+	if !reloader.Configuration().DryRun {
+		t.Error("expected dry run to stay on")
+	}
+}
+
+func TestReloadReplacesClient(t *testing.T) {
+	filePath := writeConfigurationFile(t, "config.yaml", "listen_address: 127.0.0.1:3000\n"+
+		"sentry_dsn: https://public@example.com/1\n")
+	reloader, hub := newTestReloader(t, filePath)
+	client := hub.Client()
+
+	rewriteConfigurationFile(t, filePath, "listen_address: 127.0.0.1:4000\n"+
+		"sentry_dsn: https://public@example.com/2\n")
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if hub.Client() == client {
+		t.Fatal("expected a new client to be bound")
+	}
+	if dsn := hub.Client().Options().Dsn; dsn != "https://public@example.com/2" {
+		t.Errorf("expected the new DSN, got %q", dsn)
+	}
+
+	configuration := reloader.Configuration()
+	if configuration.SentryDsn != "https://public@example.com/2" {
+		t.Errorf("expected the new DSN to be applied, got %q", configuration.SentryDsn)
+	}
+	if configuration.ListenAddress != "127.0.0.1:3000" {
+		t.Errorf("expected listen_address to keep the value it requires a restart for, got %q",
+			configuration.ListenAddress)
+	}
+}
+
+func TestReloadAppliesLimitsTagsAndRelabelConfigs(t *testing.T) {
+	filePath := writeConfigurationFile(t, "config.yaml", "environment: production\n"+
+		"limits:\n  max_series_per_request: 10\n  read_timeout: 1m\n"+
+		"forward:\n  - name: mimir\n    url: http://mimir:9009/api/v1/push\n")
+	reloader, _ := newTestReloader(t, filePath)
+	configuration := reloader.Configuration()
+
+	converter := pipeline.NewConverter(discardSink{}, NewConversionOptions(configuration))
+	reloader.Register(ReloadConversion(converter))
+	limiter := NewRequestLimiter(NewServerLimits(configuration))
+	reloader.Register(ReloadLimits(limiter))
+	forwarders, err := CreateForwarders(configuration, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forwarders[0].Close(context.Background())
+	reloader.Register(ReloadForwarders(forwarders))
+
+	rewriteConfigurationFile(t, filePath, "environment: staging\n"+
+		"limits:\n  max_series_per_request: 20\n  read_timeout: 2m\n"+
+		"forward:\n  - name: mimir\n    url: http://mimir:9009/api/v1/push\n"+
+		"    write_relabel_configs:\n      - source_labels: [job]\n        regex: debug\n        action: drop\n")
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if environment := converter.Options().Tags.Environment; environment != "staging" {
+		t.Errorf("expected the new environment, got %q", environment)
+	}
+	if limits := limiter.current().limits; limits.MaxSeriesPerRequest != 20 {
+		t.Errorf("expected the new series limit, got %d", limits.MaxSeriesPerRequest)
+	}
+
+	configuration = reloader.Configuration()
+	if len(configuration.Forward[0].WriteRelabelConfigs) != 1 {
+		t.Errorf("expected the new write_relabel_configs to be applied, got %+v", configuration.Forward[0])
+	}
+	if configuration.Limits.ReadTimeout != Duration(time.Minute) {
+		t.Errorf("expected read_timeout to keep the value it requires a restart for, got %v",
+			time.Duration(configuration.Limits.ReadTimeout))
+	}
+}
+
+func TestReloadTakesOverDiskQueue(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "http://", "http://public@", 1) + "/1"
+	directory := t.TempDir()
+	filePath := writeConfigurationFile(t, "config.yaml", "sentry_dsn: "+dsn+"\n"+
+		"transport:\n  queue:\n    directory: "+directory+"\n")
+	reloader, hub := newTestReloader(t, filePath)
+	previous := hub.Client()
+
+	rewriteConfigurationFile(t, filePath, "sentry_dsn: "+dsn+"\n"+
+		"transport:\n  queue:\n    directory: "+directory+"\n    max_size: 1048576\n")
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	transport, ok := hub.Client().Transport.(*sentry.DiskTransport)
+	if !ok {
+		t.Fatalf("expected a DiskTransport, got %T", hub.Client().Transport)
+	}
+	if transport.Previous != previous.Transport || transport.MaxSize != 1048576 {
+		t.Fatalf("expected the new transport to take the queue over, got %+v", transport)
+	}
+
+	// The previous client still delivers through the queue that was taken over.
+	previous.Transport.SendEvent(previous.EventFromMetric(sentry.Metric("foo:1|c")))
+	hub.Client().Transport.SendEvent(hub.Client().EventFromMetric(sentry.Metric("bar:1|c")))
+	if !hub.Client().Flush(time.Second * 5) {
+		t.Fatal("Flush timed out")
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 envelopes, got %d", n)
+	}
+}
@@ -0,0 +1,96 @@
+package promsentry
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aldy505/promsentry/scrape"
+)
+
+// CreateScrapers creates a Scraper for every scrape config of the configuration. The scrapers write to writer,
+// usually a pipeline.Converter that shares the sinks of the server, until the context given to their Run method is
+// done.
+func CreateScrapers(configuration *Configuration, writer scrape.Writer, logger *slog.Logger) ([]*scrape.Scraper, error) {
+	var scrapers []*scrape.Scraper
+	for _, scrapeConfig := range configuration.ScrapeConfigs {
+		options, err := scrapeOptions(scrapeConfig)
+		if err != nil {
+			return nil, err
+		}
+		options.Logger = logger
+
+		scraper, err := scrape.New(scrapeConfig.JobName, writer, options)
+		if err != nil {
+			return nil, err
+		}
+		scrapers = append(scrapers, scraper)
+	}
+
+	return scrapers, nil
+}
+
+// scrapeOptions converts the scrape config. The file_sd files are all read again at the shortest refresh interval.
+func scrapeOptions(scrapeConfig ScrapeConfig) (scrape.Options, error) {
+	options := scrape.Options{
+		Interval:    time.Duration(scrapeConfig.ScrapeInterval),
+		Timeout:     time.Duration(scrapeConfig.ScrapeTimeout),
+		MetricsPath: scrapeConfig.MetricsPath,
+		Scheme:      scrapeConfig.Scheme,
+	}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		options.StaticConfigs = append(options.StaticConfigs, scrape.TargetGroup{
+			Targets: staticConfig.Targets,
+			Labels:  staticConfig.Labels,
+		})
+	}
+
+	for _, fileSDConfig := range scrapeConfig.FileSDConfigs {
+		options.Files = append(options.Files, fileSDConfig.Files...)
+		refreshInterval := time.Duration(fileSDConfig.RefreshInterval)
+		if refreshInterval > 0 && (options.RefreshInterval == 0 || refreshInterval < options.RefreshInterval) {
+			options.RefreshInterval = refreshInterval
+		}
+	}
+
+	for i, c := range scrapeConfig.MetricRelabelConfigs {
+		relabelConfig, err := c.relabelConfig()
+		if err != nil {
+			return scrape.Options{}, fmt.Errorf("invalid metric_relabel_configs #%d: %w", i+1, err)
+		}
+		options.RelabelConfigs = append(options.RelabelConfigs, relabelConfig)
+	}
+
+	return options, nil
+}
+
+// validateScrapeConfigs checks that every scrape config has a unique job name, targets, and valid options.
+func validateScrapeConfigs(configuration *Configuration) error {
+	jobs := make(map[string]bool)
+	for i, scrapeConfig := range configuration.ScrapeConfigs {
+		if jobs[scrapeConfig.JobName] {
+			return fmt.Errorf("invalid scrape configuration #%d: duplicate job_name %q", i+1, scrapeConfig.JobName)
+		}
+		jobs[scrapeConfig.JobName] = true
+
+		options, err := scrapeOptions(scrapeConfig)
+		if err != nil {
+			return fmt.Errorf("invalid scrape configuration #%d: %w", i+1, err)
+		}
+
+		hasTargets := len(options.Files) > 0
+		for _, group := range options.StaticConfigs {
+			hasTargets = hasTargets || len(group.Targets) > 0
+		}
+		if !hasTargets {
+			return fmt.Errorf("invalid scrape configuration #%d: static_configs or file_sd_configs is required", i+1)
+		}
+
+		if _, err := scrape.New(scrapeConfig.JobName, nil, options); err != nil {
+			return fmt.Errorf("invalid scrape configuration #%d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
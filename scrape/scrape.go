@@ -0,0 +1,436 @@
+// Package scrape lets promsentry scrape the /metrics endpoint of targets
+// itself, for small deployments that don't run Prometheus. The scraped
+// samples go through the same conversion as remote write requests, along
+// with the synthetic up, scrape_duration_seconds, scrape_samples_scraped and
+// scrape_samples_post_metric_relabeling series of Prometheus.
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/telemetry"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultInterval        = time.Minute
+	defaultTimeout         = time.Second * 10
+	defaultMetricsPath     = "/metrics"
+	defaultScheme          = "http"
+	defaultRefreshInterval = time.Minute * 5
+	// maxBodyBytes bounds the size of a scraped page.
+	maxBodyBytes = 64 << 20
+
+	acceptHeader = "application/openmetrics-text;version=1.0.0;q=0.5," +
+		"text/plain;version=0.0.4;q=0.4," +
+		"application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.3," +
+		"*/*;q=0.1"
+)
+
+// Writer receives the scraped time series. pipeline.Converter implements it.
+type Writer interface {
+	WriteSeries(ctx context.Context, timeseries []prompb.TimeSeries) error
+}
+
+// TargetGroup is a set of targets that share the same labels, as in the
+// static_configs of Prometheus and in file_sd files.
+type TargetGroup struct {
+	// Targets are host:port addresses.
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// Options configures a Scraper.
+type Options struct {
+	// Interval between two scrapes of a target. Defaults to one minute.
+	Interval time.Duration
+	// Timeout of a scrape. Defaults to 10 seconds, or Interval when it is
+	// shorter.
+	Timeout time.Duration
+	// MetricsPath is the path of the metrics on the targets. Defaults to
+	// /metrics.
+	MetricsPath string
+	// Scheme is http or https. Defaults to http.
+	Scheme string
+	// StaticConfigs are the targets that never change.
+	StaticConfigs []TargetGroup
+	// Files are the file_sd files, in JSON or YAML, holding a list of target
+	// groups. The last path element may be a glob, like targets/*.json.
+	Files []string
+	// RefreshInterval is the interval the Files are read again at. Defaults
+	// to 5 minutes.
+	RefreshInterval time.Duration
+	// RelabelConfigs are applied to the scraped time series, before the
+	// labels of the target are added.
+	RelabelConfigs []*relabel.Config
+	// Client sends the scrape requests. Defaults to a client using
+	// http.DefaultTransport.
+	Client *http.Client
+	// Logger receives the scrape errors. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Scraper scrapes the targets of a job and writes the time series to a
+// Writer.
+type Scraper struct {
+	job     string
+	writer  Writer
+	options Options
+	client  *http.Client
+	logger  *slog.Logger
+
+	// fileGroups are the target groups last read from each file, kept when
+	// the file can't be read anymore.
+	fileGroups map[string][]TargetGroup
+}
+
+// target is a scrape target with its labels.
+type target struct {
+	url    string
+	labels labels.Labels
+}
+
+// New creates a Scraper for the job. The name of the job is the job label of
+// its time series.
+func New(job string, writer Writer, options Options) (*Scraper, error) {
+	if job == "" {
+		return nil, errors.New("job name is required")
+	}
+
+	if options.Interval <= 0 {
+		options.Interval = defaultInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = min(defaultTimeout, options.Interval)
+	}
+	if options.Timeout > options.Interval {
+		return nil, fmt.Errorf("scrape timeout %s is greater than the scrape interval %s", options.Timeout, options.Interval)
+	}
+	if options.MetricsPath == "" {
+		options.MetricsPath = defaultMetricsPath
+	}
+	if options.Scheme == "" {
+		options.Scheme = defaultScheme
+	}
+	if options.Scheme != "http" && options.Scheme != "https" {
+		return nil, fmt.Errorf("invalid scheme %q, use http or https", options.Scheme)
+	}
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = defaultRefreshInterval
+	}
+	for _, file := range options.Files {
+		if _, err := filepath.Match(filepath.Base(file), ""); err != nil {
+			return nil, fmt.Errorf("invalid file_sd path %q: %w", file, err)
+		}
+	}
+
+	client := options.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Scraper{
+		job:        job,
+		writer:     writer,
+		options:    options,
+		client:     client,
+		logger:     logger.With("job", job),
+		fileGroups: make(map[string][]TargetGroup),
+	}, nil
+}
+
+// Job returns the name of the job.
+func (s *Scraper) Job() string {
+	return s.job
+}
+
+// Run scrapes the targets until the context is done. Each target is scraped
+// right away, then every Interval. The file_sd files are read again every
+// RefreshInterval, new targets start being scraped and removed ones stop.
+func (s *Scraper) Run(ctx context.Context) {
+	running := make(map[string]context.CancelFunc)
+	var wg sync.WaitGroup
+	defer func() {
+		for _, cancel := range running {
+			cancel()
+		}
+		wg.Wait()
+		telemetry.ScrapeTargets.WithLabelValues(s.job).Set(0)
+	}()
+
+	ticker := time.NewTicker(s.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		current := make(map[string]bool)
+		for _, t := range s.targets() {
+			key := t.url + t.labels.String()
+			current[key] = true
+			if _, ok := running[key]; ok {
+				continue
+			}
+
+			targetCtx, cancel := context.WithCancel(ctx)
+			running[key] = cancel
+			wg.Add(1)
+			go func(t target) {
+				defer wg.Done()
+				s.loop(targetCtx, t)
+			}(t)
+		}
+		for key, cancel := range running {
+			if !current[key] {
+				cancel()
+				delete(running, key)
+			}
+		}
+		telemetry.ScrapeTargets.WithLabelValues(s.job).Set(float64(len(running)))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// loop scrapes the target every Interval until the context is done.
+func (s *Scraper) loop(ctx context.Context, t target) {
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.scrape(ctx, t)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrape scrapes the target once and writes its time series, followed by the
+// synthetic ones. Nothing is written if the context is done meanwhile.
+func (s *Scraper) scrape(ctx context.Context, t target) {
+	start := time.Now()
+	timeseries, err := s.fetch(ctx, t, start)
+	duration := time.Since(start)
+	if ctx.Err() != nil {
+		return
+	}
+
+	telemetry.ScrapeDuration.WithLabelValues(s.job).Observe(duration.Seconds())
+	up := 1.0
+	// Like Prometheus, the scraped samples are counted before the metric relabeling drops any.
+	scraped := len(timeseries)
+	if err != nil {
+		up = 0
+		scraped = 0
+		timeseries = nil
+		telemetry.Scrapes.WithLabelValues(s.job, "failure").Inc()
+		s.logger.WarnContext(ctx, "Unable to scrape the target", "target", t.url, "error", err)
+	} else {
+		telemetry.Scrapes.WithLabelValues(s.job, "success").Inc()
+		timeseries = s.relabel(timeseries, t.labels)
+	}
+
+	timestamp := start.UnixMilli()
+	timeseries = append(timeseries,
+		synthetic("up", up, timestamp, t.labels),
+		synthetic("scrape_duration_seconds", duration.Seconds(), timestamp, t.labels),
+		synthetic("scrape_samples_scraped", float64(scraped), timestamp, t.labels),
+		synthetic("scrape_samples_post_metric_relabeling", float64(len(timeseries)), timestamp, t.labels),
+	)
+
+	if err := s.writer.WriteSeries(ctx, timeseries); err != nil {
+		s.logger.ErrorContext(ctx, "Unable to write the scraped time series", "target", t.url, "error", err)
+	}
+}
+
+// fetch requests the metrics of the target and parses them according to the
+// content type of the response.
+func (s *Scraper) fetch(ctx context.Context, t target, now time.Time) ([]prompb.TimeSeries, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", acceptHeader)
+	request.Header.Set("User-Agent", "promsentry")
+	request.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(s.options.Timeout.Seconds(), 'f', -1, 64))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return nil, fmt.Errorf("server returned HTTP status %s", response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodyBytes {
+		return nil, fmt.Errorf("the response exceeds the limit of %d bytes", maxBodyBytes)
+	}
+
+	return pipeline.ParseText(body, response.Header.Get("Content-Type"), now)
+}
+
+// relabel applies the relabel configurations to the time series, then adds
+// the labels of the target. Like Prometheus, a scraped label that conflicts
+// with a label of the target is renamed with an exported_ prefix.
+func (s *Scraper) relabel(timeseries []prompb.TimeSeries, targetLabels labels.Labels) []prompb.TimeSeries {
+	relabeled := timeseries[:0]
+	for _, series := range timeseries {
+		builder := labels.NewBuilder(labels.EmptyLabels())
+		for _, l := range series.GetLabels() {
+			builder.Set(l.GetName(), l.GetValue())
+		}
+
+		lbls, keep := relabel.Process(builder.Labels(), s.options.RelabelConfigs...)
+		if !keep {
+			continue
+		}
+
+		builder.Reset(lbls)
+		targetLabels.Range(func(l labels.Label) {
+			if existing := lbls.Get(l.Name); existing != "" {
+				builder.Set("exported_"+l.Name, existing)
+			}
+			builder.Set(l.Name, l.Value)
+		})
+
+		series.Labels = labelsToProto(builder.Labels())
+		relabeled = append(relabeled, series)
+	}
+	return relabeled
+}
+
+// synthetic returns a time series that describes the scrape of a target.
+func synthetic(name string, value float64, timestamp int64, targetLabels labels.Labels) prompb.TimeSeries {
+	builder := labels.NewBuilder(targetLabels)
+	builder.Set(labels.MetricName, name)
+	return prompb.TimeSeries{
+		Labels:  labelsToProto(builder.Labels()),
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+}
+
+func labelsToProto(lbls labels.Labels) []prompb.Label {
+	result := make([]prompb.Label, 0, lbls.Len())
+	lbls.Range(func(l labels.Label) {
+		result = append(result, prompb.Label{Name: l.Name, Value: l.Value})
+	})
+	return result
+}
+
+// targets returns the targets of the static configs and of the files.
+func (s *Scraper) targets() []target {
+	groups := append([]TargetGroup(nil), s.options.StaticConfigs...)
+	groups = append(groups, s.readFiles()...)
+
+	var targets []target
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		for _, address := range group.Targets {
+			builder := labels.NewBuilder(labels.EmptyLabels())
+			builder.Set("job", s.job)
+			builder.Set("instance", address)
+			for name, value := range group.Labels {
+				builder.Set(name, value)
+			}
+
+			t := target{
+				url:    s.options.Scheme + "://" + address + s.options.MetricsPath,
+				labels: builder.Labels(),
+			}
+			if key := t.url + t.labels.String(); !seen[key] {
+				seen[key] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+	return targets
+}
+
+// readFiles reads the target groups of the file_sd files. A file that can't
+// be read keeps the target groups it had.
+func (s *Scraper) readFiles() []TargetGroup {
+	var paths []string
+	for _, pattern := range s.options.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			s.logger.Warn("Invalid file_sd path", "path", pattern, "error", err)
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	fileGroups := make(map[string][]TargetGroup, len(paths))
+	var groups []TargetGroup
+	for _, path := range paths {
+		fileGroup, err := readTargetGroups(path)
+		if err != nil {
+			s.logger.Warn("Unable to read the file_sd file, keeping its previous targets", "path", path, "error", err)
+			fileGroup = s.fileGroups[path]
+		}
+		fileGroups[path] = fileGroup
+		groups = append(groups, fileGroup...)
+	}
+	s.fileGroups = fileGroups
+
+	return groups
+}
+
+// readTargetGroups reads a file_sd file, in JSON or YAML depending on its
+// extension.
+func readTargetGroups(path string) ([]TargetGroup, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []TargetGroup
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &groups)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, &groups)
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q, use .json, .yml or .yaml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,222 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v3"
+)
+
+// channelWriter sends the written time series on a channel.
+type channelWriter chan []prompb.TimeSeries
+
+func (w channelWriter) WriteSeries(ctx context.Context, timeseries []prompb.TimeSeries) error {
+	w <- timeseries
+	return nil
+}
+
+// seriesLabels returns the labels of the time series with the given name.
+func seriesLabels(timeseries []prompb.TimeSeries, name string) map[string]string {
+	for _, series := range timeseries {
+		lbls := make(map[string]string)
+		for _, l := range series.GetLabels() {
+			lbls[l.GetName()] = l.GetValue()
+		}
+		if lbls["__name__"] == name {
+			return lbls
+		}
+	}
+	return nil
+}
+
+func seriesValue(timeseries []prompb.TimeSeries, name string) float64 {
+	for _, series := range timeseries {
+		for _, l := range series.GetLabels() {
+			if l.GetName() == "__name__" && l.GetValue() == name {
+				return series.GetSamples()[0].GetValue()
+			}
+		}
+	}
+	return -1
+}
+
+func receive(t *testing.T, writer channelWriter) []prompb.TimeSeries {
+	t.Helper()
+
+	select {
+	case timeseries := <-writer:
+		return timeseries
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for a scrape")
+		return nil
+	}
+}
+
+func TestScraper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/custom/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			t.Errorf("unexpected Accept header %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte("requests_total{code=\"200\",job=\"app\"} 12\ndebug_info 1\n"))
+	}))
+	defer server.Close()
+
+	var dropDebug relabel.Config
+	if err := yaml.Unmarshal([]byte("source_labels: [__name__]\nregex: debug_.*\naction: drop\n"), &dropDebug); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := make(channelWriter, 1)
+	scraper, err := New("services", writer, Options{
+		Interval:    time.Hour,
+		MetricsPath: "/custom/metrics",
+		StaticConfigs: []TargetGroup{{
+			Targets: []string{strings.TrimPrefix(server.URL, "http://")},
+			Labels:  map[string]string{"region": "eu"},
+		}},
+		RelabelConfigs: []*relabel.Config{&dropDebug},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scraper.Run(ctx)
+		close(done)
+	}()
+	timeseries := receive(t, writer)
+	cancel()
+	<-done
+
+	instance := strings.TrimPrefix(server.URL, "http://")
+	requests := seriesLabels(timeseries, "requests_total")
+	if requests["job"] != "services" || requests["exported_job"] != "app" || requests["instance"] != instance ||
+		requests["region"] != "eu" || requests["code"] != "200" {
+		t.Errorf("unexpected labels %v", requests)
+	}
+	if seriesLabels(timeseries, "debug_info") != nil {
+		t.Error("expected debug_info to be dropped by the relabel configuration")
+	}
+
+	if up := seriesValue(timeseries, "up"); up != 1 {
+		t.Errorf("expected up to be 1, got %v", up)
+	}
+	if samples := seriesValue(timeseries, "scrape_samples_scraped"); samples != 2 {
+		t.Errorf("expected 2 scraped samples, got %v", samples)
+	}
+	if samples := seriesValue(timeseries, "scrape_samples_post_metric_relabeling"); samples != 1 {
+		t.Errorf("expected 1 sample after relabeling, got %v", samples)
+	}
+	if lbls := seriesLabels(timeseries, "up"); lbls["job"] != "services" || lbls["instance"] != instance {
+		t.Errorf("expected up to carry the target labels, got %v", lbls)
+	}
+}
+
+func TestScraperFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	writer := make(channelWriter, 1)
+	scraper, err := New("services", writer, Options{
+		StaticConfigs: []TargetGroup{{Targets: []string{strings.TrimPrefix(server.URL, "http://")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scraper.Run(ctx)
+
+	timeseries := receive(t, writer)
+	if len(timeseries) != 4 {
+		t.Errorf("expected only the synthetic time series, got %d", len(timeseries))
+	}
+	if up := seriesValue(timeseries, "up"); up != 0 {
+		t.Errorf("expected up to be 0, got %v", up)
+	}
+}
+
+func TestScraperFileSD(t *testing.T) {
+	directory := t.TempDir()
+	jsonPath := filepath.Join(directory, "a.json")
+	yamlPath := filepath.Join(directory, "b.yml")
+	if err := os.WriteFile(jsonPath, []byte(`[{"targets": ["a:9100", "b:9100"], "labels": {"team": "web"}}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(yamlPath, []byte("- targets: [\"c:9100\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	scraper, err := New("nodes", make(channelWriter), Options{
+		Scheme: "https",
+		Files:  []string{filepath.Join(directory, "*.json"), yamlPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := scraper.targets()
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 targets, got %d", len(targets))
+	}
+	if targets[0].url != "https://a:9100/metrics" || targets[0].labels.Get("team") != "web" {
+		t.Errorf("unexpected target %+v", targets[0])
+	}
+
+	// A broken file keeps its previous targets.
+	if err := os.WriteFile(jsonPath, []byte(`[{"targets": [`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if targets := scraper.targets(); len(targets) != 3 {
+		t.Errorf("expected the previous targets to be kept, got %d", len(targets))
+	}
+
+	// A removed file removes its targets.
+	if err := os.Remove(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	if targets := scraper.targets(); len(targets) != 1 || targets[0].labels.Get("instance") != "c:9100" {
+		t.Errorf("expected only the target of the YAML file, got %+v", targets)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     string
+		options Options
+		message string
+	}{
+		{"no job", "", Options{}, "job name is required"},
+		{"timeout", "job", Options{Interval: time.Second, Timeout: time.Minute}, "greater than the scrape interval"},
+		{"scheme", "job", Options{Scheme: "ftp"}, "invalid scheme"},
+		{"files", "job", Options{Files: []string{"targets/[.json"}}, "invalid file_sd path"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(test.job, make(channelWriter), test.options)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error with %q, got %v", test.message, err)
+			}
+		})
+	}
+}
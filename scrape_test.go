@@ -0,0 +1,82 @@
+package promsentry
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScrapeOptions(t *testing.T) {
+	options, err := scrapeOptions(ScrapeConfig{
+		JobName:        "node",
+		ScrapeInterval: Duration(time.Second * 30),
+		StaticConfigs:  []StaticConfig{{Targets: []string{"node:9100"}, Labels: map[string]string{"region": "eu"}}},
+		FileSDConfigs: []FileSDConfig{
+			{Files: []string{"targets/*.json"}, RefreshInterval: Duration(time.Minute * 5)},
+			{Files: []string{"targets/*.yml"}, RefreshInterval: Duration(time.Minute)},
+		},
+		MetricRelabelConfigs: []RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "go_.*", Action: "drop"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.Interval != time.Second*30 || len(options.StaticConfigs) != 1 || options.StaticConfigs[0].Labels["region"] != "eu" {
+		t.Errorf("unexpected options %+v", options)
+	}
+	if len(options.Files) != 2 || options.RefreshInterval != time.Minute {
+		t.Errorf("expected both file_sd configs to be read every minute, got %+v", options)
+	}
+	if len(options.RelabelConfigs) != 1 || options.RelabelConfigs[0].Regex.String() != "go_.*" {
+		t.Errorf("unexpected relabel configs %+v", options.RelabelConfigs)
+	}
+}
+
+func TestValidateScrapeConfigs(t *testing.T) {
+	tests := []struct {
+		name          string
+		scrapeConfigs []ScrapeConfig
+		want          string
+	}{
+		{
+			name: "duplicate job",
+			scrapeConfigs: []ScrapeConfig{
+				{JobName: "node", StaticConfigs: []StaticConfig{{Targets: []string{"a:9100"}}}},
+				{JobName: "node", StaticConfigs: []StaticConfig{{Targets: []string{"b:9100"}}}},
+			},
+			want: "duplicate job_name",
+		},
+		{
+			name:          "no targets",
+			scrapeConfigs: []ScrapeConfig{{JobName: "node"}},
+			want:          "static_configs or file_sd_configs is required",
+		},
+		{
+			name: "timeout",
+			scrapeConfigs: []ScrapeConfig{{
+				JobName:        "node",
+				ScrapeInterval: Duration(time.Second * 15),
+				ScrapeTimeout:  Duration(time.Minute),
+				StaticConfigs:  []StaticConfig{{Targets: []string{"a:9100"}}},
+			}},
+			want: "greater than the scrape interval",
+		},
+		{
+			name: "relabel",
+			scrapeConfigs: []ScrapeConfig{{
+				JobName:              "node",
+				StaticConfigs:        []StaticConfig{{Targets: []string{"a:9100"}}},
+				MetricRelabelConfigs: []RelabelConfig{{Action: "hashmod", TargetLabel: "shard"}},
+			}},
+			want: "invalid metric_relabel_configs #1",
+		},
+	}
+
+	for _, tt := range tests {
+		configuration := &Configuration{ScrapeConfigs: tt.scrapeConfigs}
+		err := validateScrapeConfigs(configuration)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package sentry
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"io"
 	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"os"
@@ -79,6 +81,35 @@ type usageError struct {
 // can be enabled by either using Logger.SetOutput directly or with Debug client option.
 var Logger = log.New(io.Discard, "[Sentry] ", log.LstdFlags)
 
+// newLogger returns the structured logger of the transports. Without a
+// logger in the options, records are written to Logger, so that they follow
+// the Debug option.
+func newLogger(options ClientOptions) *slog.Logger {
+	if options.Logger != nil {
+		return options.Logger
+	}
+	return slog.New(slog.NewTextHandler(loggerWriter{}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// loggerWriter writes to Logger.
+type loggerWriter struct{}
+
+func (loggerWriter) Write(p []byte) (int, error) {
+	Logger.Print(string(p))
+	return len(p), nil
+}
+
+// slogWriter writes the lines of Logger as debug records of a structured
+// logger.
+type slogWriter struct {
+	logger *slog.Logger
+}
+
+func (w slogWriter) Write(p []byte) (int, error) {
+	w.logger.Debug(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
 // EventProcessor is a function that processes an event.
 // Event processors are used to change an event before it is sent to Sentry.
 type EventProcessor func(event *Event, hint *EventHint) *Event
@@ -128,6 +159,10 @@ type ClientOptions struct {
 
 	// io.Writer implementation that should be used with the Debug mode.
 	DebugWriter io.Writer
+	// Logger receives the structured logs of the transports, and the output
+	// of the package level Logger as debug records. It takes precedence over
+	// Debug and DebugWriter.
+	Logger *slog.Logger
 	// The transport to use. Defaults to HTTPTransport.
 	Transport Transport
 	// The server name to be reported.
@@ -162,11 +197,11 @@ type ClientOptions struct {
 	Environment string
 	// An optional pointer to http.Client that will be used with a default
 	// HTTPTransport. Using your own client will make HTTPTransport, HTTPProxy,
-	// HTTPSProxy and CaCerts options ignored.
+	// HTTPSProxy and the TLS options ignored.
 	HTTPClient *http.Client
 	// An optional pointer to http.Transport that will be used with a default
 	// HTTPTransport. Using your own transport will make HTTPProxy, HTTPSProxy
-	// and CaCerts options ignored.
+	// and the TLS options ignored.
 	HTTPTransport http.RoundTripper
 	// An optional HTTP proxy to use.
 	// This will default to the HTTP_PROXY environment variable.
@@ -177,8 +212,27 @@ type ClientOptions struct {
 	HTTPSProxy string
 	// An optional set of SSL certificates to use.
 	CaCerts *x509.CertPool
+	// Optional client certificates, presented when Sentry or the proxy
+	// requires mutual TLS.
+	ClientCertificates []tls.Certificate
+	// An optional minimum TLS version, like tls.VersionTLS12. Zero leaves
+	// the default of crypto/tls.
+	TLSMinVersion uint16
 	// Default event tags. These are overridden by tags set on a scope.
 	Tags map[string]string
+	// Maximum size in bytes of the statsd payload of a single envelope.
+	// Larger metrics are split into several envelopes, without breaking
+	// lines. Defaults to 1 MiB.
+	MaxMetricItemBytes int
+	// Maximum number of statsd lines in a single envelope. Zero means no
+	// limit.
+	MaxMetricItemLines int
+	// Whether the transport periodically reports the events it discarded to
+	// Sentry, so that they show up in the usage stats of the organization.
+	SendClientReports bool
+	// TransportMetrics receives the measurements of the transport, like the
+	// envelopes sent and dropped. They are discarded when it is nil.
+	TransportMetrics TransportMetrics
 }
 
 // Client is the underlying processor that is used by the main API and Hub
@@ -226,7 +280,11 @@ func NewClient(options ClientOptions) (*Client, error) {
 		options.SampleRate = 1.0
 	}
 
-	if options.Debug {
+	if options.Logger != nil {
+		Logger.SetFlags(0)
+		Logger.SetPrefix("")
+		Logger.SetOutput(slogWriter{logger: options.Logger})
+	} else if options.Debug {
 		debugWriter := options.DebugWriter
 		if debugWriter == nil {
 			debugWriter = os.Stderr
@@ -238,6 +296,10 @@ func NewClient(options ClientOptions) (*Client, error) {
 		options.Dsn = os.Getenv("SENTRY_DSN")
 	}
 
+	if options.MaxMetricItemBytes == 0 {
+		options.MaxMetricItemBytes = defaultMaxMetricItemBytes
+	}
+
 	if options.Release == "" {
 		options.Release = defaultRelease()
 	}
@@ -331,9 +393,18 @@ func (client *Client) Options() ClientOptions {
 	return client.options
 }
 
+// CaptureMetric captures a statsd payload. Payloads over the
+// MaxMetricItemBytes or MaxMetricItemLines limits are sent as several events.
+// The return value is the ID of the last captured event.
 func (client *Client) CaptureMetric(metric Metric) *EventID {
-	event := client.EventFromMetric(metric)
-	return client.CaptureEvent(event, nil, nil)
+	var eventID *EventID
+	for _, chunk := range splitMetric(metric, client.options.MaxMetricItemBytes, client.options.MaxMetricItemLines) {
+		event := client.EventFromMetric(chunk)
+		if id := client.CaptureEvent(event, nil, nil); id != nil {
+			eventID = id
+		}
+	}
+	return eventID
 }
 
 // CaptureEvent captures an event on the currently active client if any.
@@ -363,7 +434,7 @@ func (client *Client) Flush(timeout time.Duration) bool {
 func (client *Client) EventFromMetric(metric Metric) *Event {
 	event := NewEvent()
 	event.metrics = metric
-	event.Type = "statsd"
+	event.Type = metricType
 	return event
 }
 
@@ -404,8 +475,10 @@ func (client *Client) processEvent(event *Event, hint *EventHint, scope EventMod
 	}
 	if client.options.BeforeSend != nil {
 		// All other events
+		category, quantity := categoryFor(event.Type), eventQuantity(event)
 		if event = client.options.BeforeSend(event, hint); event == nil {
 			Logger.Println("Event dropped due to BeforeSend callback.")
+			clientReports.record(discardReasonBeforeSend, category, quantity)
 			return nil
 		}
 	}
@@ -452,9 +525,12 @@ func (client *Client) prepareEvent(event *Event, hint *EventHint, scope EventMod
 		}},
 	}
 
+	category, quantity := categoryFor(event.Type), eventQuantity(event)
+
 	if scope != nil {
 		event = scope.ApplyToEvent(event, hint)
 		if event == nil {
+			clientReports.record(discardReasonEventProcessor, category, quantity)
 			return nil
 		}
 	}
@@ -464,6 +540,7 @@ func (client *Client) prepareEvent(event *Event, hint *EventHint, scope EventMod
 		event = processor(event, hint)
 		if event == nil {
 			Logger.Printf("Event dropped by one of the Client EventProcessors: %s\n", id)
+			clientReports.record(discardReasonEventProcessor, category, quantity)
 			return nil
 		}
 	}
@@ -473,6 +550,7 @@ func (client *Client) prepareEvent(event *Event, hint *EventHint, scope EventMod
 		event = processor(event, hint)
 		if event == nil {
 			Logger.Printf("Event dropped by one of the Global EventProcessors: %s\n", id)
+			clientReports.record(discardReasonEventProcessor, category, quantity)
 			return nil
 		}
 	}
@@ -0,0 +1,266 @@
+package sentry
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+)
+
+// clientReportInterval is the minimum time between two client reports sent by
+// a transport, unless it is flushed.
+const clientReportInterval = time.Second * 30
+
+// clientReportCategory is the rate limit category of the envelopes carrying a
+// client report. Sentry never rate limits them.
+const clientReportCategory ratelimit.Category = "internal"
+
+// Reasons for discarding an event, as defined by the client report protocol.
+// Reference: https://develop.sentry.dev/sdk/client-reports/
+const (
+	discardReasonQueueOverflow  = "queue_overflow"
+	discardReasonRateLimit      = "ratelimit_backoff"
+	discardReasonNetworkError   = "network_error"
+	discardReasonBeforeSend     = "before_send"
+	discardReasonEventProcessor = "event_processor"
+	discardReasonInternalError  = "internal_sdk_error"
+)
+
+// clientReports accumulates the events discarded by every client and transport
+// of the process until a transport sends them to Sentry.
+var clientReports = &clientReportRecorder{}
+
+// clientReport is the payload of a client_report envelope item.
+type clientReport struct {
+	Timestamp       time.Time        `json:"timestamp"`
+	DiscardedEvents []discardedEvent `json:"discarded_events"`
+}
+
+type discardedEvent struct {
+	Reason   string `json:"reason"`
+	Category string `json:"category"`
+	Quantity int    `json:"quantity"`
+}
+
+type discardKey struct {
+	reason   string
+	category string
+}
+
+// clientReportRecorder counts discarded events by reason and data category.
+type clientReportRecorder struct {
+	mu        sync.Mutex
+	discarded map[discardKey]int
+}
+
+// record counts quantity events of the given category as discarded.
+func (r *clientReportRecorder) record(reason string, category ratelimit.Category, quantity int) {
+	if reason == "" || quantity <= 0 || category == clientReportCategory {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.discarded == nil {
+		r.discarded = make(map[discardKey]int)
+	}
+	r.discarded[discardKey{reason: reason, category: dataCategory(category)}] += quantity
+}
+
+// take returns the events discarded since the previous call, or nil if there
+// are none.
+func (r *clientReportRecorder) take() *clientReport {
+	r.mu.Lock()
+	discarded := r.discarded
+	r.discarded = nil
+	r.mu.Unlock()
+
+	if len(discarded) == 0 {
+		return nil
+	}
+
+	report := &clientReport{Timestamp: time.Now().UTC()}
+	for key, quantity := range discarded {
+		report.DiscardedEvents = append(report.DiscardedEvents, discardedEvent{
+			Reason:   key.reason,
+			Category: key.category,
+			Quantity: quantity,
+		})
+	}
+	sort.Slice(report.DiscardedEvents, func(i, j int) bool {
+		a, b := report.DiscardedEvents[i], report.DiscardedEvents[j]
+		if a.Reason != b.Reason {
+			return a.Reason < b.Reason
+		}
+		return a.Category < b.Category
+	})
+
+	return report
+}
+
+// restore adds back the counts of a report that could not be sent.
+func (r *clientReportRecorder) restore(report *clientReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.discarded == nil {
+		r.discarded = make(map[discardKey]int)
+	}
+	for _, e := range report.DiscardedEvents {
+		r.discarded[discardKey{reason: e.Reason, category: e.Category}] += e.Quantity
+	}
+}
+
+// clientReportSchedule decides when a transport sends the pending client
+// report.
+type clientReportSchedule struct {
+	enabled bool
+
+	mu   sync.Mutex
+	next time.Time
+
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// run calls send every clientReportInterval in the background until stop is
+// called, so that discarded events are reported when no event is sent.
+func (s *clientReportSchedule) run(send func(force bool)) {
+	if !s.enabled {
+		return
+	}
+
+	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
+
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(clientReportInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				send(false)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop ends the background goroutine started by run and waits for it.
+func (s *clientReportSchedule) stop() {
+	if s == nil || s.done == nil {
+		return
+	}
+
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+	<-s.stopped
+}
+
+// due returns the pending client report if the transport should send it now.
+// Unless force is set, reports are sent at most once per clientReportInterval.
+func (s *clientReportSchedule) due(force bool) *clientReport {
+	if s == nil || !s.enabled {
+		return nil
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	if !force && now.Before(s.next) {
+		s.mu.Unlock()
+		return nil
+	}
+	s.next = now.Add(clientReportInterval)
+	s.mu.Unlock()
+
+	return clientReports.take()
+}
+
+// envelopeFromClientReport serializes a client report into an envelope.
+func envelopeFromClientReport(report *clientReport, dsn *Dsn) (*bytes.Buffer, error) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+
+	// Envelope header
+	err = enc.Encode(struct {
+		SentAt string `json:"sent_at"`
+		Dsn    string `json:"dsn"`
+	}{
+		SentAt: time.Now().UTC().Format(time.RFC3339),
+		Dsn:    dsn.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := encodeEnvelopeItem(enc, "client_report", body); err != nil {
+		return nil, err
+	}
+
+	b.Write(body)
+	b.WriteString("\n")
+
+	return &b, nil
+}
+
+// dataCategory returns the Sentry data category of the events of a rate limit
+// category.
+func dataCategory(c ratelimit.Category) string {
+	switch c {
+	case ratelimit.CategoryStatsd:
+		return "metric_bucket"
+	case ratelimit.CategoryAll:
+		return "default"
+	default:
+		return string(c)
+	}
+}
+
+// eventQuantity returns the number of items of an event that Sentry accounts
+// for, which is the number of statsd lines for a metric event.
+func eventQuantity(event *Event) int {
+	if event.Type == metricType {
+		return metricQuantity(event.metrics)
+	}
+	return 1
+}
+
+// envelopeQuantity is eventQuantity for a serialized envelope.
+func envelopeQuantity(category ratelimit.Category, envelope []byte) int {
+	if category != ratelimit.CategoryStatsd {
+		return 1
+	}
+
+	// Skip the envelope and item headers.
+	parts := bytes.SplitN(envelope, []byte("\n"), 3)
+	if len(parts) < 3 {
+		return 0
+	}
+	return metricQuantity(parts[2])
+}
+
+func metricQuantity(payload []byte) int {
+	quantity := 0
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			quantity++
+		}
+	}
+	return quantity
+}
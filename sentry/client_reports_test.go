@@ -0,0 +1,159 @@
+package sentry
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+	"github.com/aldy505/promsentry/sentry/testutils"
+)
+
+func TestClientReportRecorder(t *testing.T) {
+	r := &clientReportRecorder{}
+	if r.take() != nil {
+		t.Fatal("expected no report from an empty recorder")
+	}
+
+	r.record(discardReasonRateLimit, "statsd", 3)
+	r.record(discardReasonRateLimit, "statsd", 2)
+	r.record(discardReasonQueueOverflow, ratelimit.CategoryError, 1)
+	r.record(discardReasonQueueOverflow, "statsd", 0)
+	r.record(discardReasonQueueOverflow, clientReportCategory, 1)
+
+	report := r.take()
+	assertEqual(t, report.DiscardedEvents, []discardedEvent{
+		{Reason: discardReasonQueueOverflow, Category: "error", Quantity: 1},
+		{Reason: discardReasonRateLimit, Category: "metric_bucket", Quantity: 5},
+	})
+	if r.take() != nil {
+		t.Error("expected the recorder to be reset by take")
+	}
+
+	r.restore(report)
+	r.record(discardReasonRateLimit, "statsd", 1)
+	assertEqual(t, r.take().DiscardedEvents, []discardedEvent{
+		{Reason: discardReasonQueueOverflow, Category: "error", Quantity: 1},
+		{Reason: discardReasonRateLimit, Category: "metric_bucket", Quantity: 6},
+	})
+}
+
+func TestEnvelopeQuantity(t *testing.T) {
+	dsn, _ := NewDsn("http://whatever@example.com/1337")
+	event := (&Client{}).EventFromMetric(Metric("a:1|c\nb:1|c\nc:1|c\n"))
+	assertEqual(t, eventQuantity(event), 3)
+
+	envelope, err := envelopeFromBody(event, dsn, event.Timestamp, event.metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, envelopeQuantity("statsd", envelope.Bytes()), 3)
+}
+
+func TestBeforeSendDropsAreReported(t *testing.T) {
+	clientReports.take()
+
+	client, err := NewClient(ClientOptions{
+		Dsn:       "http://whatever@example.com/1337",
+		Transport: &TransportMock{},
+		BeforeSend: func(event *Event, hint *EventHint) *Event {
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.CaptureMetric(Metric("a:1|c\nb:1|c"))
+
+	assertEqual(t, clientReports.take().DiscardedEvents, []discardedEvent{
+		{Reason: discardReasonBeforeSend, Category: "metric_bucket", Quantity: 2},
+	})
+}
+
+func TestHTTPTransportSendsClientReports(t *testing.T) {
+	clientReports.take()
+
+	var mu sync.Mutex
+	var reports []clientReport
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+
+		lines := strings.Split(string(body), "\n")
+		if strings.Contains(lines[1], `"client_report"`) {
+			var report clientReport
+			if err := json.Unmarshal([]byte(lines[2]), &report); err != nil {
+				t.Error(err)
+			}
+			reports = append(reports, report)
+			return
+		}
+
+		if requests == 1 {
+			w.Header().Add("X-Sentry-Rate-Limits", "60::organization")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL), SendClientReports: true})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("a:1|c")))
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+
+	// Both events are dropped because of the rate limit.
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("a:1|c\nb:1|c")))
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("c:1|c")))
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var discarded []discardedEvent
+	for _, report := range reports {
+		discarded = append(discarded, report.DiscardedEvents...)
+	}
+	assertEqual(t, discarded, []discardedEvent{
+		{Reason: discardReasonNetworkError, Category: "metric_bucket", Quantity: 1},
+		{Reason: discardReasonRateLimit, Category: "metric_bucket", Quantity: 3},
+	})
+}
+
+func TestClientReportsAreDisabledByDefault(t *testing.T) {
+	clientReports.take()
+
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+	}))
+	defer server.Close()
+
+	clientReports.record(discardReasonQueueOverflow, "statsd", 1)
+
+	transport := NewHTTPSyncTransport()
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+	transport.Flush(testutils.FlushTimeout())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assertEqual(t, requests, 0)
+	clientReports.take()
+}
@@ -0,0 +1,151 @@
+package sentry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the content encoding used for the envelopes sent to Sentry.
+type Compression string
+
+// Supported compression algorithms. Relay accepts both gzip and zstd request
+// bodies, older self-hosted Sentry versions might only accept gzip.
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// ParseCompression converts "none", "gzip" or "zstd" into a Compression. An
+// empty string is treated as "none".
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip":
+		return CompressionGzip, nil
+	case "zstd":
+		return CompressionZstd, nil
+	default:
+		return CompressionNone, fmt.Errorf("unknown compression %q", s)
+	}
+}
+
+// compressor compresses request bodies. A nil compressor leaves them as is.
+type compressor struct {
+	compression Compression
+	level       int
+	// zstd encoders are expensive to create, but EncodeAll is safe for
+	// concurrent use, so a single one is shared by all requests.
+	zstd *zstd.Encoder
+}
+
+// ValidateCompression checks that the level is valid for the compression
+// algorithm, 1 to 9 for gzip and 1 to 22 for zstd. A level of zero selects the
+// default level of the algorithm.
+func ValidateCompression(compression Compression, level int) error {
+	switch compression {
+	case CompressionNone:
+		return nil
+	case CompressionGzip:
+		if level < 0 || level > gzip.BestCompression {
+			return fmt.Errorf("invalid gzip compression level %d", level)
+		}
+	case CompressionZstd:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("invalid zstd compression level %d", level)
+		}
+	default:
+		return fmt.Errorf("unknown compression %q", compression)
+	}
+
+	return nil
+}
+
+// newCompressor returns a compressor for the given algorithm. A level of zero
+// selects the default level of the algorithm. It returns nil for
+// CompressionNone.
+func newCompressor(compression Compression, level int) (*compressor, error) {
+	if err := ValidateCompression(compression, level); err != nil {
+		return nil, err
+	}
+
+	c := &compressor{compression: compression, level: level}
+
+	switch compression {
+	case CompressionNone:
+		return nil, nil
+	case CompressionGzip:
+		if level == 0 {
+			c.level = gzip.DefaultCompression
+		}
+	case CompressionZstd:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(encoderLevel))
+		if err != nil {
+			return nil, err
+		}
+		c.zstd = encoder
+	}
+
+	return c, nil
+}
+
+// close releases the zstd encoder once the transport is replaced. Requests
+// that are still compressed afterwards are unaffected.
+func (c *compressor) close() {
+	if c != nil && c.zstd != nil {
+		_ = c.zstd.Close()
+	}
+}
+
+// compress replaces the body of the request with its compressed form and sets
+// the Content-Encoding header accordingly.
+func (c *compressor) compress(r *http.Request) error {
+	if c == nil || r.Body == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	_ = r.Body.Close()
+
+	var compressed []byte
+	switch c.compression {
+	case CompressionGzip:
+		var b bytes.Buffer
+		w, err := gzip.NewWriterLevel(&b, c.level)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		compressed = b.Bytes()
+	case CompressionZstd:
+		compressed = c.zstd.EncodeAll(body, make([]byte, 0, len(body)/4))
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(compressed))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	r.ContentLength = int64(len(compressed))
+	r.Header.Set("Content-Encoding", string(c.compression))
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package sentry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aldy505/promsentry/sentry/testutils"
+	"github.com/klauspost/compress/zstd"
+)
+
+// fakeRelay decodes request bodies according to their Content-Encoding, the
+// same way Relay does.
+type fakeRelay struct {
+	mu        sync.Mutex
+	encodings []string
+	bodies    []string
+	wireBytes int
+}
+
+func (f *fakeRelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+
+	var body []byte
+	var err error
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		var reader *gzip.Reader
+		reader, err = gzip.NewReader(bytes.NewReader(raw))
+		if err == nil {
+			body, err = io.ReadAll(reader)
+		}
+	case "zstd":
+		var decoder *zstd.Decoder
+		decoder, err = zstd.NewReader(nil)
+		if err == nil {
+			body, err = decoder.DecodeAll(raw, nil)
+			decoder.Close()
+		}
+	default:
+		body = raw
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.encodings = append(f.encodings, r.Header.Get("Content-Encoding"))
+	f.bodies = append(f.bodies, string(body))
+	f.wireBytes += len(raw)
+}
+
+func TestParseCompression(t *testing.T) {
+	for input, want := range map[string]Compression{
+		"":     CompressionNone,
+		"none": CompressionNone,
+		"GZIP": CompressionGzip,
+		"zstd": CompressionZstd,
+	} {
+		got, err := ParseCompression(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+		}
+		assertEqual(t, got, want)
+	}
+
+	if _, err := ParseCompression("brotli"); err == nil {
+		t.Error("expected an error for an unknown compression")
+	}
+}
+
+func TestNewCompressorRejectsInvalidGzipLevel(t *testing.T) {
+	if _, err := newCompressor(CompressionGzip, 42); err == nil {
+		t.Error("expected an error for an invalid gzip level")
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	valid := []struct {
+		compression Compression
+		level       int
+	}{
+		{CompressionNone, 0},
+		{CompressionGzip, 0},
+		{CompressionGzip, 9},
+		{CompressionZstd, 0},
+		{CompressionZstd, 22},
+	}
+	for _, tt := range valid {
+		if err := ValidateCompression(tt.compression, tt.level); err != nil {
+			t.Errorf("ValidateCompression(%q, %d): unexpected error %v", tt.compression, tt.level, err)
+		}
+	}
+
+	for _, level := range []int{42, gzip.DefaultCompression, gzip.HuffmanOnly} {
+		if err := ValidateCompression(CompressionGzip, level); err == nil {
+			t.Errorf("expected an error for the gzip level %d", level)
+		}
+	}
+	if err := ValidateCompression(CompressionZstd, 23); err == nil {
+		t.Error("expected an error for an invalid zstd level")
+	}
+}
+
+func TestTransportsCompressEnvelopes(t *testing.T) {
+	metric := Metric(strings.Repeat("promsentry_metric:1|g|#job:prometheus,instance:localhost:9090\n", 200))
+
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		compression := compression
+		t.Run("HTTPTransport/"+string(compression), func(t *testing.T) {
+			relay := &fakeRelay{}
+			server := httptest.NewServer(relay)
+			defer server.Close()
+
+			transport := NewHTTPTransport()
+			transport.Compression = compression
+			transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+			transport.SendEvent((&Client{}).EventFromMetric(metric))
+			if !transport.Flush(testutils.FlushTimeout()) {
+				t.Fatal("Flush timed out")
+			}
+
+			assertCompressedEnvelope(t, relay, compression, len(metric))
+		})
+
+		t.Run("HTTPSyncTransport/"+string(compression), func(t *testing.T) {
+			relay := &fakeRelay{}
+			server := httptest.NewServer(relay)
+			defer server.Close()
+
+			transport := NewHTTPSyncTransport()
+			transport.Compression = compression
+			transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+			transport.SendEvent((&Client{}).EventFromMetric(metric))
+
+			assertCompressedEnvelope(t, relay, compression, len(metric))
+		})
+	}
+}
+
+func assertCompressedEnvelope(t *testing.T, relay *fakeRelay, compression Compression, size int) {
+	t.Helper()
+
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+
+	if len(relay.bodies) != 1 {
+		t.Fatalf("expected 1 envelope, got %d", len(relay.bodies))
+	}
+	assertEqual(t, relay.encodings[0], string(compression))
+	if !strings.Contains(relay.bodies[0], "promsentry_metric:1|g|#job:prometheus") {
+		t.Errorf("envelope does not contain the metric")
+	}
+	if compression != CompressionNone && relay.wireBytes*10 > size {
+		t.Errorf("expected the envelope to be compressed at least 10 times, got %d bytes for %d", relay.wireBytes, size)
+	}
+}
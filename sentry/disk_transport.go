@@ -0,0 +1,649 @@
+package sentry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+	"github.com/aldy505/promsentry/sentry/wal"
+)
+
+// defaultDiskRetryInterval is how long DiskTransport waits before reading the
+// queue again after it failed to.
+const defaultDiskRetryInterval = time.Second * 5
+
+// DiskTransport is a durable, non-blocking implementation of Transport.
+//
+// Clients using this transport append serialized envelopes to a segmented
+// write-ahead log on disk and return to the caller before any network
+// communication has happened. A background goroutine sends the envelopes to
+// Sentry in order, and only removes them from the log once Sentry accepted or
+// definitely rejected them. Envelopes that were not sent when the process
+// stopped are sent again on the next start.
+//
+// Envelopes of a rate limited category are kept on disk until the rate limit
+// expires instead of being discarded. Other failed deliveries are retried
+// according to RetryPolicy, while the next envelopes wait.
+type DiskTransport struct {
+	dsn       *Dsn
+	client    *http.Client
+	transport http.RoundTripper
+	logger    *slog.Logger
+	telemetry transportTelemetry
+
+	lastSuccess lastSuccess
+
+	log *wal.Log
+
+	start  sync.Once
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+
+	// Directory where the segments of the queue are stored.
+	Directory string
+	// Size in bytes after which a new segment is started. Defaults to 16 MiB.
+	MaxSegmentSize int64
+	// Maximum size in bytes of the queue on disk. The oldest envelopes are
+	// dropped when it is exceeded. Zero means no limit.
+	MaxSize int64
+	// Envelopes older than MaxAge are dropped without being sent. Zero means
+	// no limit.
+	MaxAge time.Duration
+	// When queued envelopes are synced to disk. Defaults to wal.FsyncInterval.
+	Fsync wal.FsyncPolicy
+	// How often queued envelopes are synced to disk with wal.FsyncInterval.
+	// Defaults to one second.
+	FsyncInterval time.Duration
+	// HTTP Client request timeout. Defaults to 30 seconds.
+	Timeout time.Duration
+	// How failed deliveries are retried. Defaults to DefaultRetryPolicy.
+	// Envelopes are dropped from the queue once the attempts are exhausted.
+	RetryPolicy RetryPolicy
+	// Content encoding of the envelopes. Defaults to no compression. The
+	// envelopes are stored uncompressed on disk.
+	Compression Compression
+	// Level of the compression algorithm. Zero selects its default level.
+	CompressionLevel int
+	// Previous, if set, is a running transport using the same Directory. Two
+	// transports can't open the same directory, so the queue of Previous is
+	// taken over by TakeOver instead of being opened.
+	Previous *DiskTransport
+
+	// handover protects log and successor while the queue is handed over.
+	handover  sync.RWMutex
+	successor *DiskTransport
+
+	compressor    *compressor
+	clientReports *clientReportSchedule
+
+	mu         sync.RWMutex
+	limits     ratelimit.Map
+	configured bool
+	openDrops  []queueDrop
+}
+
+// queueDrop is a drop of queued envelopes that is not accounted for yet.
+type queueDrop struct {
+	reason string
+	bytes  int64
+}
+
+// NewDiskTransport returns a new pre-configured instance of DiskTransport that
+// stores its queue in the given directory.
+func NewDiskTransport(directory string) *DiskTransport {
+	transport := DiskTransport{
+		Directory:   directory,
+		Timeout:     defaultTimeout,
+		RetryPolicy: DefaultRetryPolicy(),
+		notify:      make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		limits:      make(ratelimit.Map),
+		logger:      newLogger(ClientOptions{}),
+		telemetry:   newTransportTelemetry(diskTransportName, ClientOptions{}),
+	}
+	return &transport
+}
+
+// Configure is called by the Client itself, providing it it's own ClientOptions.
+func (t *DiskTransport) Configure(options ClientOptions) {
+	t.logger = newLogger(options)
+
+	t.mu.Lock()
+	t.telemetry = newTransportTelemetry(diskTransportName, options)
+	for _, drop := range t.openDrops {
+		t.telemetry.metrics.QueueBytesDropped(diskTransportName, drop.reason, drop.bytes)
+	}
+	t.openDrops = nil
+	t.configured = true
+	t.mu.Unlock()
+
+	dsn, err := NewDsn(options.Dsn)
+	if err != nil {
+		t.logger.Error("Invalid DSN", "error", err)
+		return
+	}
+	t.dsn = dsn
+
+	t.compressor, err = newCompressor(t.Compression, t.CompressionLevel)
+	if err != nil {
+		t.logger.Warn("Sending envelopes uncompressed", "error", err)
+	}
+
+	t.clientReports.stop()
+	t.clientReports = &clientReportSchedule{enabled: options.SendClientReports}
+
+	if options.HTTPTransport != nil {
+		t.transport = options.HTTPTransport
+	} else {
+		t.transport = NewHTTPRoundTripper(options)
+	}
+
+	if options.HTTPClient != nil {
+		t.client = options.HTTPClient
+	} else {
+		t.client = &http.Client{
+			Transport: t.transport,
+			Timeout:   t.Timeout,
+		}
+	}
+
+	if t.Previous == nil {
+		if err := t.Open(); err != nil {
+			t.logger.Error("Unable to open the disk queue", "error", err)
+			return
+		}
+	}
+	t.run()
+
+	t.clientReports.run(t.sendClientReport)
+}
+
+// run starts sending the queued envelopes, once the queue is open.
+func (t *DiskTransport) run() {
+	if t.log == nil {
+		return
+	}
+
+	t.start.Do(func() {
+		if pending := t.log.Len(); pending > 0 {
+			t.logger.Info("Replaying queued events", "bytes", pending, "directory", t.Directory)
+		}
+
+		go t.worker()
+	})
+}
+
+// TakeOver takes the queue over from Previous, without closing it, and starts
+// sending the envelopes queued by both transports. Previous stops sending them
+// and passes the events it still receives on to the transport, so that none
+// are lost while clients are swapped. Call it once the transport is
+// configured.
+func (t *DiskTransport) TakeOver() {
+	previous := t.Previous
+	if previous == nil || t.log != nil {
+		return
+	}
+
+	previous.clientReports.stop()
+
+	previous.handover.Lock()
+	defer previous.handover.Unlock()
+
+	previous.stopWorker()
+	if previous.log == nil {
+		return
+	}
+
+	t.handover.Lock()
+	t.log = previous.log
+	t.handover.Unlock()
+	t.log.SetOptions(t.walOptions())
+
+	previous.log = nil
+	previous.successor = t
+
+	t.run()
+}
+
+// Open opens the queue on disk. Configure opens it when it is not open yet,
+// call Open before to handle the errors, like a directory that can't be
+// created, instead of having every event dropped.
+func (t *DiskTransport) Open() error {
+	if t.log != nil {
+		return nil
+	}
+
+	log, err := wal.Open(t.Directory, t.walOptions())
+	if err != nil {
+		return fmt.Errorf("opening the disk queue %s: %w", t.Directory, err)
+	}
+	t.log = log
+
+	return nil
+}
+
+func (t *DiskTransport) walOptions() wal.Options {
+	return wal.Options{
+		MaxSegmentSize: t.MaxSegmentSize,
+		MaxSize:        t.MaxSize,
+		MaxAge:         t.MaxAge,
+		Fsync:          t.Fsync,
+		FsyncInterval:  t.FsyncInterval,
+		OnDrop:         t.queueDropped,
+		OnDropRecord:   t.queueRecordDropped,
+	}
+}
+
+// queueDropped accounts for queued envelopes that the log dropped before they
+// were sent. The drops found while opening the log, before Configure provides
+// the metrics, are reported by Configure.
+func (t *DiskTransport) queueDropped(segment uint64, bytes int64, reason string) {
+	t.logger.Warn("Dropped queued events", "bytes", bytes, "segment", segment, "reason", reason)
+
+	// The envelopes of a corrupted segment can't be read, so their number and
+	// categories are unknown: report at least one.
+	if reason == "corrupted" {
+		clientReports.record(discardReasonInternalError, ratelimit.CategoryAll, 1)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.configured {
+		t.openDrops = append(t.openDrops, queueDrop{reason: reason, bytes: bytes})
+		return
+	}
+	t.telemetry.metrics.QueueBytesDropped(diskTransportName, reason, bytes)
+}
+
+// queueRecordDropped reports a queued envelope that the log dropped because of
+// its size or age caps in the client reports.
+func (t *DiskTransport) queueRecordDropped(record []byte, _ string) {
+	category, envelope, err := decodeDiskRecord(record)
+	if err != nil {
+		return
+	}
+	clientReports.record(discardReasonQueueOverflow, category, envelopeQuantity(category, envelope))
+}
+
+// SendEvent assembles a new packet out of Event and appends it to the queue on
+// disk. Once the queue was taken over, the event is passed on to the
+// transport that took it over.
+func (t *DiskTransport) SendEvent(event *Event) {
+	t.handover.RLock()
+	defer t.handover.RUnlock()
+
+	if t.successor != nil {
+		t.successor.SendEvent(event)
+		return
+	}
+
+	if t.dsn == nil || t.log == nil {
+		return
+	}
+
+	defer t.queueClientReport(false)
+
+	category := categoryFor(event.Type)
+	quantity := eventQuantity(event)
+
+	envelope, err := envelopeFromBody(event, t.dsn, time.Now(), event.metrics)
+	if err != nil {
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
+		return
+	}
+
+	if err := t.append(category, envelope.Bytes()); err != nil {
+		t.logger.Warn("Event dropped, the disk queue failed", "error", err)
+		t.telemetry.drop(dropReasonQueueError, category, quantity)
+		return
+	}
+
+	t.logger.Debug("Queued event",
+		"level", event.Level,
+		"event_id", event.EventID,
+		"host", t.dsn.host,
+		"project", t.dsn.projectID,
+	)
+}
+
+// append adds an envelope to the queue and wakes up the worker.
+func (t *DiskTransport) append(category ratelimit.Category, envelope []byte) error {
+	if err := t.log.Append(encodeDiskRecord(category, envelope)); err != nil {
+		return err
+	}
+	t.telemetry.metrics.QueueBytes(diskTransportName, t.log.Len())
+
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// sendClientReport adds the pending client report to the queue. Unless force
+// is set, it does nothing if a report was sent recently.
+func (t *DiskTransport) sendClientReport(force bool) {
+	t.handover.RLock()
+	defer t.handover.RUnlock()
+
+	if t.dsn == nil || t.log == nil {
+		return
+	}
+	t.queueClientReport(force)
+}
+
+// queueClientReport is sendClientReport, with t.handover held.
+func (t *DiskTransport) queueClientReport(force bool) {
+	report := t.clientReports.due(force)
+	if report == nil {
+		return
+	}
+
+	envelope, err := envelopeFromClientReport(report, t.dsn)
+	if err != nil {
+		t.logger.Warn("Unable to encode the client report", "error", err)
+		return
+	}
+
+	if err := t.append(clientReportCategory, envelope.Bytes()); err != nil {
+		t.logger.Warn("Unable to queue the client report", "error", err)
+		clientReports.restore(report)
+	}
+}
+
+// Flush syncs the queue to disk and waits until every queued event is sent to
+// the Sentry server, blocking for at most the given timeout. It returns false
+// if the timeout was reached. In that case, the unsent events stay on disk and
+// are sent later, or after a restart.
+func (t *DiskTransport) Flush(timeout time.Duration) bool {
+	t.handover.RLock()
+	log := t.log
+	t.handover.RUnlock()
+
+	if log == nil {
+		return true
+	}
+
+	t.sendClientReport(true)
+
+	if err := log.Sync(); err != nil {
+		t.logger.Warn("Unable to sync the disk queue", "error", err)
+	}
+
+	toolate := time.After(timeout)
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for {
+		if log.Len() == 0 {
+			t.logger.Debug("Buffer flushed")
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-toolate:
+			t.logger.Warn("Buffer flushing reached the timeout")
+			return false
+		}
+	}
+}
+
+// QueueSize returns the size in bytes of the events that were not sent yet.
+// They stay on disk if the program terminates.
+func (t *DiskTransport) QueueSize() int64 {
+	t.handover.RLock()
+	defer t.handover.RUnlock()
+
+	if t.log == nil {
+		return 0
+	}
+	return t.log.Len()
+}
+
+// Close stops the background goroutine and closes the queue. Events that were
+// not sent yet stay on disk. A queue that was taken over is left open.
+func (t *DiskTransport) Close() error {
+	t.clientReports.stop()
+
+	t.handover.Lock()
+	defer t.handover.Unlock()
+
+	t.stopWorker()
+	t.compressor.close()
+
+	if t.log == nil {
+		return nil
+	}
+	return t.log.Close()
+}
+
+// stopWorker stops the background goroutine and waits for it.
+func (t *DiskTransport) stopWorker() {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	// The worker only runs once the queue is open.
+	t.start.Do(func() {
+		close(t.done)
+	})
+	<-t.done
+}
+
+func (t *DiskTransport) worker() {
+	defer close(t.done)
+
+	// Number of delivery attempts made for the envelope at the head of the
+	// queue.
+	attempts := 0
+	// Rate limited envelopes are moved to the end of the queue, so that the
+	// other categories are not held behind them. held is the first envelope
+	// moved since one was delivered, meeting it again means that every
+	// envelope left is rate limited.
+	var held []byte
+	var requeued, pending int64
+	var until time.Time
+	for {
+		record, err := t.log.Peek()
+		if errors.Is(err, io.EOF) {
+			select {
+			case <-t.notify:
+				continue
+			case <-t.stop:
+				return
+			}
+		}
+		if err != nil {
+			t.logger.Error("Unable to read from the disk queue", "error", err)
+			if !t.wait(defaultDiskRetryInterval) {
+				return
+			}
+			continue
+		}
+
+		category, envelope, err := decodeDiskRecord(record)
+		if err != nil {
+			t.logger.Warn("Skipping unreadable event from the disk queue", "error", err)
+			clientReports.record(discardReasonInternalError, ratelimit.CategoryAll, 1)
+			_ = t.log.Commit()
+			continue
+		}
+
+		if deadline, limited := t.rateLimited(category); limited {
+			if held == nil {
+				held, requeued, pending, until = record, 0, t.log.Len(), time.Time(deadline)
+			} else if bytes.Equal(record, held) || requeued >= pending {
+				// The records are shorter than their size on disk, requeued
+				// reaches pending at the latest once around the queue.
+				t.logger.Warn("Too many requests, holding queued events", "until", until)
+				held = nil
+				if !t.hold(time.Until(until)) {
+					return
+				}
+				continue
+			}
+			if time.Time(deadline).Before(until) {
+				until = time.Time(deadline)
+			}
+
+			if err := t.log.Requeue(record); err != nil {
+				t.logger.Error("Unable to requeue a rate limited event", "error", err)
+				if !t.wait(defaultDiskRetryInterval) {
+					return
+				}
+				continue
+			}
+			requeued += int64(len(record))
+			continue
+		}
+		held = nil
+
+		attempts++
+		if delivered, response := t.send(category, envelope); !delivered {
+			if _, limited := t.rateLimited(category); limited {
+				// Held until the rate limit expires, which doesn't count as
+				// an attempt.
+				attempts--
+				continue
+			}
+
+			if t.RetryPolicy.allows(attempts) {
+				t.mu.RLock()
+				deadline := t.limits.Deadline(category)
+				t.mu.RUnlock()
+
+				delay := t.RetryPolicy.delay(attempts, response, deadline)
+				t.logger.Debug("Retrying event", "delay", delay, "attempt", attempts+1, "max_attempts", t.RetryPolicy.MaxAttempts)
+				if !t.wait(delay) {
+					return
+				}
+				continue
+			}
+
+			t.logger.Warn("Event dropped after failed attempts", "attempts", attempts)
+			t.telemetry.drop(dropReasonRetriesExhausted, category, envelopeQuantity(category, envelope))
+		}
+		attempts = 0
+
+		if err := t.log.Commit(); err != nil {
+			t.logger.Error("Unable to commit the disk queue", "error", err)
+		}
+		t.telemetry.metrics.QueueBytes(diskTransportName, t.log.Len())
+	}
+}
+
+// send delivers a single envelope. It returns false if the envelope should be
+// sent again later, along with the response of Sentry when there is one.
+func (t *DiskTransport) send(category ratelimit.Category, envelope []byte) (bool, *http.Response) {
+	request, err := getRequestFromEnvelope(bytes.NewBuffer(envelope), t.dsn, sdkIdentifier, SDKVersion)
+	if err != nil {
+		t.logger.Warn("Unable to create a request for a queued event", "error", err)
+		t.telemetry.drop(dropReasonEncodingError, category, envelopeQuantity(category, envelope))
+		return true, nil
+	}
+
+	if err := t.compressor.compress(request); err != nil {
+		t.logger.Warn("Unable to compress a queued event", "error", err)
+		t.telemetry.drop(dropReasonEncodingError, category, envelopeQuantity(category, envelope))
+		return true, nil
+	}
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		t.logger.Warn("Unable to send an event", "error", err)
+		t.telemetry.sendError()
+		return false, nil
+	}
+	t.telemetry.response(request, response)
+	t.lastSuccess.record(response)
+
+	body, _ := io.ReadAll(response.Body)
+	t.logger.Debug("Sentry response", "status", response.StatusCode, "body", string(body))
+
+	t.mu.Lock()
+	t.telemetry.mergeRateLimits(t.limits, response)
+	t.mu.Unlock()
+	// Drain body up to a limit and close it, allowing the
+	// transport to reuse TCP connections.
+	_, _ = io.CopyN(io.Discard, response.Body, maxDrainResponseBytes)
+	response.Body.Close()
+
+	// Rate limited envelopes are held until the limit recorded above
+	// expires, server errors are retried after a while.
+	return !shouldRetry(response, nil), response
+}
+
+// wait blocks for the given duration. It returns false if the transport was
+// closed in the meantime.
+func (t *DiskTransport) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-t.stop:
+		return false
+	}
+}
+
+// hold blocks for the given duration, or until an event is queued. It
+// returns false if the transport was stopped meanwhile.
+func (t *DiskTransport) hold(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-t.notify:
+		return true
+	case <-t.stop:
+		return false
+	}
+}
+
+func (t *DiskTransport) rateLimited(c ratelimit.Category) (ratelimit.Deadline, bool) {
+	if c == clientReportCategory {
+		return ratelimit.Deadline{}, false
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.limits.Deadline(c), t.limits.IsRateLimited(c)
+}
+
+// encodeDiskRecord serializes the rate limit category and the envelope into a
+// single queue record.
+func encodeDiskRecord(category ratelimit.Category, envelope []byte) []byte {
+	record := make([]byte, 0, 1+len(category)+len(envelope))
+	record = append(record, byte(len(category)))
+	record = append(record, category...)
+	record = append(record, envelope...)
+	return record
+}
+
+func decodeDiskRecord(record []byte) (ratelimit.Category, []byte, error) {
+	if len(record) == 0 {
+		return "", nil, fmt.Errorf("empty record")
+	}
+
+	length := int(record[0])
+	if len(record) < 1+length {
+		return "", nil, fmt.Errorf("record is too short")
+	}
+
+	return ratelimit.Category(record[1 : 1+length]), record[1+length:], nil
+}
@@ -0,0 +1,282 @@
+package sentry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/testutils"
+)
+
+type envelopeRecorder struct {
+	mu        sync.Mutex
+	envelopes []string
+}
+
+func (r *envelopeRecorder) record(req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.envelopes = append(r.envelopes, string(body))
+}
+
+func (r *envelopeRecorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.envelopes)
+}
+
+func newDiskTestTransport(t *testing.T, dir string, url string) *DiskTransport {
+	t.Helper()
+	transport := NewDiskTransport(dir)
+	transport.Configure(ClientOptions{
+		Dsn: testDsn(url),
+	})
+	t.Cleanup(func() {
+		_ = transport.Close()
+	})
+	return transport
+}
+
+func TestDiskTransportSendsEvents(t *testing.T) {
+	recorder := &envelopeRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+	}))
+	defer server.Close()
+
+	transport := newDiskTestTransport(t, t.TempDir(), server.URL)
+
+	client := &Client{}
+	for i := 0; i < 3; i++ {
+		transport.SendEvent(client.EventFromMetric(Metric("foo:1|c")))
+	}
+
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	if n := recorder.Len(); n != 3 {
+		t.Fatalf("expected 3 envelopes, got %d", n)
+	}
+	if !strings.Contains(recorder.envelopes[0], "foo:1|c") {
+		t.Errorf("envelope does not contain the metric: %q", recorder.envelopes[0])
+	}
+}
+
+func TestDiskTransportReplaysAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	transport := newDiskTestTransport(t, dir, failing.URL)
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	if transport.Flush(100 * time.Millisecond) {
+		t.Fatal("expected Flush to time out while the server is failing")
+	}
+	if err := transport.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &envelopeRecorder{}
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+	}))
+	defer working.Close()
+
+	transport = newDiskTestTransport(t, dir, working.URL)
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	if n := recorder.Len(); n != 1 {
+		t.Fatalf("expected the queued envelope to be replayed, got %d envelopes", n)
+	}
+}
+
+func TestDiskTransportHoldsRateLimitedEvents(t *testing.T) {
+	var requests int32
+	recorder := &envelopeRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		recorder.record(r)
+	}))
+	defer server.Close()
+
+	transport := newDiskTestTransport(t, t.TempDir(), server.URL)
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+
+	if !transport.Flush(testutils.FlushTimeout() + 2*time.Second) {
+		t.Fatal("Flush timed out")
+	}
+	if n := recorder.Len(); n != 1 {
+		t.Fatalf("expected the rate limited envelope to be sent later, got %d envelopes", n)
+	}
+}
+
+func TestDiskTransportRateLimitDoesNotHoldOtherCategories(t *testing.T) {
+	recorder := &envelopeRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+		w.Header().Set("X-Sentry-Rate-Limits", "60:metric_bucket:organization")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	transport := newDiskTestTransport(t, t.TempDir(), server.URL)
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	// An event without a type is in the error category.
+	event := (&Client{}).EventFromMetric(Metric("bar:1|c"))
+	event.Type = ""
+	transport.SendEvent(event)
+
+	// The metrics are held for a minute, the error is sent right away.
+	deadline := time.Now().Add(testutils.FlushTimeout())
+	for recorder.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := recorder.Len(); n != 2 {
+		t.Fatalf("expected the error to be sent while the metrics are rate limited, got %d envelopes", n)
+	}
+	if !strings.Contains(recorder.envelopes[1], "bar:1|c") {
+		t.Errorf("expected the error envelope, got %q", recorder.envelopes[1])
+	}
+	if size := transport.QueueSize(); size == 0 {
+		t.Error("expected the rate limited metrics to stay queued")
+	}
+}
+
+func TestDiskTransportRetriesWithPolicy(t *testing.T) {
+	server, requests := newFlakyServer(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	transport := NewDiskTransport(t.TempDir())
+	transport.RetryPolicy = fastRetryPolicy
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+	defer transport.Close()
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestDiskTransportDropsAfterMaxAttempts(t *testing.T) {
+	server, requests := newFlakyServer(10, http.StatusInternalServerError)
+	defer server.Close()
+
+	transport := NewDiskTransport(t.TempDir())
+	transport.RetryPolicy = RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+	defer transport.Close()
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("expected the event to be dropped from the queue")
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestDiskTransportOpenError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := NewDiskTransport(filepath.Join(file, "queue"))
+	if err := transport.Open(); err == nil {
+		t.Fatal("expected an error when the directory can't be created")
+	}
+	if err := transport.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskTransportReportsQueueDrops(t *testing.T) {
+	clientReports.take()
+	defer clientReports.take()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	transport := NewDiskTransport(t.TempDir())
+	transport.MaxSegmentSize = 256
+	transport.MaxSize = 512
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+	defer transport.Close()
+	defer close(release)
+
+	client := &Client{}
+	for i := 0; i < 20; i++ {
+		transport.SendEvent(client.EventFromMetric(Metric("foo:1|c\nbar:1|c")))
+	}
+
+	report := clientReports.take()
+	if report == nil || len(report.DiscardedEvents) != 1 {
+		t.Fatalf("expected the dropped events to be reported, got %+v", report)
+	}
+	dropped := report.DiscardedEvents[0]
+	if dropped.Reason != discardReasonQueueOverflow || dropped.Category != "metric_bucket" || dropped.Quantity%2 != 0 {
+		t.Fatalf("unexpected discarded events %+v", dropped)
+	}
+}
+
+func TestDiskTransportTakeOver(t *testing.T) {
+	unavailable, _ := newFlakyServer(100, http.StatusServiceUnavailable)
+	defer unavailable.Close()
+
+	recorder := &envelopeRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	previous := NewDiskTransport(dir)
+	previous.RetryPolicy = RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Hour}
+	previous.Configure(ClientOptions{Dsn: testDsn(unavailable.URL)})
+	defer previous.Close()
+
+	client := &Client{}
+	previous.SendEvent(client.EventFromMetric(Metric("foo:1|c")))
+	previous.SendEvent(client.EventFromMetric(Metric("bar:1|c")))
+
+	transport := NewDiskTransport(dir)
+	transport.Previous = previous
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+	defer transport.Close()
+	transport.TakeOver()
+
+	// Events sent to the previous transport are passed on.
+	previous.SendEvent(client.EventFromMetric(Metric("baz:1|c")))
+	if err := previous.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	if n := recorder.Len(); n != 3 {
+		t.Fatalf("expected the 3 envelopes to be sent by the new transport, got %d", n)
+	}
+}
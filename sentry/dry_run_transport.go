@@ -0,0 +1,273 @@
+package sentry
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+)
+
+const (
+	dryRunTransportName = "dry_run"
+
+	// bucketInterval is the interval Sentry aggregates the statsd lines of a
+	// series into a single bucket over.
+	bucketInterval = 10 * time.Second
+	// dryRunBucketWindow is how long the buckets of an interval are counted
+	// for, since lines with an older timestamp can still arrive.
+	dryRunBucketWindow = 2 * time.Minute
+	// maxDryRunSeries bounds the number of series counted as unique.
+	maxDryRunSeries = 1000000
+)
+
+// DryRunTransport builds the envelopes of the events like the HTTPTransport
+// does, but never sends them. It keeps statistics about them instead, to
+// project the volume a real DSN would receive.
+type DryRunTransport struct {
+	// Compression and CompressionLevel are applied to the envelopes, so that
+	// the projected bytes match what would be sent.
+	Compression      Compression
+	CompressionLevel int
+
+	dsn        *Dsn
+	compressor *compressor
+	logger     *slog.Logger
+	telemetry  transportTelemetry
+
+	mu      sync.Mutex
+	started time.Time
+	stats   DryRunStats
+	series  map[uint64]struct{}
+	// buckets holds the series seen in each bucket interval of the window,
+	// by the start of the interval in unix seconds. closedBuckets counts the
+	// buckets of the intervals that left the window.
+	buckets       map[int64]map[uint64]struct{}
+	closedBuckets int64
+	newest        int64
+}
+
+// DryRunStats summarizes the envelopes a DryRunTransport would have sent.
+type DryRunStats struct {
+	// Since is when the transport was configured.
+	Since time.Time `json:"since"`
+	// Envelopes, Lines and Bytes are the envelopes, statsd lines and request
+	// body bytes, after compression, that would have been sent.
+	Envelopes int64 `json:"envelopes"`
+	Lines     int64 `json:"lines"`
+	Bytes     int64 `json:"bytes"`
+	// UniqueSeries is the number of distinct metric names, types and tags,
+	// counted up to one million.
+	UniqueSeries int `json:"unique_series"`
+	// Buckets is the number of buckets Sentry would have stored: one per
+	// series and 10 second interval with at least one line.
+	Buckets int64 `json:"buckets"`
+	// LinesPerSecond and BucketsPerHour are the averages since Since.
+	LinesPerSecond float64 `json:"lines_per_second"`
+	BucketsPerHour float64 `json:"buckets_per_hour"`
+}
+
+// NewDryRunTransport returns a new DryRunTransport.
+func NewDryRunTransport() *DryRunTransport {
+	return &DryRunTransport{
+		logger:    newLogger(ClientOptions{}),
+		telemetry: newTransportTelemetry(dryRunTransportName, ClientOptions{}),
+		series:    make(map[uint64]struct{}),
+		buckets:   make(map[int64]map[uint64]struct{}),
+	}
+}
+
+// Configure is called by the Client itself, providing it it's own ClientOptions.
+// An empty DSN is replaced by a placeholder, the envelopes are never sent.
+func (t *DryRunTransport) Configure(options ClientOptions) {
+	t.logger = newLogger(options)
+	t.telemetry = newTransportTelemetry(dryRunTransportName, options)
+
+	dsn := options.Dsn
+	if dsn == "" {
+		dsn = "https://public@sentry.invalid/0"
+	}
+	var err error
+	t.dsn, err = NewDsn(dsn)
+	if err != nil {
+		t.logger.Error("Invalid DSN", "error", err)
+		return
+	}
+
+	t.compressor, err = newCompressor(t.Compression, t.CompressionLevel)
+	if err != nil {
+		t.logger.Warn("Projecting uncompressed envelopes", "error", err)
+	}
+
+	t.mu.Lock()
+	t.started = time.Now()
+	t.mu.Unlock()
+
+	t.logger.Info("Dry run, metrics are converted but never sent to Sentry")
+}
+
+// SendEvent builds the envelope of the event and records it.
+func (t *DryRunTransport) SendEvent(event *Event) {
+	if t.dsn == nil {
+		return
+	}
+
+	category := categoryFor(event.Type)
+	quantity := eventQuantity(event)
+
+	request, err := getRequestFromEvent(event, t.dsn)
+	if err != nil {
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
+		return
+	}
+	if err := t.compressor.compress(request); err != nil {
+		t.logger.Warn("Unable to compress the event", "error", err)
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
+		return
+	}
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
+		return
+	}
+
+	t.record(category, event.metrics, int64(len(body)))
+	t.logger.Debug("Dry run, envelope not sent", "lines", quantity, "bytes", len(body))
+}
+
+// record accounts for an envelope carrying the statsd payload.
+func (t *DryRunTransport) record(category ratelimit.Category, payload []byte, size int64) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stats.Envelopes++
+	t.stats.Bytes += size
+	if category != ratelimit.CategoryStatsd {
+		return
+	}
+
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		t.stats.Lines++
+
+		series, timestamp := seriesOf(line)
+		if timestamp == 0 {
+			timestamp = now.Unix()
+		}
+		if _, ok := t.series[series]; ok || len(t.series) < maxDryRunSeries {
+			t.series[series] = struct{}{}
+		}
+		t.addToBucket(series, timestamp)
+	}
+}
+
+// addToBucket adds the series to the bucket of the interval of the timestamp,
+// and closes the intervals that left the window.
+func (t *DryRunTransport) addToBucket(series uint64, timestamp int64) {
+	interval := timestamp - timestamp%int64(bucketInterval.Seconds())
+	window := int64(dryRunBucketWindow.Seconds())
+	if interval <= t.newest-window {
+		// Too late to tell whether the bucket was already counted, assume
+		// it was not.
+		t.closedBuckets++
+		return
+	}
+
+	bucket, ok := t.buckets[interval]
+	if !ok {
+		bucket = make(map[uint64]struct{})
+		t.buckets[interval] = bucket
+	}
+	bucket[series] = struct{}{}
+
+	if interval <= t.newest {
+		return
+	}
+	t.newest = interval
+	for start, bucket := range t.buckets {
+		if start <= t.newest-window {
+			t.closedBuckets += int64(len(bucket))
+			delete(t.buckets, start)
+		}
+	}
+}
+
+// seriesOf returns a hash of the name, type and tags of a statsd line, and its
+// timestamp, zero when it has none.
+func seriesOf(line []byte) (uint64, int64) {
+	h := fnv.New64a()
+	var timestamp int64
+	for i, section := range bytes.Split(line, []byte("|")) {
+		switch {
+		case i == 0:
+			name, _, _ := bytes.Cut(section, []byte(":"))
+			h.Write(name)
+		case i == 1 || bytes.HasPrefix(section, []byte("#")):
+			h.Write([]byte("|"))
+			h.Write(section)
+		case bytes.HasPrefix(section, []byte("T")):
+			timestamp, _ = strconv.ParseInt(string(section[1:]), 10, 64)
+		}
+	}
+	return h.Sum64(), timestamp
+}
+
+// Stats returns the statistics of the envelopes recorded so far.
+func (t *DryRunTransport) Stats() DryRunStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := t.stats
+	stats.Since = t.started
+	stats.UniqueSeries = len(t.series)
+	stats.Buckets = t.closedBuckets
+	for _, bucket := range t.buckets {
+		stats.Buckets += int64(len(bucket))
+	}
+	if elapsed := time.Since(t.started).Seconds(); !t.started.IsZero() && elapsed > 0 {
+		stats.LinesPerSecond = float64(stats.Lines) / elapsed
+		stats.BucketsPerHour = float64(stats.Buckets) / elapsed * time.Hour.Seconds()
+	}
+	return stats
+}
+
+// Flush logs a summary of the statistics. Nothing is ever buffered.
+func (t *DryRunTransport) Flush(time.Duration) bool {
+	stats := t.Stats()
+	t.logger.Info("Dry run summary",
+		"envelopes", stats.Envelopes,
+		"lines", stats.Lines,
+		"bytes", stats.Bytes,
+		"unique_series", stats.UniqueSeries,
+		"buckets", stats.Buckets,
+		"lines_per_second", stats.LinesPerSecond,
+		"buckets_per_hour", stats.BucketsPerHour,
+	)
+	return true
+}
+
+// Close releases the compression encoder of the transport.
+func (t *DryRunTransport) Close() error {
+	t.compressor.close()
+	return nil
+}
+
+// Status returns the status of the transport, with the statistics of the dry
+// run.
+func (t *DryRunTransport) Status() TransportStatus {
+	stats := t.Stats()
+	return TransportStatus{
+		Transport:  dryRunTransportName,
+		Configured: t.dsn != nil,
+		RateLimits: map[string]time.Time{},
+		DryRun:     &stats,
+	}
+}
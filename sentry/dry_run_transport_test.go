@@ -0,0 +1,68 @@
+package sentry
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestDryRunTransport(t *testing.T) {
+	var output bytes.Buffer
+	transport := NewDryRunTransport()
+	transport.Configure(ClientOptions{Logger: slog.New(slog.NewTextHandler(&output, nil))})
+
+	client := &Client{}
+	// Two series, the first one in two 10 second intervals.
+	transport.SendEvent(client.EventFromMetric(Metric("up:1|g|#job:node|T1700000000\nup:0|g|#job:api|T1700000001")))
+	transport.SendEvent(client.EventFromMetric(Metric("up:1|g|#job:node|T1700000005\nup:1|g|#job:node|T1700000010")))
+
+	stats := transport.Stats()
+	if stats.Envelopes != 2 || stats.Lines != 4 || stats.UniqueSeries != 2 || stats.Buckets != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Bytes == 0 || stats.LinesPerSecond == 0 || stats.BucketsPerHour == 0 {
+		t.Errorf("expected the bytes and rates to be computed, got %+v", stats)
+	}
+
+	status := transport.Status()
+	if !status.Configured || status.DryRun == nil || status.DryRun.Lines != 4 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	if !transport.Flush(0) || !strings.Contains(output.String(), "Dry run summary") {
+		t.Errorf("expected a summary to be logged, got %q", output.String())
+	}
+}
+
+func TestDryRunTransportCompression(t *testing.T) {
+	payload := Metric(strings.Repeat("requests:1|c|#job:node\n", 100))
+
+	uncompressed := NewDryRunTransport()
+	uncompressed.Configure(ClientOptions{})
+	uncompressed.SendEvent((&Client{}).EventFromMetric(payload))
+
+	compressed := NewDryRunTransport()
+	compressed.Compression = CompressionGzip
+	compressed.Configure(ClientOptions{})
+	compressed.SendEvent((&Client{}).EventFromMetric(payload))
+
+	if c, u := compressed.Stats().Bytes, uncompressed.Stats().Bytes; c == 0 || c >= u {
+		t.Errorf("expected fewer bytes with compression, got %d compressed and %d uncompressed", c, u)
+	}
+}
+
+func TestDryRunTransportOldBuckets(t *testing.T) {
+	transport := NewDryRunTransport()
+	transport.Configure(ClientOptions{})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("up:1|g|T1700000000")))
+	// Closes the interval of the first line.
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("up:1|g|T1700001000")))
+	// Too old to be deduplicated, counted as a new bucket.
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("up:1|g|T1700000000")))
+
+	if stats := transport.Stats(); stats.Buckets != 3 || stats.UniqueSeries != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
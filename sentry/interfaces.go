@@ -16,6 +16,9 @@ const transactionType = "transaction"
 // eventType is the type of an error event.
 const eventType = "event"
 
+// metricType is the type of an event carrying statsd lines.
+const metricType = "statsd"
+
 const profileType = "profile"
 
 // checkInType is the type of a check in event.
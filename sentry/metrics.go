@@ -1,6 +1,53 @@
 package sentry
 
+import "bytes"
+
+// defaultMaxMetricItemBytes is the default size limit of a single statsd
+// envelope item, well below the limits enforced by Relay for envelopes.
+const defaultMaxMetricItemBytes = 1 << 20
+
 // Metric provide a.. type alias for []byte.
 // You should provide a serialized statsd format using the statsd package.
 // For multiple metric entries, please respect the new lines (you should provide the new lines).
 type Metric []byte
+
+// splitMetric splits a metric payload into chunks of at most maxBytes bytes
+// and maxLines lines each. Lines are never broken, a single line that is larger
+// than maxBytes is returned as a chunk of its own. Empty lines are dropped.
+// A limit of zero or less means no limit.
+func splitMetric(metric Metric, maxBytes int, maxLines int) []Metric {
+	var chunks []Metric
+	var current []byte
+	var lines int
+
+	for _, line := range bytes.Split(metric, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		// The new line that separates this line from the previous one.
+		size := len(line)
+		if len(current) > 0 {
+			size++
+		}
+
+		full := (maxBytes > 0 && len(current)+size > maxBytes) || (maxLines > 0 && lines >= maxLines)
+		if len(current) > 0 && full {
+			chunks = append(chunks, current)
+			current = nil
+			lines = 0
+		}
+
+		if len(current) > 0 {
+			current = append(current, '\n')
+		}
+		current = append(current, line...)
+		lines++
+	}
+
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
@@ -0,0 +1,99 @@
+package sentry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitMetric(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   string
+		maxBytes int
+		maxLines int
+		want     []string
+	}{
+		{
+			name:   "no limits",
+			metric: "a:1|c\nb:2|c\n",
+			want:   []string{"a:1|c\nb:2|c"},
+		},
+		{
+			name:     "byte limit",
+			metric:   "a:1|c\nb:2|c\nc:3|c",
+			maxBytes: 12,
+			want:     []string{"a:1|c\nb:2|c", "c:3|c"},
+		},
+		{
+			name:     "line limit",
+			metric:   "a:1|c\nb:2|c\nc:3|c",
+			maxLines: 1,
+			want:     []string{"a:1|c", "b:2|c", "c:3|c"},
+		},
+		{
+			name:     "oversized line is kept whole",
+			metric:   "a:1|c\nlong_metric_name:1|c\nb:2|c",
+			maxBytes: 8,
+			want:     []string{"a:1|c", "long_metric_name:1|c", "b:2|c"},
+		},
+		{
+			name:   "empty lines are dropped",
+			metric: "\n\na:1|c\n\n",
+			want:   []string{"a:1|c"},
+		},
+		{
+			name:   "empty metric",
+			metric: "",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, chunk := range splitMetric(Metric(tt.metric), tt.maxBytes, tt.maxLines) {
+				got = append(got, string(chunk))
+			}
+			assertEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestCaptureMetricSplitsLargePayloads(t *testing.T) {
+	transport := &TransportMock{}
+	client, err := NewClient(ClientOptions{
+		Dsn:                "http://whatever@example.com/1337",
+		Transport:          transport,
+		MaxMetricItemLines: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.CaptureMetric(Metric("a:1|c\nb:1|c\nc:1|c\nd:1|c\ne:1|c"))
+
+	events := transport.Events()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	for _, event := range events {
+		if lines := strings.Count(string(event.metrics), "\n") + 1; lines > 2 {
+			t.Errorf("event has %d lines, want at most 2", lines)
+		}
+	}
+}
+
+func TestEnvelopeItemLengthMatchesPayload(t *testing.T) {
+	dsn, _ := NewDsn("http://whatever@example.com/1337")
+	event := (&Client{}).EventFromMetric(Metric("\na:1|c\nb:1|c\n"))
+
+	envelope, err := envelopeFromBody(event, dsn, event.Timestamp, event.metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(envelope.String(), "\n")
+	assertEqual(t, lines[1], `{"type":"statsd","length":11}`)
+	assertEqual(t, lines[2]+"\n"+lines[3], "a:1|c\nb:1|c")
+}
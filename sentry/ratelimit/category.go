@@ -20,6 +20,7 @@ const (
 	CategoryAll         Category = ""
 	CategoryError       Category = "error"
 	CategoryTransaction Category = "transaction"
+	CategoryStatsd      Category = "statsd"
 )
 
 // knownCategories is the set of currently known categories. Other categories
@@ -28,6 +29,14 @@ var knownCategories = map[Category]struct{}{
 	CategoryAll:         {},
 	CategoryError:       {},
 	CategoryTransaction: {},
+	CategoryStatsd:      {},
+}
+
+// categoryAliases maps the data categories that Sentry uses in rate limits to
+// the category of the payloads they apply to. Metric envelopes carry statsd
+// items, which Sentry accounts for as metric buckets.
+var categoryAliases = map[Category]Category{
+	"metric_bucket": CategoryStatsd,
 }
 
 // String returns the category formatted for debugging.
@@ -62,3 +62,14 @@ func fromResponse(r *http.Response, now time.Time) Map {
 	}
 	return Map{}
 }
+
+// RetryAfter returns the deadline from the Retry-After header of an HTTP
+// response. It returns false if the response does not have a valid header.
+func RetryAfter(r *http.Response) (Deadline, bool) {
+	s := r.Header.Get("Retry-After")
+	if s == "" {
+		return Deadline{}, false
+	}
+	deadline, err := parseRetryAfter(s, time.Now())
+	return deadline, err == nil
+}
@@ -43,6 +43,9 @@ func parseXSentryRateLimits(s string, now time.Time) Map {
 		}
 		for _, category := range strings.Split(categories, ";") {
 			c := Category(strings.ToLower(strings.TrimSpace(category)))
+			if alias, ok := categoryAliases[c]; ok {
+				c = alias
+			}
 			if _, ok := knownCategories[c]; !ok {
 				// skip unknown categories, keep m small
 				continue
@@ -0,0 +1,112 @@
+package sentry
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+)
+
+// RetryPolicy configures how the HTTP transports retry deliveries that failed
+// for a reason that may go away by itself: connection errors, server errors
+// (5xx) and rate limits (429).
+//
+// Retries are spaced with an exponential backoff that starts at InitialBackoff
+// and doubles on every attempt, up to MaxBackoff. If the server asked us to
+// wait longer with a Retry-After or X-Sentry-Rate-Limits header, the longer
+// delay is used.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one. Values below 2
+	// disable retries.
+	MaxAttempts int
+	// Delay before the first retry.
+	InitialBackoff time.Duration
+	// Upper bound of the delay between two attempts.
+	MaxBackoff time.Duration
+	// Jitter randomizes every delay by up to the given fraction of it, in the
+	// range [0.0, 1.0], so that many clients do not retry all at once.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the RetryPolicy used by NewHTTPTransport and
+// NewHTTPSyncTransport.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second * 30,
+		Jitter:         0.2,
+	}
+}
+
+// allows reports whether another attempt can be made after the given number
+// of attempts.
+func (p RetryPolicy) allows(attempts int) bool {
+	return attempts < p.MaxAttempts
+}
+
+// backoff returns the delay to wait after the given number of attempts.
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(2, float64(attempts-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if jitter := math.Min(math.Max(p.Jitter, 0), 1); jitter > 0 {
+		d += d * jitter * (2*rng.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// delay returns how long to wait before retrying after the given number of
+// attempts, honoring the Retry-After header of the response and the rate limit
+// deadline of the category.
+func (p RetryPolicy) delay(attempts int, response *http.Response, deadline ratelimit.Deadline) time.Duration {
+	d := p.backoff(attempts)
+
+	if wait := time.Until(time.Time(deadline)); wait > d {
+		d = wait
+	}
+
+	if response != nil {
+		if retryAfter, ok := ratelimit.RetryAfter(response); ok {
+			if wait := time.Until(time.Time(retryAfter)); wait > d {
+				d = wait
+			}
+		}
+	}
+
+	return d
+}
+
+// shouldRetry reports whether a delivery that ended with the given response or
+// error is worth retrying.
+func shouldRetry(response *http.Response, err error) bool {
+	if err != nil {
+		// http.Client only returns errors for failures that happened before
+		// a response was received, like refused connections or timeouts.
+		return true
+	}
+
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+}
+
+// cloneRequest returns a copy of the request with a fresh body, so that it can
+// be sent again.
+func cloneRequest(r *http.Request) (*http.Request, error) {
+	clone := r.Clone(r.Context())
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
@@ -0,0 +1,156 @@
+package sentry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+	"github.com/aldy505/promsentry/sentry/testutils"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second * 5,
+	}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, time.Second * 2},
+		{3, time.Second * 4},
+		{4, time.Second * 5},
+		{10, time.Second * 5},
+	}
+	for _, tt := range tests {
+		if got := policy.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := policy.backoff(1)
+		if got < time.Millisecond*500 || got > time.Millisecond*1500 {
+			t.Fatalf("backoff with jitter out of range: %s", got)
+		}
+	}
+}
+
+func TestRetryPolicyDelayHonorsDeadline(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	deadline := ratelimit.Deadline(time.Now().Add(time.Minute))
+
+	if got := policy.delay(1, nil, deadline); got < time.Second*59 {
+		t.Errorf("expected the delay to wait for the rate limit deadline, got %s", got)
+	}
+
+	response := &http.Response{Header: http.Header{"Retry-After": []string{"30"}}}
+	if got := policy.delay(1, response, ratelimit.Deadline{}); got < time.Second*29 {
+		t.Errorf("expected the delay to honor Retry-After, got %s", got)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusRequestEntityTooLarge, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		if got := shouldRetry(&http.Response{StatusCode: tt.status}, nil); got != tt.want {
+			t.Errorf("shouldRetry(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+
+	if !shouldRetry(nil, http.ErrHandlerTimeout) {
+		t.Error("expected connection errors to be retried")
+	}
+}
+
+func newFlakyServer(failures int32, status int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(status)
+		}
+	}))
+	return server, &requests
+}
+
+func testDsn(url string) string {
+	return strings.Replace(url, "http://", "http://whatever@", 1) + "/1337"
+}
+
+var fastRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Millisecond * 10,
+}
+
+func TestHTTPTransportRetriesServerErrors(t *testing.T) {
+	server, requests := newFlakyServer(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.RetryPolicy = fastRetryPolicy
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+
+	deadline := time.Now().Add(testutils.FlushTimeout())
+	for atomic.LoadInt32(requests) < 3 && time.Now().Before(deadline) {
+		transport.Flush(testutils.FlushTimeout())
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestHTTPTransportDoesNotRetryClientErrors(t *testing.T) {
+	server, requests := newFlakyServer(10, http.StatusBadRequest)
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.RetryPolicy = fastRetryPolicy
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	transport.Flush(testutils.FlushTimeout())
+	time.Sleep(time.Millisecond * 50)
+	transport.Flush(testutils.FlushTimeout())
+
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Fatalf("expected a single attempt, got %d", n)
+	}
+}
+
+func TestHTTPSyncTransportRetriesUpToMaxAttempts(t *testing.T) {
+	server, requests := newFlakyServer(10, http.StatusInternalServerError)
+	defer server.Close()
+
+	transport := NewHTTPSyncTransport()
+	transport.RetryPolicy = fastRetryPolicy
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+}
@@ -0,0 +1,141 @@
+package sentry
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+)
+
+// TransportStatus is a snapshot of the state of a transport.
+type TransportStatus struct {
+	// Transport is the name of the transport: http, http_sync, disk or
+	// dry_run.
+	Transport string `json:"transport"`
+	// Configured reports whether the transport was configured with a valid
+	// DSN.
+	Configured bool `json:"configured"`
+	// RateLimits maps the rate limited categories to the end of their rate
+	// limit. The special category that applies to every payload is named
+	// "all". Expired rate limits are left out.
+	RateLimits map[string]time.Time `json:"rate_limits"`
+	// QueueLength is the number of envelopes waiting to be sent, and
+	// QueueCapacity the number of envelopes that fit in the buffer. Both are
+	// zero for the DiskTransport.
+	QueueLength   int `json:"queue_length"`
+	QueueCapacity int `json:"queue_capacity"`
+	// QueueBytes is the size of the envelopes queued on disk, and
+	// QueueMaxBytes the size at which the oldest ones are dropped, zero when
+	// unlimited. Both are zero for the in-memory transports.
+	QueueBytes    int64 `json:"queue_bytes"`
+	QueueMaxBytes int64 `json:"queue_max_bytes"`
+	// LastSuccess is when Sentry last accepted an envelope. It is zero until
+	// an envelope is accepted.
+	LastSuccess time.Time `json:"last_success"`
+	// DryRun holds the statistics of the DryRunTransport, nil for the other
+	// transports.
+	DryRun *DryRunStats `json:"dry_run,omitempty"`
+}
+
+// StatusReporter is implemented by the transports that can report their
+// status.
+type StatusReporter interface {
+	Status() TransportStatus
+}
+
+// RateLimited reports whether the category, or every category, is rate
+// limited.
+func (s TransportStatus) RateLimited(category string) bool {
+	now := time.Now()
+	for _, c := range []string{"all", category} {
+		if deadline, ok := s.RateLimits[c]; ok && deadline.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeRateLimits returns the rate limits of the map that are not expired.
+func activeRateLimits(limits ratelimit.Map) map[string]time.Time {
+	now := time.Now()
+	active := make(map[string]time.Time)
+	for category, deadline := range limits {
+		if !time.Time(deadline).After(now) {
+			continue
+		}
+		name := string(category)
+		if category == ratelimit.CategoryAll {
+			name = "all"
+		}
+		active[name] = time.Time(deadline)
+	}
+	return active
+}
+
+// lastSuccess records when Sentry last accepted an envelope.
+type lastSuccess struct {
+	unixNano atomic.Int64
+}
+
+// record updates the time of the last success if the response is
+// successful.
+func (l *lastSuccess) record(response *http.Response) {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		l.unixNano.Store(time.Now().UnixNano())
+	}
+}
+
+func (l *lastSuccess) time() time.Time {
+	n := l.unixNano.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// Status returns the status of the transport.
+func (t *HTTPTransport) Status() TransportStatus {
+	t.mu.RLock()
+	rateLimits := activeRateLimits(t.limits)
+	t.mu.RUnlock()
+
+	return TransportStatus{
+		Transport:     httpTransportName,
+		Configured:    t.dsn != nil,
+		RateLimits:    rateLimits,
+		QueueLength:   t.Pending(),
+		QueueCapacity: t.BufferSize,
+		LastSuccess:   t.lastSuccess.time(),
+	}
+}
+
+// Status returns the status of the transport.
+func (t *HTTPSyncTransport) Status() TransportStatus {
+	t.mu.Lock()
+	rateLimits := activeRateLimits(t.limits)
+	t.mu.Unlock()
+
+	return TransportStatus{
+		Transport:   httpSyncTransportName,
+		Configured:  t.dsn != nil,
+		RateLimits:  rateLimits,
+		LastSuccess: t.lastSuccess.time(),
+	}
+}
+
+// Status returns the status of the transport.
+func (t *DiskTransport) Status() TransportStatus {
+	t.mu.RLock()
+	rateLimits := activeRateLimits(t.limits)
+	t.mu.RUnlock()
+
+	return TransportStatus{
+		Transport:     diskTransportName,
+		Configured:    t.dsn != nil && t.log != nil,
+		RateLimits:    rateLimits,
+		QueueBytes:    t.QueueSize(),
+		QueueMaxBytes: t.MaxSize,
+		LastSuccess:   t.lastSuccess.time(),
+	}
+}
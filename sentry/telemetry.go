@@ -0,0 +1,133 @@
+package sentry
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/ratelimit"
+)
+
+// Names of the transports passed to TransportMetrics.
+const (
+	httpTransportName     = "http"
+	httpSyncTransportName = "http_sync"
+	diskTransportName     = "disk"
+)
+
+// Reasons for dropping an envelope, passed to TransportMetrics.
+const (
+	dropReasonBufferFull       = "buffer_full"
+	dropReasonRateLimited      = "rate_limited"
+	dropReasonRetriesExhausted = "retries_exhausted"
+	dropReasonEncodingError    = "encoding_error"
+	dropReasonQueueError       = "queue_error"
+)
+
+// TransportMetrics receives the measurements of the transports, so that they
+// can be exposed as metrics. Transports are identified by name: http,
+// http_sync, disk or dry_run.
+type TransportMetrics interface {
+	// EnvelopeSent is called for every envelope that reached Sentry, with the
+	// size of the request body and the status code of the response.
+	EnvelopeSent(transport string, bytes int64, status int)
+	// SendError is called for every request that failed without a response.
+	SendError(transport string)
+	// EnvelopeDropped is called for every envelope that will never be
+	// delivered, with the reason it was dropped.
+	EnvelopeDropped(transport string, reason string)
+	// RateLimited is called with the additional time Sentry asked us to back
+	// off for, by rate limit category. The category that applies to every
+	// payload is named "all".
+	RateLimited(category string, duration time.Duration)
+	// QueueDepthChanged is called when envelopes are added to, or taken
+	// from, the buffer of the HTTPTransport.
+	QueueDepthChanged(transport string, delta int)
+	// QueueBytes is called with the size of the DiskTransport queue when it
+	// changes.
+	QueueBytes(transport string, bytes int64)
+	// QueueBytesDropped is called when queued envelopes are dropped from the
+	// DiskTransport queue before being sent, because of its size or age caps
+	// or because they were corrupted.
+	QueueBytesDropped(transport string, reason string, bytes int64)
+}
+
+// noopTransportMetrics discards the measurements. It is used when
+// ClientOptions.TransportMetrics is nil.
+type noopTransportMetrics struct{}
+
+func (noopTransportMetrics) EnvelopeSent(string, int64, int)         {}
+func (noopTransportMetrics) SendError(string)                        {}
+func (noopTransportMetrics) EnvelopeDropped(string, string)          {}
+func (noopTransportMetrics) RateLimited(string, time.Duration)       {}
+func (noopTransportMetrics) QueueDepthChanged(string, int)           {}
+func (noopTransportMetrics) QueueBytes(string, int64)                {}
+func (noopTransportMetrics) QueueBytesDropped(string, string, int64) {}
+
+// transportTelemetry reports the measurements of a transport to the
+// TransportMetrics of its client options.
+type transportTelemetry struct {
+	transport string
+	metrics   TransportMetrics
+}
+
+func newTransportTelemetry(transport string, options ClientOptions) transportTelemetry {
+	metrics := options.TransportMetrics
+	if metrics == nil {
+		metrics = noopTransportMetrics{}
+	}
+	return transportTelemetry{transport: transport, metrics: metrics}
+}
+
+// mergeRateLimits merges the rate limits of a response into limits, and
+// accounts for the additional time Sentry asked us to back off for.
+func (t transportTelemetry) mergeRateLimits(limits ratelimit.Map, response *http.Response) {
+	update := ratelimit.FromResponse(response)
+	now := ratelimit.Deadline(time.Now())
+
+	for category, deadline := range update {
+		start := limits[category]
+		if !start.After(now) {
+			start = now
+		}
+
+		if deadline.After(start) {
+			t.metrics.RateLimited(categoryLabel(category), time.Time(deadline).Sub(time.Time(start)))
+		}
+	}
+
+	limits.Merge(update)
+}
+
+// response accounts for an envelope that reached Sentry.
+func (t transportTelemetry) response(request *http.Request, response *http.Response) {
+	t.metrics.EnvelopeSent(t.transport, max(request.ContentLength, 0), response.StatusCode)
+}
+
+// sendError accounts for a request that failed without a response.
+func (t transportTelemetry) sendError() {
+	t.metrics.SendError(t.transport)
+}
+
+// discardReasons maps the reasons for dropping an envelope to the reasons
+// reported to Sentry in client reports.
+var discardReasons = map[string]string{
+	dropReasonBufferFull:       discardReasonQueueOverflow,
+	dropReasonRateLimited:      discardReasonRateLimit,
+	dropReasonRetriesExhausted: discardReasonNetworkError,
+	dropReasonEncodingError:    discardReasonInternalError,
+	dropReasonQueueError:       discardReasonInternalError,
+}
+
+// drop accounts for an envelope that will never be delivered. quantity is the
+// number of items of the given category it carried.
+func (t transportTelemetry) drop(reason string, category ratelimit.Category, quantity int) {
+	t.metrics.EnvelopeDropped(t.transport, reason)
+	clientReports.record(discardReasons[reason], category, quantity)
+}
+
+func categoryLabel(c ratelimit.Category) string {
+	if c == ratelimit.CategoryAll {
+		return "all"
+	}
+	return string(c)
+}
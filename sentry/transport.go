@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aldy505/promsentry/sentry/ratelimit"
@@ -17,6 +19,7 @@ import (
 
 const defaultBufferSize = 30
 const defaultTimeout = time.Second * 30
+const defaultWorkers = 1
 
 // maxDrainResponseBytes is the maximum number of bytes that transport
 // implementations will read from response bodies when draining them.
@@ -53,15 +56,27 @@ func getProxyConfig(options ClientOptions) func(*http.Request) (*url.URL, error)
 }
 
 func getTLSConfig(options ClientOptions) *tls.Config {
-	if options.CaCerts != nil {
-		// #nosec G402 -- We should be using `MinVersion: tls.VersionTLS12`,
-		// 				 but we don't want to break peoples code without the major bump.
-		return &tls.Config{
-			RootCAs: options.CaCerts,
-		}
+	if options.CaCerts == nil && len(options.ClientCertificates) == 0 && options.TLSMinVersion == 0 {
+		return nil
 	}
 
-	return nil
+	// #nosec G402 -- MinVersion is ClientOptions.TLSMinVersion. Zero keeps the
+	// default of crypto/tls, which is TLS 1.2 for clients.
+	return &tls.Config{
+		RootCAs:      options.CaCerts,
+		Certificates: options.ClientCertificates,
+		MinVersion:   options.TLSMinVersion,
+	}
+}
+
+// NewHTTPRoundTripper returns the http.Transport that the transports use when
+// ClientOptions.HTTPTransport is not set, with the proxy and TLS options
+// applied. It lets a custom round tripper wrap the same connection settings.
+func NewHTTPRoundTripper(options ClientOptions) *http.Transport {
+	return &http.Transport{
+		Proxy:           getProxyConfig(options),
+		TLSClientConfig: getTLSConfig(options),
+	}
 }
 
 func getRequestBodyFromEvent(event *Event) []byte {
@@ -136,48 +151,59 @@ func envelopeFromBody(event *Event, dsn *Dsn, sentAt time.Time, body json.RawMes
 		return nil, err
 	}
 
-	err = encodeEnvelopeItem(enc, "statsd", body)
+	// The item length must match the payload that is actually written.
+	payload := bytes.TrimSuffix(bytes.TrimPrefix(body, []byte("\n")), []byte("\n"))
+
+	err = encodeEnvelopeItem(enc, "statsd", payload)
 	if err != nil {
 		return nil, err
 	}
 
-	b.Write(bytes.TrimSuffix(bytes.TrimPrefix(event.metrics, []byte("\n")), []byte("\n")))
+	b.Write(payload)
 	b.WriteString("\n")
 
 	return &b, nil
 }
 
-func getRequestFromEvent(event *Event, dsn *Dsn) (r *http.Request, err error) {
-	defer func() {
-		if r != nil {
-			r.Header.Set("User-Agent", fmt.Sprintf("%s/%s", event.Sdk.Name, event.Sdk.Version))
-			r.Header.Set("Content-Type", "application/x-sentry-envelope")
-
-			auth := fmt.Sprintf("Sentry sentry_version=%s, "+
-				"sentry_client=%s/%s, sentry_key=%s", apiVersion, event.Sdk.Name, event.Sdk.Version, dsn.publicKey)
-
-			// The key sentry_secret is effectively deprecated and no longer needs to be set.
-			// However, since it was required in older self-hosted versions,
-			// it should still passed through to Sentry if set.
-			if dsn.secretKey != "" {
-				auth = fmt.Sprintf("%s, sentry_secret=%s", auth, dsn.secretKey)
-			}
-
-			r.Header.Set("X-Sentry-Auth", auth)
-		}
-	}()
-
+func getRequestFromEvent(event *Event, dsn *Dsn) (*http.Request, error) {
 	body := event.metrics
 
 	envelope, err := envelopeFromBody(event, dsn, time.Now(), body)
 	if err != nil {
 		return nil, err
 	}
-	return http.NewRequest(
+
+	return getRequestFromEnvelope(envelope, dsn, event.Sdk.Name, event.Sdk.Version)
+}
+
+// getRequestFromEnvelope builds the HTTP request that delivers an already
+// serialized envelope to the project of the given DSN.
+func getRequestFromEnvelope(envelope *bytes.Buffer, dsn *Dsn, sdkName, sdkVersion string) (*http.Request, error) {
+	r, err := http.NewRequest(
 		http.MethodPost,
 		dsn.GetAPIURL().String(),
 		envelope,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("User-Agent", fmt.Sprintf("%s/%s", sdkName, sdkVersion))
+	r.Header.Set("Content-Type", "application/x-sentry-envelope")
+
+	auth := fmt.Sprintf("Sentry sentry_version=%s, "+
+		"sentry_client=%s/%s, sentry_key=%s", apiVersion, sdkName, sdkVersion, dsn.publicKey)
+
+	// The key sentry_secret is effectively deprecated and no longer needs to be set.
+	// However, since it was required in older self-hosted versions,
+	// it should still passed through to Sentry if set.
+	if dsn.secretKey != "" {
+		auth = fmt.Sprintf("%s, sentry_secret=%s", auth, dsn.secretKey)
+	}
+
+	r.Header.Set("X-Sentry-Auth", auth)
+
+	return r, nil
 }
 
 func categoryFor(eventType string) ratelimit.Category {
@@ -186,6 +212,8 @@ func categoryFor(eventType string) ratelimit.Category {
 		return ratelimit.CategoryError
 	case transactionType:
 		return ratelimit.CategoryTransaction
+	case metricType:
+		return ratelimit.CategoryStatsd
 	default:
 		return ratelimit.Category(eventType)
 	}
@@ -195,7 +223,7 @@ func categoryFor(eventType string) ratelimit.Category {
 // HTTPTransport
 // ================================
 
-// A batch groups items that are processed sequentially.
+// A batch groups items that are processed together by the workers.
 type batch struct {
 	items   chan batchItem
 	started chan struct{} // closed to signal items started to be worked on
@@ -205,17 +233,26 @@ type batch struct {
 type batchItem struct {
 	request  *http.Request
 	category ratelimit.Category
+	// Number of items of the category carried by the request.
+	quantity int
+	// Number of delivery attempts made so far.
+	attempts int
 }
 
 // HTTPTransport is the default, non-blocking, implementation of Transport.
 //
 // Clients using this transport will enqueue requests in a buffer and return to
 // the caller before any network communication has happened. Requests are sent
-// to Sentry sequentially from a background goroutine.
+// to Sentry from background goroutines, by up to Workers requests at a time.
+// Workers take requests from the buffer in the order they were enqueued.
 type HTTPTransport struct {
 	dsn       *Dsn
 	client    *http.Client
 	transport http.RoundTripper
+	logger    *slog.Logger
+	telemetry transportTelemetry
+
+	lastSuccess lastSuccess
 
 	// buffer is a channel of batches. Calling Flush terminates work on the
 	// current in-flight items and starts a new batch for subsequent events.
@@ -227,6 +264,22 @@ type HTTPTransport struct {
 	BufferSize int
 	// HTTP Client request timeout. Defaults to 30 seconds.
 	Timeout time.Duration
+	// Number of requests that are sent concurrently. Defaults to 1.
+	Workers int
+	// How failed deliveries are retried. Defaults to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+	// Content encoding of the envelopes. Defaults to no compression.
+	Compression Compression
+	// Level of the compression algorithm. Zero selects its default level.
+	CompressionLevel int
+
+	compressor    *compressor
+	clientReports *clientReportSchedule
+
+	// Number of items in the buffer or being sent, and number of items
+	// waiting for their retry backoff to expire.
+	pending  atomic.Int64
+	retrying atomic.Int64
 
 	mu     sync.RWMutex
 	limits ratelimit.Map
@@ -235,22 +288,37 @@ type HTTPTransport struct {
 // NewHTTPTransport returns a new pre-configured instance of HTTPTransport.
 func NewHTTPTransport() *HTTPTransport {
 	transport := HTTPTransport{
-		BufferSize: defaultBufferSize,
-		Timeout:    defaultTimeout,
-		limits:     make(ratelimit.Map),
+		BufferSize:  defaultBufferSize,
+		Timeout:     defaultTimeout,
+		Workers:     defaultWorkers,
+		RetryPolicy: DefaultRetryPolicy(),
+		limits:      make(ratelimit.Map),
+		logger:      newLogger(ClientOptions{}),
+		telemetry:   newTransportTelemetry(httpTransportName, ClientOptions{}),
 	}
 	return &transport
 }
 
 // Configure is called by the Client itself, providing it it's own ClientOptions.
 func (t *HTTPTransport) Configure(options ClientOptions) {
+	t.logger = newLogger(options)
+	t.telemetry = newTransportTelemetry(httpTransportName, options)
+
 	dsn, err := NewDsn(options.Dsn)
 	if err != nil {
-		Logger.Printf("%v\n", err)
+		t.logger.Error("Invalid DSN", "error", err)
 		return
 	}
 	t.dsn = dsn
 
+	t.compressor, err = newCompressor(t.Compression, t.CompressionLevel)
+	if err != nil {
+		t.logger.Warn("Sending envelopes uncompressed", "error", err)
+	}
+
+	t.clientReports.stop()
+	t.clientReports = &clientReportSchedule{enabled: options.SendClientReports}
+
 	// A buffered channel with capacity 1 works like a mutex, ensuring only one
 	// goroutine can access the current batch at a given time. Access is
 	// synchronized by reading from and writing to the channel.
@@ -261,13 +329,17 @@ func (t *HTTPTransport) Configure(options ClientOptions) {
 		done:    make(chan struct{}),
 	}
 
+	if t.Workers < 1 {
+		t.Workers = defaultWorkers
+	}
+
 	if options.HTTPTransport != nil {
 		t.transport = options.HTTPTransport
 	} else {
-		t.transport = &http.Transport{
-			Proxy:           getProxyConfig(options),
-			TLSClientConfig: getTLSConfig(options),
-		}
+		transport := NewHTTPRoundTripper(options)
+		// Keep a connection around for every worker.
+		transport.MaxIdleConnsPerHost = t.Workers
+		t.transport = transport
 	}
 
 	if options.HTTPClient != nil {
@@ -282,6 +354,8 @@ func (t *HTTPTransport) Configure(options ClientOptions) {
 	t.start.Do(func() {
 		go t.worker()
 	})
+
+	t.clientReports.run(t.sendClientReport)
 }
 
 // SendEvent assembles a new packet out of Event and sends it to remote server.
@@ -290,17 +364,51 @@ func (t *HTTPTransport) SendEvent(event *Event) {
 		return
 	}
 
+	defer t.sendClientReport(false)
+
 	category := categoryFor(event.Type)
+	quantity := eventQuantity(event)
 
 	if t.disabled(category) {
+		t.telemetry.drop(dropReasonRateLimited, category, quantity)
 		return
 	}
 
 	request, err := getRequestFromEvent(event, t.dsn)
 	if err != nil {
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
+		return
+	}
+
+	if err := t.compressor.compress(request); err != nil {
+		t.logger.Warn("Unable to compress the event", "error", err)
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
 		return
 	}
 
+	if !t.enqueue(batchItem{request: request, category: category, quantity: quantity}) {
+		t.logger.Warn("Event dropped, the transport buffer is full")
+		t.telemetry.drop(dropReasonBufferFull, category, quantity)
+		return
+	}
+
+	var eventType string
+	if event.Type == transactionType {
+		eventType = "transaction"
+	} else {
+		eventType = fmt.Sprintf("%s event", event.Level)
+	}
+	t.logger.Debug("Sending event",
+		"type", eventType,
+		"event_id", event.EventID,
+		"host", t.dsn.host,
+		"project", t.dsn.projectID,
+	)
+}
+
+// enqueue adds the item to the current batch. It returns false if the item was
+// dropped because the buffer is full.
+func (t *HTTPTransport) enqueue(item batchItem) bool {
 	// <-t.buffer is equivalent to acquiring a lock to access the current batch.
 	// A few lines below, t.buffer <- b releases the lock.
 	//
@@ -313,30 +421,18 @@ func (t *HTTPTransport) SendEvent(event *Event) {
 	// is, the event is dropped if it cannot be sent immediately to the b.items
 	// channel (used as a queue).
 	b := <-t.buffer
+	defer func() {
+		t.buffer <- b
+	}()
 
 	select {
-	case b.items <- batchItem{
-		request:  request,
-		category: category,
-	}:
-		var eventType string
-		if event.Type == transactionType {
-			eventType = "transaction"
-		} else {
-			eventType = fmt.Sprintf("%s event", event.Level)
-		}
-		Logger.Printf(
-			"Sending %s [%s] to %s project: %s",
-			eventType,
-			event.EventID,
-			t.dsn.host,
-			t.dsn.projectID,
-		)
+	case b.items <- item:
+		t.pending.Add(1)
+		t.telemetry.metrics.QueueDepthChanged(httpTransportName, 1)
+		return true
 	default:
-		Logger.Println("Event dropped due to transport buffer being full.")
+		return false
 	}
-
-	t.buffer <- b
 }
 
 // Flush waits until any buffered events are sent to the Sentry server, blocking
@@ -349,9 +445,44 @@ func (t *HTTPTransport) SendEvent(event *Event) {
 // Do not call Flush indiscriminately after every call to SendEvent. Instead, to
 // have the SDK send events over the network synchronously, configure it to use
 // the HTTPSyncTransport in the call to Init.
+//
+// Flush also waits for the deliveries that failed and are waiting to be
+// retried, until they are sent or their attempts are exhausted.
 func (t *HTTPTransport) Flush(timeout time.Duration) bool {
 	toolate := time.After(timeout)
 
+	t.sendClientReport(true)
+
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for {
+		if !t.flushBatch(toolate) {
+			t.logger.Warn("Buffer flushing reached the timeout")
+			return false
+		}
+
+		// A retry is added to the buffer before it stops counting as
+		// retrying, so checking in this order doesn't miss it.
+		if t.retrying.Load() == 0 && t.pending.Load() == 0 {
+			t.logger.Debug("Buffer flushed")
+			return true
+		}
+
+		// Retries are added to the buffer again once their backoff expires,
+		// the next batch picks them up.
+		select {
+		case <-ticker.C:
+		case <-toolate:
+			t.logger.Warn("Buffer flushing reached the timeout")
+			return false
+		}
+	}
+}
+
+// flushBatch waits until the items of the current batch are processed. It
+// returns false if toolate fires first.
+func (t *HTTPTransport) flushBatch(toolate <-chan time.Time) bool {
 	// Wait until processing the current batch has started or the timeout.
 	//
 	// We must wait until the worker has seen the current batch, because it is
@@ -369,7 +500,7 @@ func (t *HTTPTransport) Flush(timeout time.Duration) bool {
 				t.buffer <- b
 			}
 		case <-toolate:
-			goto fail
+			return false
 		}
 	}
 
@@ -387,15 +518,10 @@ started:
 	// Wait until the current batch is done or the timeout.
 	select {
 	case <-b.done:
-		Logger.Println("Buffer flushed successfully.")
 		return true
 	case <-toolate:
-		goto fail
+		return false
 	}
-
-fail:
-	Logger.Println("Buffer flushing reached the timeout.")
-	return false
 }
 
 func (t *HTTPTransport) worker() {
@@ -407,41 +533,148 @@ func (t *HTTPTransport) worker() {
 		// Equivalent to releasing a lock.
 		t.buffer <- b
 
-		// Process all batch items.
-		for item := range b.items {
-			if t.disabled(item.category) {
-				continue
-			}
+		// Process all batch items, with up to t.Workers concurrent
+		// deliveries. The batch is done once every worker returned.
+		var wg sync.WaitGroup
+		for i := 0; i < t.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for item := range b.items {
+					t.telemetry.metrics.QueueDepthChanged(httpTransportName, -1)
+
+					if t.disabled(item.category) {
+						t.telemetry.drop(dropReasonRateLimited, item.category, item.quantity)
+					} else {
+						t.send(item)
+					}
+					t.pending.Add(-1)
+				}
+			}()
+		}
+		wg.Wait()
 
-			response, err := t.client.Do(item.request)
-			if err != nil {
-				Logger.Printf("There was an issue with sending an event: %v", err)
-				continue
-			}
+		// Signal that processing of the batch is done.
+		close(b.done)
+	}
+}
+
+// send delivers a single batch item. Failed deliveries that are worth retrying
+// are scheduled to be added to the buffer again after a backoff, so that the
+// worker can move on with the other items in the meantime.
+func (t *HTTPTransport) send(item batchItem) {
+	request, err := cloneRequest(item.request)
+	if err != nil {
+		t.logger.Warn("Unable to send an event", "error", err)
+		t.telemetry.drop(dropReasonEncodingError, item.category, item.quantity)
+		return
+	}
+	item.attempts++
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		t.logger.Warn("Unable to send an event", "error", err)
+		t.telemetry.sendError()
+		t.retry(item, nil)
+		return
+	}
+	t.telemetry.response(request, response)
+	t.lastSuccess.record(response)
+
+	body, _ := io.ReadAll(response.Body)
+	t.logger.Debug("Sentry response", "status", response.StatusCode, "body", string(body))
+
+	t.mu.Lock()
+	t.telemetry.mergeRateLimits(t.limits, response)
+	t.mu.Unlock()
+	// Drain body up to a limit and close it, allowing the
+	// transport to reuse TCP connections.
+	_, _ = io.CopyN(io.Discard, response.Body, maxDrainResponseBytes)
+	response.Body.Close()
 
-			body, _ := io.ReadAll(response.Body)
-			Logger.Printf("Response: %d\n%s\n", response.StatusCode, string(body))
+	if shouldRetry(response, nil) {
+		t.retry(item, response)
+	}
+}
+
+func (t *HTTPTransport) retry(item batchItem, response *http.Response) {
+	if !t.RetryPolicy.allows(item.attempts) {
+		t.logger.Warn("Event dropped after failed attempts", "attempts", item.attempts)
+		t.telemetry.drop(dropReasonRetriesExhausted, item.category, item.quantity)
+		return
+	}
 
-			t.mu.Lock()
-			t.limits.Merge(ratelimit.FromResponse(response))
-			t.mu.Unlock()
-			// Drain body up to a limit and close it, allowing the
-			// transport to reuse TCP connections.
-			_, _ = io.CopyN(io.Discard, response.Body, maxDrainResponseBytes)
-			response.Body.Close()
+	t.mu.RLock()
+	deadline := t.limits.Deadline(item.category)
+	t.mu.RUnlock()
+
+	delay := t.RetryPolicy.delay(item.attempts, response, deadline)
+	t.logger.Debug("Retrying event", "delay", delay, "attempt", item.attempts+1, "max_attempts", t.RetryPolicy.MaxAttempts)
+
+	t.retrying.Add(1)
+	time.AfterFunc(delay, func() {
+		defer t.retrying.Add(-1)
+		if !t.enqueue(item) {
+			t.logger.Warn("Event dropped, the transport buffer is full")
+			t.telemetry.drop(dropReasonBufferFull, item.category, item.quantity)
 		}
+	})
+}
 
-		// Signal that processing of the batch is done.
-		close(b.done)
+// Close stops sending client reports and releases the compression encoder of
+// the transport. It doesn't wait for the buffered events, call Flush first.
+func (t *HTTPTransport) Close() error {
+	t.clientReports.stop()
+	t.compressor.close()
+	return nil
+}
+
+// Pending returns the number of events that were not sent yet, including the
+// events waiting to be retried. They are lost if the program terminates.
+func (t *HTTPTransport) Pending() int {
+	return int(t.pending.Load() + t.retrying.Load())
+}
+
+// sendClientReport adds the pending client report to the buffer. Unless force
+// is set, it does nothing if a report was sent recently.
+func (t *HTTPTransport) sendClientReport(force bool) {
+	report := t.clientReports.due(force)
+	if report == nil {
+		return
+	}
+
+	envelope, err := envelopeFromClientReport(report, t.dsn)
+	if err != nil {
+		t.logger.Warn("Unable to encode the client report", "error", err)
+		return
+	}
+
+	request, err := getRequestFromEnvelope(envelope, t.dsn, sdkIdentifier, SDKVersion)
+	if err != nil {
+		t.logger.Warn("Unable to create a request for the client report", "error", err)
+		return
+	}
+
+	if err := t.compressor.compress(request); err != nil {
+		t.logger.Warn("Unable to compress the client report", "error", err)
+		return
+	}
+
+	if !t.enqueue(batchItem{request: request, category: clientReportCategory}) {
+		clientReports.restore(report)
 	}
 }
 
 func (t *HTTPTransport) disabled(c ratelimit.Category) bool {
+	if c == clientReportCategory {
+		return false
+	}
+
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	disabled := t.limits.IsRateLimited(c)
 	if disabled {
-		Logger.Printf("Too many requests for %q, backing off till: %v", c, t.limits.Deadline(c))
+		t.logger.Warn("Too many requests, backing off", "category", c, "until", t.limits.Deadline(c))
 	}
 	return disabled
 }
@@ -465,19 +698,36 @@ type HTTPSyncTransport struct {
 	dsn       *Dsn
 	client    *http.Client
 	transport http.RoundTripper
+	logger    *slog.Logger
+	telemetry transportTelemetry
+
+	lastSuccess lastSuccess
 
 	mu     sync.Mutex
 	limits ratelimit.Map
 
 	// HTTP Client request timeout. Defaults to 30 seconds.
 	Timeout time.Duration
+	// How failed deliveries are retried. Defaults to DefaultRetryPolicy.
+	// Retries block the caller of SendEvent.
+	RetryPolicy RetryPolicy
+	// Content encoding of the envelopes. Defaults to no compression.
+	Compression Compression
+	// Level of the compression algorithm. Zero selects its default level.
+	CompressionLevel int
+
+	compressor    *compressor
+	clientReports *clientReportSchedule
 }
 
 // NewHTTPSyncTransport returns a new pre-configured instance of HTTPSyncTransport.
 func NewHTTPSyncTransport() *HTTPSyncTransport {
 	transport := HTTPSyncTransport{
-		Timeout: defaultTimeout,
-		limits:  make(ratelimit.Map),
+		Timeout:     defaultTimeout,
+		RetryPolicy: DefaultRetryPolicy(),
+		limits:      make(ratelimit.Map),
+		logger:      newLogger(ClientOptions{}),
+		telemetry:   newTransportTelemetry(httpSyncTransportName, ClientOptions{}),
 	}
 
 	return &transport
@@ -485,20 +735,28 @@ func NewHTTPSyncTransport() *HTTPSyncTransport {
 
 // Configure is called by the Client itself, providing it it's own ClientOptions.
 func (t *HTTPSyncTransport) Configure(options ClientOptions) {
+	t.logger = newLogger(options)
+	t.telemetry = newTransportTelemetry(httpSyncTransportName, options)
+
 	dsn, err := NewDsn(options.Dsn)
 	if err != nil {
-		Logger.Printf("%v\n", err)
+		t.logger.Error("Invalid DSN", "error", err)
 		return
 	}
 	t.dsn = dsn
 
+	t.compressor, err = newCompressor(t.Compression, t.CompressionLevel)
+	if err != nil {
+		t.logger.Warn("Sending envelopes uncompressed", "error", err)
+	}
+
+	t.clientReports.stop()
+	t.clientReports = &clientReportSchedule{enabled: options.SendClientReports}
+
 	if options.HTTPTransport != nil {
 		t.transport = options.HTTPTransport
 	} else {
-		t.transport = &http.Transport{
-			Proxy:           getProxyConfig(options),
-			TLSClientConfig: getTLSConfig(options),
-		}
+		t.transport = NewHTTPRoundTripper(options)
 	}
 
 	if options.HTTPClient != nil {
@@ -509,6 +767,8 @@ func (t *HTTPSyncTransport) Configure(options ClientOptions) {
 			Timeout:   t.Timeout,
 		}
 	}
+
+	t.clientReports.run(t.sendClientReport)
 }
 
 // SendEvent assembles a new packet out of Event and sends it to remote server.
@@ -517,12 +777,25 @@ func (t *HTTPSyncTransport) SendEvent(event *Event) {
 		return
 	}
 
-	if t.disabled(categoryFor(event.Type)) {
+	defer t.sendClientReport(false)
+
+	category := categoryFor(event.Type)
+	quantity := eventQuantity(event)
+
+	if t.disabled(category) {
+		t.telemetry.drop(dropReasonRateLimited, category, quantity)
 		return
 	}
 
 	request, err := getRequestFromEvent(event, t.dsn)
 	if err != nil {
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
+		return
+	}
+
+	if err := t.compressor.compress(request); err != nil {
+		t.logger.Warn("Unable to compress the event", "error", err)
+		t.telemetry.drop(dropReasonEncodingError, category, quantity)
 		return
 	}
 
@@ -532,44 +805,128 @@ func (t *HTTPSyncTransport) SendEvent(event *Event) {
 	} else {
 		eventType = fmt.Sprintf("%s event", event.Level)
 	}
-	Logger.Printf(
-		"Sending %s [%s] to %s project: %s",
-		eventType,
-		event.EventID,
-		t.dsn.host,
-		t.dsn.projectID,
+	t.logger.Debug("Sending event",
+		"type", eventType,
+		"event_id", event.EventID,
+		"host", t.dsn.host,
+		"project", t.dsn.projectID,
 	)
 
+	for attempts := 1; ; attempts++ {
+		response, err := t.send(request)
+		if !shouldRetry(response, err) {
+			return
+		}
+
+		if !t.RetryPolicy.allows(attempts) {
+			t.logger.Warn("Event dropped after failed attempts", "attempts", attempts)
+			t.telemetry.drop(dropReasonRetriesExhausted, category, quantity)
+			return
+		}
+
+		t.mu.Lock()
+		deadline := t.limits.Deadline(category)
+		t.mu.Unlock()
+
+		delay := t.RetryPolicy.delay(attempts, response, deadline)
+		t.logger.Debug("Retrying event", "delay", delay, "attempt", attempts+1, "max_attempts", t.RetryPolicy.MaxAttempts)
+		time.Sleep(delay)
+
+		request, err = cloneRequest(request)
+		if err != nil {
+			t.logger.Warn("Unable to send an event", "error", err)
+			t.telemetry.drop(dropReasonEncodingError, category, quantity)
+			return
+		}
+	}
+}
+
+// send delivers a single request. The returned response has its body closed
+// already.
+func (t *HTTPSyncTransport) send(request *http.Request) (*http.Response, error) {
 	response, err := t.client.Do(request)
 	if err != nil {
-		Logger.Printf("There was an issue with sending an event: %v", err)
-		return
+		t.logger.Warn("Unable to send an event", "error", err)
+		t.telemetry.sendError()
+		return nil, err
 	}
+	t.telemetry.response(request, response)
+	t.lastSuccess.record(response)
 
 	body, _ := io.ReadAll(response.Body)
-	Logger.Printf("Response: %d\n%s\n", response.StatusCode, string(body))
+	t.logger.Debug("Sentry response", "status", response.StatusCode, "body", string(body))
 
 	t.mu.Lock()
-	t.limits.Merge(ratelimit.FromResponse(response))
+	t.telemetry.mergeRateLimits(t.limits, response)
 	t.mu.Unlock()
 
 	// Drain body up to a limit and close it, allowing the
 	// transport to reuse TCP connections.
 	_, _ = io.CopyN(io.Discard, response.Body, maxDrainResponseBytes)
 	response.Body.Close()
+
+	return response, nil
 }
 
-// Flush is a no-op for HTTPSyncTransport. It always returns true immediately.
+// Flush sends the pending client report, if any. Events are never buffered by
+// HTTPSyncTransport, so it always returns true.
 func (t *HTTPSyncTransport) Flush(_ time.Duration) bool {
+	t.sendClientReport(true)
 	return true
 }
 
+// Close stops sending client reports and releases the compression encoder of
+// the transport.
+func (t *HTTPSyncTransport) Close() error {
+	t.clientReports.stop()
+	t.compressor.close()
+	return nil
+}
+
+// sendClientReport sends the pending client report. Unless force is set, it
+// does nothing if a report was sent recently.
+func (t *HTTPSyncTransport) sendClientReport(force bool) {
+	if t.dsn == nil {
+		return
+	}
+
+	report := t.clientReports.due(force)
+	if report == nil {
+		return
+	}
+
+	envelope, err := envelopeFromClientReport(report, t.dsn)
+	if err != nil {
+		t.logger.Warn("Unable to encode the client report", "error", err)
+		return
+	}
+
+	request, err := getRequestFromEnvelope(envelope, t.dsn, sdkIdentifier, SDKVersion)
+	if err != nil {
+		t.logger.Warn("Unable to create a request for the client report", "error", err)
+		return
+	}
+
+	if err := t.compressor.compress(request); err != nil {
+		t.logger.Warn("Unable to compress the client report", "error", err)
+		return
+	}
+
+	if _, err := t.send(request); err != nil {
+		clientReports.restore(report)
+	}
+}
+
 func (t *HTTPSyncTransport) disabled(c ratelimit.Category) bool {
+	if c == clientReportCategory {
+		return false
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	disabled := t.limits.IsRateLimited(c)
 	if disabled {
-		Logger.Printf("Too many requests for %q, backing off till: %v", c, t.limits.Deadline(c))
+		t.logger.Warn("Too many requests, backing off", "category", c, "until", t.limits.Deadline(c))
 	}
 	return disabled
 }
@@ -0,0 +1,314 @@
+package sentry
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry/testutils"
+)
+
+func TestHTTPTransportWorkersSendConcurrently(t *testing.T) {
+	const workers = 4
+
+	var inFlight, maxInFlight, requests int32
+	release := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			current := atomic.LoadInt32(&maxInFlight)
+			if n <= current || atomic.CompareAndSwapInt32(&maxInFlight, current, n) {
+				break
+			}
+		}
+		if n == workers {
+			once.Do(func() { close(release) })
+		}
+
+		select {
+		case <-release:
+		case <-time.After(testutils.FlushTimeout()):
+		}
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.Workers = workers
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	for i := 0; i < workers*2; i++ {
+		transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	}
+
+	if !transport.Flush(testutils.FlushTimeout() * 2) {
+		t.Fatal("Flush timed out")
+	}
+
+	// Flush waits for every in-flight item of the batch.
+	if n := atomic.LoadInt32(&requests); n != workers*2 {
+		t.Errorf("expected %d requests once flushed, got %d", workers*2, n)
+	}
+	if n := atomic.LoadInt32(&maxInFlight); n != workers {
+		t.Errorf("expected %d concurrent requests, got %d", workers, n)
+	}
+}
+
+func TestHTTPTransportDefaultsToSingleWorker(t *testing.T) {
+	transport := NewHTTPTransport()
+	transport.Workers = 0
+	transport.Configure(ClientOptions{Dsn: "https://whatever@example.com/1337"})
+	assertEqual(t, transport.Workers, 1)
+}
+
+func TestHTTPTransportPending(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	for i := 0; i < 3; i++ {
+		transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	}
+	assertEqual(t, transport.Pending(), 3)
+
+	close(release)
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	assertEqual(t, transport.Pending(), 0)
+}
+
+func TestGetTLSConfig(t *testing.T) {
+	if config := getTLSConfig(ClientOptions{}); config != nil {
+		t.Errorf("expected no TLS config without TLS options, got %+v", config)
+	}
+
+	pool := x509.NewCertPool()
+	certificates := []tls.Certificate{{}}
+	config := getTLSConfig(ClientOptions{
+		CaCerts:            pool,
+		ClientCertificates: certificates,
+		TLSMinVersion:      tls.VersionTLS13,
+	})
+	if config == nil {
+		t.Fatal("expected a TLS config")
+	}
+	if config.RootCAs != pool {
+		t.Error("expected the CA certificates to be used as root CAs")
+	}
+	assertEqual(t, len(config.Certificates), 1)
+	assertEqual(t, config.MinVersion, uint16(tls.VersionTLS13))
+}
+
+func TestHTTPTransportTrustsCaCerts(t *testing.T) {
+	var requests int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	transport := NewHTTPTransport()
+	transport.Configure(ClientOptions{
+		Dsn:           strings.Replace(server.URL, "https://", "https://whatever@", 1) + "/1337",
+		CaCerts:       pool,
+		TLSMinVersion: tls.VersionTLS12,
+	})
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected 1 request over TLS, got %d", n)
+	}
+}
+
+func TestHTTPTransportLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"0"}`))
+	}))
+	defer server.Close()
+
+	var output syncBuffer
+	transport := NewHTTPTransport()
+	transport.Configure(ClientOptions{
+		Dsn:    testDsn(server.URL),
+		Logger: slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	if got := output.String(); !strings.Contains(got, `"msg":"Sentry response","status":200`) {
+		t.Errorf("expected the response to be logged, got:\n%s", got)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestHTTPTransportStatus(t *testing.T) {
+	var limited atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limited.Load() {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+	if transport.Status().Configured {
+		t.Error("expected the transport not to be configured before Configure")
+	}
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+
+	status := transport.Status()
+	if !status.Configured || status.QueueCapacity != defaultBufferSize || status.LastSuccess.IsZero() {
+		t.Errorf("unexpected status after a success: %+v", status)
+	}
+	if status.RateLimited("statsd") {
+		t.Error("expected no rate limit")
+	}
+
+	limited.Store(true)
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	transport.Flush(testutils.FlushTimeout())
+
+	status = transport.Status()
+	if !status.RateLimited("statsd") {
+		t.Errorf("expected the metrics to be rate limited, got %+v", status.RateLimits)
+	}
+}
+
+func TestHTTPTransportFlushWaitsForRetries(t *testing.T) {
+	server, requests := newFlakyServer(1, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.RetryPolicy = RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond * 200}
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	if !transport.Flush(testutils.FlushTimeout()) {
+		t.Fatal("Flush timed out")
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("expected Flush to wait for the retry, got %d attempts", n)
+	}
+	if pending := transport.Pending(); pending != 0 {
+		t.Errorf("expected no pending event, got %d", pending)
+	}
+}
+
+func TestHTTPTransportMetricRateLimit(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("X-Sentry-Rate-Limits", "60:metric_bucket:organization")
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport()
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL)})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	transport.Flush(testutils.FlushTimeout())
+
+	status := transport.Status()
+	if !status.RateLimited("statsd") {
+		t.Errorf("expected the metrics to be rate limited, got %+v", status.RateLimits)
+	}
+	if status.RateLimited("error") {
+		t.Error("expected the other categories not to be rate limited")
+	}
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	transport.Flush(testutils.FlushTimeout())
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected the second metric to be dropped, got %d requests", n)
+	}
+}
+
+// recordingMetrics records the measurements of the transports.
+type recordingMetrics struct {
+	noopTransportMetrics
+
+	mu      sync.Mutex
+	sent    []int
+	dropped []string
+}
+
+func (m *recordingMetrics) EnvelopeSent(transport string, bytes int64, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, status)
+}
+
+func (m *recordingMetrics) EnvelopeDropped(transport string, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped = append(m.dropped, transport+":"+reason)
+}
+
+func TestHTTPTransportMetrics(t *testing.T) {
+	server, _ := newFlakyServer(1, http.StatusBadRequest)
+	defer server.Close()
+
+	metrics := &recordingMetrics{}
+	transport := NewHTTPTransport()
+	transport.Configure(ClientOptions{Dsn: testDsn(server.URL), TransportMetrics: metrics})
+
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	transport.SendEvent((&Client{}).EventFromMetric(Metric("foo:1|c")))
+	transport.Flush(testutils.FlushTimeout())
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if len(metrics.sent) != 2 || metrics.sent[0] != http.StatusBadRequest || metrics.sent[1] != http.StatusOK {
+		t.Errorf("unexpected responses %v", metrics.sent)
+	}
+	if len(metrics.dropped) != 0 {
+		t.Errorf("unexpected drops %v", metrics.dropped)
+	}
+}
@@ -0,0 +1,763 @@
+// Package wal implements a small segmented, append-only log that is used to
+// persist outbound envelopes on disk until they are delivered.
+//
+// Records are appended to the newest segment file. A single reader consumes
+// records in order with Peek and Commit, and the committed position is kept
+// in a cursor file so that unsent records are replayed after a restart.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentSuffix = ".seg"
+	cursorName    = "cursor"
+
+	// headerSize is the size of the record header: the payload length and
+	// the CRC32 checksum of the payload, both as big endian uint32.
+	headerSize = 8
+	cursorSize = 16
+
+	defaultMaxSegmentSize = 16 << 20
+	defaultFsyncInterval  = time.Second
+)
+
+// ErrClosed is returned when operating on a closed Log.
+var ErrClosed = errors.New("wal: log is closed")
+
+var errCorrupted = errors.New("wal: corrupted record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FsyncPolicy decides when appended records are synced to stable storage.
+type FsyncPolicy int
+
+const (
+	// FsyncInterval syncs pending writes every Options.FsyncInterval.
+	FsyncInterval FsyncPolicy = iota
+	// FsyncAlways syncs after every appended record.
+	FsyncAlways
+	// FsyncNever leaves syncing to the operating system.
+	FsyncNever
+)
+
+// ParseFsyncPolicy converts "interval", "always" or "never" into a FsyncPolicy.
+// An empty string is treated as "interval".
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "interval":
+		return FsyncInterval, nil
+	case "always":
+		return FsyncAlways, nil
+	case "never":
+		return FsyncNever, nil
+	default:
+		return FsyncInterval, fmt.Errorf("unknown fsync policy %q", s)
+	}
+}
+
+// String returns the policy name as accepted by ParseFsyncPolicy.
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncNever:
+		return "never"
+	default:
+		return "interval"
+	}
+}
+
+// Options configures a Log.
+type Options struct {
+	// Size in bytes after which a new segment is started. Defaults to 16 MiB.
+	MaxSegmentSize int64
+	// Maximum size in bytes of all segments on disk. When exceeded, the
+	// oldest segments are dropped, even if they were not read yet.
+	// Zero means no limit.
+	MaxSize int64
+	// Sealed segments whose last write is older than MaxAge are dropped.
+	// Zero means no limit.
+	MaxAge time.Duration
+	// When appended records are synced to disk. Defaults to FsyncInterval.
+	Fsync FsyncPolicy
+	// How often pending writes are synced with FsyncInterval. Defaults to
+	// one second.
+	FsyncInterval time.Duration
+	// OnDrop, if set, is called when unread data is discarded because of the
+	// size or age caps, or because it was corrupted.
+	OnDrop func(segment uint64, bytes int64, reason string)
+	// OnDropRecord, if set, is called with every readable record discarded
+	// because of the size or age caps. The records of a corrupted segment
+	// can't be read and are only reported to OnDrop.
+	OnDropRecord func(record []byte, reason string)
+}
+
+type segment struct {
+	index   uint64
+	size    int64
+	modTime time.Time
+}
+
+// Log is a segmented write-ahead log. It is safe for concurrent use, although
+// Peek and Commit are meant to be called by a single consumer.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu sync.Mutex
+	// segments is sorted by index. The first segment is the one being read,
+	// the last one is the one being written to.
+	segments []*segment
+	writer   *os.File
+	dirty    bool
+
+	reader        *os.File
+	readOffset    int64
+	pendingOffset int64
+
+	cursor *os.File
+	closed bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the log stored in dir, creating the directory if necessary.
+// Records that were not committed before the log was last closed are
+// available to Peek again.
+func Open(dir string, opts Options) (*Log, error) {
+	opts = opts.withDefaults()
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating wal directory: %w", err)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:           dir,
+		opts:          opts,
+		pendingOffset: -1,
+		stop:          make(chan struct{}),
+	}
+
+	l.cursor, err = os.OpenFile(filepath.Join(dir, cursorName), os.O_RDWR|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("opening wal cursor: %w", err)
+	}
+
+	cursorIndex, cursorOffset, err := l.readCursor()
+	if err != nil {
+		_ = l.cursor.Close()
+		return nil, err
+	}
+
+	// Segments before the cursor were fully consumed already.
+	for len(segments) > 0 && segments[0].index < cursorIndex {
+		_ = os.Remove(l.segmentPath(segments[0].index))
+		segments = segments[1:]
+	}
+	if len(segments) > 0 && segments[0].index == cursorIndex && cursorOffset <= segments[0].size {
+		l.readOffset = cursorOffset
+	}
+
+	if len(segments) > 0 {
+		// The last segment might end with a partially written record if the
+		// process crashed in the middle of an append.
+		last := segments[len(segments)-1]
+		if err := repairSegment(l.segmentPath(last.index), last); err != nil {
+			_ = l.cursor.Close()
+			return nil, err
+		}
+		if l.readOffset > segments[0].size {
+			l.readOffset = segments[0].size
+		}
+	}
+
+	// Seal the segments of the previous run and write into a new one, unless
+	// the last segment is empty and can be reused as is.
+	if len(segments) == 0 || segments[len(segments)-1].size > 0 {
+		next := uint64(1)
+		if len(segments) > 0 {
+			next = segments[len(segments)-1].index + 1
+		}
+		segments = append(segments, &segment{index: next, modTime: time.Now()})
+	}
+	l.segments = segments
+
+	active := l.segments[len(l.segments)-1]
+	l.writer, err = os.OpenFile(l.segmentPath(active.index), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		_ = l.cursor.Close()
+		return nil, fmt.Errorf("opening wal segment: %w", err)
+	}
+
+	if err := l.writeCursor(); err != nil {
+		_ = l.writer.Close()
+		_ = l.cursor.Close()
+		return nil, err
+	}
+
+	l.enforceLimits(time.Now())
+	l.startSyncLoop()
+
+	return l, nil
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.MaxSegmentSize <= 0 {
+		opts.MaxSegmentSize = defaultMaxSegmentSize
+	}
+	if opts.FsyncInterval <= 0 {
+		opts.FsyncInterval = defaultFsyncInterval
+	}
+	return opts
+}
+
+// SetOptions replaces the options of an open log. The size and age caps apply
+// immediately, a smaller MaxSegmentSize applies from the next append.
+func (l *Log) SetOptions(opts Options) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	close(l.stop)
+	l.stop = make(chan struct{})
+	l.opts = opts.withDefaults()
+
+	_ = l.syncLocked()
+	l.enforceLimits(time.Now())
+	l.startSyncLoop()
+}
+
+// startSyncLoop starts syncing the log in the background with FsyncInterval.
+// It must be called with l.mu held, or before the log is shared.
+func (l *Log) startSyncLoop() {
+	if l.opts.Fsync == FsyncInterval {
+		l.wg.Add(1)
+		go l.syncLoop(l.opts.FsyncInterval, l.stop)
+	}
+}
+
+// Append writes a record at the end of the log.
+func (l *Log) Append(record []byte) error {
+	if len(record) > int(^uint32(0)) {
+		return fmt.Errorf("wal: record of %d bytes is too large", len(record))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	rotated, err := l.appendLocked(record)
+	if rotated {
+		l.enforceLimits(time.Now())
+	}
+	return err
+}
+
+// Requeue moves the record returned by the last Peek to the end of the log,
+// so that the records after it are read first. The record is written again
+// before it is committed: a crash in between delivers it twice rather than
+// losing it.
+func (l *Log) Requeue(record []byte) error {
+	if len(record) > int(^uint32(0)) {
+		return fmt.Errorf("wal: record of %d bytes is too large", len(record))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	if l.pendingOffset < 0 {
+		return nil
+	}
+
+	rotated, err := l.appendLocked(record)
+	if err == nil {
+		l.readOffset = l.pendingOffset
+		l.pendingOffset = -1
+		err = l.writeCursor()
+	}
+	// The caps apply once the record is committed, it is not reported as
+	// dropped from its previous position.
+	if rotated {
+		l.enforceLimits(time.Now())
+	}
+	return err
+}
+
+// appendLocked writes a record at the end of the log. It reports whether a
+// new segment was started, the caller then enforces the limits. It must be
+// called with l.mu held.
+func (l *Log) appendLocked(record []byte) (bool, error) {
+	rotated := false
+	active := l.segments[len(l.segments)-1]
+	if active.size > 0 && active.size+headerSize+int64(len(record)) > l.opts.MaxSegmentSize {
+		if err := l.rotate(); err != nil {
+			return false, err
+		}
+		active = l.segments[len(l.segments)-1]
+		rotated = true
+	}
+
+	buf := make([]byte, headerSize+len(record))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(record, crcTable))
+	copy(buf[headerSize:], record)
+
+	n, err := l.writer.Write(buf)
+	active.size += int64(n)
+	active.modTime = time.Now()
+	if err != nil {
+		return rotated, fmt.Errorf("writing wal record: %w", err)
+	}
+	l.dirty = true
+
+	if l.opts.Fsync == FsyncAlways {
+		return rotated, l.syncLocked()
+	}
+
+	return rotated, nil
+}
+
+// Peek returns the oldest record that was not committed yet. It returns
+// io.EOF when there is nothing left to read. Calling Peek again without
+// Commit returns the same record.
+func (l *Log) Peek() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, ErrClosed
+	}
+
+	for {
+		current := l.segments[0]
+		active := len(l.segments) == 1
+
+		if l.readOffset+headerSize > current.size {
+			if active {
+				return nil, io.EOF
+			}
+			if err := l.advanceSegment(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		record, err := l.readRecord(current)
+		if errors.Is(err, errCorrupted) && !active {
+			l.drop(current, l.readOffset, "corrupted")
+			if err := l.advanceSegment(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		l.pendingOffset = l.readOffset + headerSize + int64(len(record))
+		return record, nil
+	}
+}
+
+// Commit marks the record returned by the last Peek as consumed.
+func (l *Log) Commit() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	if l.pendingOffset < 0 {
+		return nil
+	}
+
+	l.readOffset = l.pendingOffset
+	l.pendingOffset = -1
+	return l.writeCursor()
+}
+
+// Len returns the number of bytes on disk that were not committed yet.
+func (l *Log) Len() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var total int64
+	for _, s := range l.segments {
+		total += s.size
+	}
+	return total - l.readOffset
+}
+
+// Sync flushes pending writes to stable storage.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	return l.syncLocked()
+}
+
+// Close syncs and closes the log. Uncommitted records remain on disk.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.stop)
+
+	err := l.syncLocked()
+	if e := l.writer.Close(); err == nil {
+		err = e
+	}
+	if l.reader != nil {
+		_ = l.reader.Close()
+	}
+	if e := l.cursor.Close(); err == nil {
+		err = e
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	return err
+}
+
+func (l *Log) syncLoop(interval time.Duration, stop <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.closed {
+				_ = l.syncLocked()
+				l.enforceLimits(time.Now())
+			}
+			l.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (l *Log) syncLocked() error {
+	if !l.dirty {
+		return nil
+	}
+	if err := l.writer.Sync(); err != nil {
+		return fmt.Errorf("syncing wal segment: %w", err)
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.syncLocked(); err != nil {
+		return err
+	}
+	if err := l.writer.Close(); err != nil {
+		return fmt.Errorf("closing wal segment: %w", err)
+	}
+
+	next := &segment{
+		index:   l.segments[len(l.segments)-1].index + 1,
+		modTime: time.Now(),
+	}
+	writer, err := os.OpenFile(l.segmentPath(next.index), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("creating wal segment: %w", err)
+	}
+	l.writer = writer
+	l.segments = append(l.segments, next)
+
+	return nil
+}
+
+// enforceLimits drops the oldest sealed segments while they exceed the size
+// or age caps. The active segment is never dropped.
+func (l *Log) enforceLimits(now time.Time) {
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+
+		var reason string
+		switch {
+		case l.opts.MaxAge > 0 && now.Sub(oldest.modTime) > l.opts.MaxAge:
+			reason = "max_age"
+		case l.opts.MaxSize > 0 && l.totalSize() > l.opts.MaxSize:
+			reason = "max_size"
+		default:
+			return
+		}
+
+		l.drop(oldest, l.readOffset, reason)
+		if err := l.advanceSegment(); err != nil {
+			return
+		}
+	}
+}
+
+// drop reports the unread data of a segment, from offset, before it is
+// removed.
+func (l *Log) drop(s *segment, offset int64, reason string) {
+	bytes := s.size - offset
+	if bytes <= 0 {
+		return
+	}
+
+	if l.opts.OnDrop != nil {
+		l.opts.OnDrop(s.index, bytes, reason)
+	}
+	if l.opts.OnDropRecord != nil && reason != "corrupted" {
+		l.dropRecords(s, offset, reason)
+	}
+}
+
+// dropRecords passes the records of a segment, from offset, to OnDropRecord.
+// It stops at the first record that can't be read.
+func (l *Log) dropRecords(s *segment, offset int64, reason string) {
+	f, err := os.Open(l.segmentPath(s.index))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var header [headerSize]byte
+	for offset+headerSize <= s.size {
+		if _, err := f.ReadAt(header[:], offset); err != nil {
+			return
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if offset+headerSize+length > s.size {
+			return
+		}
+
+		record := make([]byte, length)
+		if _, err := f.ReadAt(record, offset+headerSize); err != nil {
+			return
+		}
+		if crc32.Checksum(record, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return
+		}
+
+		l.opts.OnDropRecord(record, reason)
+		offset += headerSize + length
+	}
+}
+
+func (l *Log) totalSize() int64 {
+	var total int64
+	for _, s := range l.segments {
+		total += s.size
+	}
+	return total
+}
+
+// advanceSegment removes the segment being read and moves the reader to the
+// beginning of the next one.
+func (l *Log) advanceSegment() error {
+	if l.reader != nil {
+		_ = l.reader.Close()
+		l.reader = nil
+	}
+
+	consumed := l.segments[0]
+	l.segments = l.segments[1:]
+	l.readOffset = 0
+	l.pendingOffset = -1
+
+	if err := l.writeCursor(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(l.segmentPath(consumed.index)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing wal segment: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) readRecord(current *segment) ([]byte, error) {
+	if l.reader == nil {
+		reader, err := os.Open(l.segmentPath(current.index))
+		if err != nil {
+			return nil, fmt.Errorf("opening wal segment: %w", err)
+		}
+		l.reader = reader
+	}
+
+	var header [headerSize]byte
+	if _, err := l.reader.ReadAt(header[:], l.readOffset); err != nil {
+		return nil, fmt.Errorf("reading wal record header: %w", err)
+	}
+
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if l.readOffset+headerSize+length > current.size {
+		return nil, errCorrupted
+	}
+
+	record := make([]byte, length)
+	if _, err := l.reader.ReadAt(record, l.readOffset+headerSize); err != nil {
+		return nil, fmt.Errorf("reading wal record: %w", err)
+	}
+
+	if crc32.Checksum(record, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errCorrupted
+	}
+
+	return record, nil
+}
+
+func (l *Log) readCursor() (uint64, int64, error) {
+	var buf [cursorSize]byte
+	n, err := l.cursor.ReadAt(buf[:], 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, fmt.Errorf("reading wal cursor: %w", err)
+	}
+	if n < cursorSize {
+		return 0, 0, nil
+	}
+
+	return binary.BigEndian.Uint64(buf[0:8]), int64(binary.BigEndian.Uint64(buf[8:16])), nil
+}
+
+func (l *Log) writeCursor() error {
+	var buf [cursorSize]byte
+	binary.BigEndian.PutUint64(buf[0:8], l.segments[0].index)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(l.readOffset))
+
+	if _, err := l.cursor.WriteAt(buf[:], 0); err != nil {
+		return fmt.Errorf("writing wal cursor: %w", err)
+	}
+
+	if l.opts.Fsync == FsyncAlways {
+		if err := l.cursor.Sync(); err != nil {
+			return fmt.Errorf("syncing wal cursor: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (l *Log) segmentPath(index uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", index, segmentSuffix))
+}
+
+func listSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading wal directory: %w", err)
+	}
+
+	var segments []*segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("reading wal segment: %w", err)
+		}
+
+		segments = append(segments, &segment{
+			index:   index,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].index < segments[j].index
+	})
+
+	return segments, nil
+}
+
+// repairSegment truncates the segment after its last complete record.
+func repairSegment(path string, s *segment) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0o640)
+	if err != nil {
+		return fmt.Errorf("opening wal segment: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	var valid int64
+	for {
+		var header [headerSize]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			break
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if valid+headerSize+length > s.size {
+			break
+		}
+
+		record := make([]byte, length)
+		if _, err := io.ReadFull(reader, record); err != nil {
+			break
+		}
+		if crc32.Checksum(record, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+
+		valid += headerSize + length
+	}
+
+	if valid == s.size {
+		return nil
+	}
+
+	if err := file.Truncate(valid); err != nil {
+		return fmt.Errorf("truncating wal segment: %w", err)
+	}
+	s.size = valid
+	return nil
+}
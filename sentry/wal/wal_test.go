@@ -0,0 +1,309 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mustOpen(t *testing.T, dir string, opts Options) *Log {
+	t.Helper()
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func mustPeek(t *testing.T, l *Log, want string) {
+	t.Helper()
+	got, err := l.Peek()
+	if err != nil {
+		t.Fatalf("peek: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("peek: got %q, want %q", got, want)
+	}
+}
+
+func TestAppendPeekCommit(t *testing.T) {
+	l := mustOpen(t, t.TempDir(), Options{})
+	defer l.Close()
+
+	if _, err := l.Peek(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF on empty log, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := l.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustPeek(t, l, "record-0")
+	// Peek without Commit returns the same record.
+	mustPeek(t, l, "record-0")
+
+	for i := 0; i < 3; i++ {
+		mustPeek(t, l, fmt.Sprintf("record-%d", i))
+		if err := l.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := l.Peek(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after consuming everything, got %v", err)
+	}
+	if n := l.Len(); n != 0 {
+		t.Fatalf("expected no pending bytes, got %d", n)
+	}
+}
+
+func TestReplayAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	l := mustOpen(t, dir, Options{Fsync: FsyncAlways})
+	for i := 0; i < 3; i++ {
+		if err := l.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustPeek(t, l, "record-0")
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = mustOpen(t, dir, Options{})
+	defer l.Close()
+
+	for i := 1; i < 3; i++ {
+		mustPeek(t, l, fmt.Sprintf("record-%d", i))
+		if err := l.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Peek(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	dir := t.TempDir()
+
+	l := mustOpen(t, dir, Options{})
+	for i := 0; i < 2; i++ {
+		if err := l.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record, err := l.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Requeue(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The requeued record is read after the others, also once reopened.
+	l = mustOpen(t, dir, Options{})
+	defer l.Close()
+
+	for _, want := range []string{"record-1", "record-0"} {
+		mustPeek(t, l, want)
+		if err := l.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Peek(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	l := mustOpen(t, dir, Options{MaxSegmentSize: 32})
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := l.Append([]byte(fmt.Sprintf("record-%d-padding", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 5 {
+		t.Fatalf("expected 5 segments, got %d", len(segments))
+	}
+
+	for i := 0; i < 5; i++ {
+		mustPeek(t, l, fmt.Sprintf("record-%d-padding", i))
+		if err := l.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Consumed segments are removed once the reader moves past them.
+	if _, err := l.Peek(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) != 1 {
+		t.Fatalf("expected only the active segment to remain, got %d", len(segments))
+	}
+}
+
+func TestMaxSizeDropsOldestSegments(t *testing.T) {
+	var dropped int64
+	var records []string
+	l := mustOpen(t, t.TempDir(), Options{
+		MaxSegmentSize: 32,
+		MaxSize:        64,
+		OnDrop: func(_ uint64, bytes int64, reason string) {
+			if reason != "max_size" {
+				t.Errorf("unexpected drop reason %q", reason)
+			}
+			dropped += bytes
+		},
+		OnDropRecord: func(record []byte, _ string) {
+			records = append(records, string(record))
+		},
+	})
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := l.Append([]byte(fmt.Sprintf("record-%d-padding", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dropped == 0 {
+		t.Fatal("expected some records to be dropped")
+	}
+	if n := l.Len(); n > 64 {
+		t.Fatalf("expected at most 64 pending bytes, got %d", n)
+	}
+	if len(records) != 3 || records[0] != "record-0-padding" {
+		t.Fatalf("expected the dropped records to be reported, got %q", records)
+	}
+	mustPeek(t, l, "record-3-padding")
+}
+
+func TestMaxAgeDropsSealedSegments(t *testing.T) {
+	dir := t.TempDir()
+	l := mustOpen(t, dir, Options{})
+	if err := l.Append([]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	for _, s := range segments {
+		if err := os.Chtimes(s, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l = mustOpen(t, dir, Options{MaxAge: time.Minute})
+	defer l.Close()
+
+	if _, err := l.Peek(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected expired records to be dropped, got %v", err)
+	}
+}
+
+func TestSetOptionsAppliesLimits(t *testing.T) {
+	l := mustOpen(t, t.TempDir(), Options{MaxSegmentSize: 32})
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := l.Append([]byte(fmt.Sprintf("record-%d-padding", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reasons []string
+	l.SetOptions(Options{
+		MaxSegmentSize: 32,
+		MaxSize:        64,
+		Fsync:          FsyncAlways,
+		OnDrop: func(_ uint64, _ int64, reason string) {
+			reasons = append(reasons, reason)
+		},
+	})
+
+	if len(reasons) == 0 || reasons[0] != "max_size" {
+		t.Fatalf("expected the new size cap to drop segments, got %q", reasons)
+	}
+	mustPeek(t, l, "record-3-padding")
+}
+
+func TestTornWriteIsRepaired(t *testing.T) {
+	dir := t.TempDir()
+	l := mustOpen(t, dir, Options{})
+	if err := l.Append([]byte("complete")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A header announcing more bytes than were written.
+	if _, err := f.Write([]byte{0, 0, 1, 0, 1, 2, 3, 4, 'x'}); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	l = mustOpen(t, dir, Options{})
+	defer l.Close()
+
+	mustPeek(t, l, "complete")
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Peek(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after the torn record, got %v", err)
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	tests := map[string]FsyncPolicy{
+		"":         FsyncInterval,
+		"interval": FsyncInterval,
+		"Always":   FsyncAlways,
+		"never":    FsyncNever,
+	}
+	for input, want := range tests {
+		got, err := ParseFsyncPolicy(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+		}
+		if got != want {
+			t.Errorf("%q: got %v, want %v", input, got, want)
+		}
+	}
+
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
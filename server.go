@@ -1,95 +1,221 @@
 package promsentry
 
 import (
-	"bytes"
 	"crypto/tls"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/aldy505/promsentry/forward"
+	"github.com/aldy505/promsentry/pipeline"
 	"github.com/aldy505/promsentry/sentry"
-	"github.com/aldy505/promsentry/statsd"
-	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/aldy505/promsentry/telemetry"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func NewServer(listenAddress string, tlsConfig *tls.Config) (*http.Server, error) {
+// ServerOptions configures the server created by NewServer. Every field is optional.
+type ServerOptions struct {
+	// ListenAddress is the address the server listens on. Defaults to 127.0.0.1:3000.
+	ListenAddress string
+	// TLSConfig enables TLS when set. The server must then be started with ListenAndServeTLS("", "").
+	TLSConfig *tls.Config
+	// Hub captures the converted metrics when Sink is nil. Defaults to the hub of the request context, or the
+	// current hub.
+	Hub *sentry.Hub
+	// Sink receives the converted metrics. Defaults to a pipeline.SentrySink for Hub.
+	Sink pipeline.Sink
+	// Conversion configures the conversion of the time series.
+	Conversion pipeline.Options
+	// Converter converts the time series. Defaults to a Converter of Sink, recorded by Recorder, with Conversion. Set
+	// it to replace the conversion options while the server runs, Sink, Conversion and Recorder are then unused.
+	Converter *pipeline.Converter
+	// Limits bounds the resources used by the server.
+	Limits ServerLimits
+	// Limiter enforces the limits of the remote write requests. Defaults to a RequestLimiter with Limits. Set it to
+	// replace the limits while the server runs, the timeouts of Limits can't be replaced.
+	Limiter *RequestLimiter
+	// Forwarders receive a copy of every remote write request, before it is converted.
+	Forwarders []*forward.Forwarder
+	// Middleware wraps the remote write handler. The first middleware is the outermost one.
+	Middleware []func(http.Handler) http.Handler
+	// Health backs the /-/healthy and /-/ready endpoints. Defaults to a Health that checks the transport of Hub.
+	Health *Health
+	// Recorder, when set, records the converted metrics before they are written to Sink. The admin server shows its
+	// statistics on the /status page.
+	Recorder *pipeline.Recorder
+	// Logger receives the logs of the server, and of the converter unless Conversion has its own logger. Defaults
+	// to slog.Default().
+	Logger *slog.Logger
+}
+
+// ServerLimits bounds the resources used by the server. Zero values select the defaults, negative values disable the
+// limit.
+type ServerLimits struct {
+	// MaxRequestBytes is the maximum size of a compressed remote write request body. Defaults to 16 MiB.
+	MaxRequestBytes int64
+	// MaxDecodedBytes is the maximum size of a remote write request body once decompressed. Defaults to 64 MiB.
+	MaxDecodedBytes int64
+	// MaxSeriesPerRequest is the maximum number of time series in a remote write request. Not limited by default.
+	MaxSeriesPerRequest int
+	// MaxSamplesPerRequest is the maximum number of samples, exemplars and histograms in a remote write request. Not
+	// limited by default.
+	MaxSamplesPerRequest int
+	// RateLimit is the number of remote write requests per second accepted from each client. Clients are identified
+	// by their authenticated identity, or by their IP address. Not limited by default.
+	RateLimit float64
+	// RateLimitBurst is the number of requests a client can send at once. Defaults to RateLimit, rounded up.
+	RateLimitBurst int
+	// ReadHeaderTimeout is the maximum duration for reading the headers of a request. Defaults to 10 seconds.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is the maximum duration for reading a request. Defaults to one minute.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of the response. Defaults to one minute.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum amount of time to wait for the next request. Defaults to one minute.
+	IdleTimeout time.Duration
+}
+
+// NewServer creates the HTTP server that receives Prometheus remote write requests.
+func NewServer(options ServerOptions) (*http.Server, error) {
+	listenAddress := options.ListenAddress
 	if listenAddress == "" {
 		listenAddress = "127.0.0.1:3000"
 	}
 
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	converter := options.Converter
+	if converter == nil {
+		sink := options.Sink
+		if sink == nil {
+			sink = pipeline.NewSentrySink(options.Hub)
+		}
+		if options.Recorder != nil {
+			sink = options.Recorder.Wrap(sink)
+		}
+		conversion := options.Conversion
+		if conversion.Logger == nil {
+			conversion.Logger = logger.With("component", "converter")
+		}
+		if conversion.Metrics == nil {
+			conversion.Metrics = telemetry.Pipeline{}
+		}
+		converter = pipeline.NewConverter(sink, conversion)
+	}
+	logger = logger.With("component", "server")
+
 	router := http.NewServeMux()
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Alive"))
 	})
-	router.HandleFunc("/api/v1/write", func(w http.ResponseWriter, r *http.Request) {
-		req, err := remote.DecodeWriteRequest(r.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	health := options.Health
+	if health == nil {
+		health = NewHealth(HealthOptions{Hub: options.Hub})
+	}
+	health.registerProbes(router)
 
-		b := &bytes.Buffer{}
-		client := statsd.NewClient(b)
-		hub := sentry.CurrentHub()
+	limits := options.Limits
+	requestLimiter := options.Limiter
+	if requestLimiter == nil {
+		requestLimiter = NewRequestLimiter(limits)
+	}
 
-		for _, timeseries := range req.GetTimeseries() {
-			var name string
-			var tags = make(map[string]string)
-			for _, l := range timeseries.GetLabels() {
-				if l.GetName() == "__name__" {
-					name = l.GetValue()
-					continue
-				}
+	var writeHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reject := func(err *limitError) {
+			logger.WarnContext(r.Context(), "Remote write request rejected", "reason", err.reason, "error", err.message)
+			writeLimitError(w, err)
+		}
 
-				tags[l.GetName()] = l.GetValue()
-			}
+		current := requestLimiter.current()
+		maxRequestBytes := withDefault(current.limits.MaxRequestBytes, defaultMaxRequestBytes)
+		maxDecodedBytes := withDefault(current.limits.MaxDecodedBytes, defaultMaxDecodedBytes)
 
-			for _, s := range timeseries.GetSamples() {
-				err := client.Gauge(name, int64(s.GetValue()), tags)
-				if err != nil {
-					log.Println(err)
-				}
+		if current.limiter != nil {
+			if err := current.limiter.allow(r); err != nil {
+				reject(err)
+				return
 			}
+		}
+
+		if maxRequestBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		}
 
-			for _, e := range timeseries.GetExemplars() {
-				err := client.Duration(name, time.Duration(e.GetValue()), tags)
-				if err != nil {
-					log.Println(err)
-				}
-				for _, l := range e.GetLabels() {
-					tags[l.GetName()] = l.GetValue()
-				}
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				reject(&limitError{
+					status:  http.StatusRequestEntityTooLarge,
+					reason:  "body_too_large",
+					message: fmt.Sprintf("request body exceeds the limit of %d bytes", maxBytesError.Limit),
+				})
+				return
 			}
 
-			for _, hp := range timeseries.GetHistograms() {
-				h := remote.HistogramProtoToHistogram(hp)
-				err := client.Histogram(name, h.Count, tags)
-				if err != nil {
-					log.Println(err)
-				}
+			logger.ErrorContext(r.Context(), "Unable to read the remote write request", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		req, err := decodeWriteRequest(compressed, maxDecodedBytes)
+		if err == nil {
+			err = checkWriteRequest(req, current.limits)
+		}
+		if err != nil {
+			var limitErr *limitError
+			if errors.As(err, &limitErr) {
+				reject(limitErr)
+				return
 			}
+
+			logger.WarnContext(r.Context(), "Unable to decode the remote write request", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, forwarder := range options.Forwarders {
+			forwarder.Forward(compressed, req)
 		}
 
-		if err := client.Flush(); err != nil {
-			log.Println(err)
+		if err := converter.Write(r.Context(), req); err != nil {
+			logger.ErrorContext(r.Context(), "Unable to write the converted metrics", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		metric := b.Bytes()
-		hub.CaptureMetric(metric)
+		logger.DebugContext(r.Context(), "Remote write request handled", "series", len(req.GetTimeseries()))
 
 		w.WriteHeader(200)
 	})
+	for i := len(options.Middleware) - 1; i >= 0; i-- {
+		writeHandler = options.Middleware[i](writeHandler)
+	}
+	router.Handle("/api/v1/write", instrumentWriteHandler(requestIDMiddleware(writeHandler)))
 
 	server := &http.Server{
 		Addr:              listenAddress,
 		Handler:           router,
-		TLSConfig:         tlsConfig,
-		ReadTimeout:       0,
-		ReadHeaderTimeout: 0,
-		WriteTimeout:      time.Minute,
-		IdleTimeout:       time.Minute,
+		TLSConfig:         options.TLSConfig,
+		ReadTimeout:       withDefault(limits.ReadTimeout, defaultReadTimeout),
+		ReadHeaderTimeout: withDefault(limits.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      withDefault(limits.WriteTimeout, time.Minute),
+		IdleTimeout:       withDefault(limits.IdleTimeout, time.Minute),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
 
 	return server, nil
 }
+
+// instrumentWriteHandler records the number and duration of remote write requests.
+func instrumentWriteHandler(handler http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(telemetry.WriteRequests,
+		promhttp.InstrumentHandlerDuration(telemetry.WriteRequestDuration, handler))
+}
@@ -0,0 +1,78 @@
+package promsentry
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/sentry"
+	"github.com/aldy505/promsentry/telemetry"
+)
+
+// defaultFileSinkMaxSize is the size after which the file sink is rotated when max_size isn't configured.
+const defaultFileSinkMaxSize = 100 * 1024 * 1024
+
+// CreateSink creates the sinks of the configuration. The Sentry sink captures the metrics with the hub, it is used
+// unless it is disabled, or when no DSN is configured, dry run is off and other sinks are. In dry run, the file and
+// statsd sinks are not created, nothing leaves promsentry but the standard output. The returned Fanout must be closed
+// once the server is shut down.
+func CreateSink(configuration *Configuration, hub *sentry.Hub) (*pipeline.Fanout, error) {
+	sinks := configuration.Sinks
+	fanout := pipeline.NewFanout()
+	fanout.Metrics = telemetry.Pipeline{}
+
+	if sinks.Stdout.Enabled {
+		format, err := pipeline.ParseFormat(sinks.Stdout.Format)
+		if err != nil {
+			return nil, err
+		}
+		fanout.Add("stdout", pipeline.NewWriterSink(os.Stdout, format))
+	}
+
+	if configuration.DryRun {
+		if sinks.File.Path != "" || sinks.Statsd.Address != "" {
+			slog.Default().Warn("Dry run, the file and statsd sinks are skipped")
+		}
+		sinks.File.Path, sinks.Statsd.Address = "", ""
+	}
+
+	if sinks.File.Path != "" {
+		format, err := pipeline.ParseFormat(sinks.File.Format)
+		if err != nil {
+			_ = fanout.Close()
+			return nil, err
+		}
+
+		maxSize := sinks.File.MaxSize
+		if maxSize == 0 {
+			maxSize = defaultFileSinkMaxSize
+		}
+
+		sink, err := pipeline.NewFileSink(sinks.File.Path, pipeline.FileSinkOptions{
+			Format:     format,
+			MaxSize:    maxSize,
+			MaxBackups: sinks.File.MaxBackups,
+		})
+		if err != nil {
+			_ = fanout.Close()
+			return nil, err
+		}
+		fanout.Add("file", sink)
+	}
+
+	if sinks.Statsd.Address != "" {
+		sink, err := pipeline.NewUDPSink(sinks.Statsd.Address, sinks.Statsd.MaxPacketSize)
+		if err != nil {
+			_ = fanout.Close()
+			return nil, err
+		}
+		sink.Metrics = telemetry.Pipeline{}
+		fanout.Add("statsd", sink)
+	}
+
+	if !sinks.Sentry.Disabled && (configuration.SentryDsn != "" || configuration.DryRun || fanout.Len() == 0) {
+		fanout.Add("sentry", pipeline.NewSentrySink(hub))
+	}
+
+	return fanout, nil
+}
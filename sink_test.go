@@ -0,0 +1,75 @@
+package promsentry
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/aldy505/promsentry/sentry"
+)
+
+func TestCreateSink(t *testing.T) {
+	hub := sentry.NewHub(nil, sentry.NewScope())
+
+	tests := []struct {
+		name      string
+		configure func(configuration *Configuration)
+		want      int
+	}{
+		{
+			name:      "sentry by default",
+			configure: func(configuration *Configuration) {},
+			want:      1,
+		},
+		{
+			name: "no sentry without dsn",
+			configure: func(configuration *Configuration) {
+				configuration.Sinks.Stdout.Enabled = true
+			},
+			want: 1,
+		},
+		{
+			name: "sentry and file",
+			configure: func(configuration *Configuration) {
+				configuration.SentryDsn = "http://whatever@example.com/1337"
+				configuration.Sinks.File.Path = filepath.Join(t.TempDir(), "metrics.jsonl")
+				configuration.Sinks.File.Format = "jsonl"
+			},
+			want: 2,
+		},
+		{
+			name: "sentry disabled",
+			configure: func(configuration *Configuration) {
+				configuration.SentryDsn = "http://whatever@example.com/1337"
+				configuration.Sinks.Sentry.Disabled = true
+			},
+			want: 0,
+		},
+		{
+			name: "dry run skips file and statsd",
+			configure: func(configuration *Configuration) {
+				configuration.DryRun = true
+				configuration.Sinks.Stdout.Enabled = true
+				configuration.Sinks.File.Path = filepath.Join(t.TempDir(), "metrics.jsonl")
+				configuration.Sinks.Statsd.Address = "127.0.0.1:8125"
+			},
+			want: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := &Configuration{}
+			test.configure(configuration)
+
+			sink, err := CreateSink(configuration, hub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+
+			if sink.Len() != test.want {
+				t.Errorf("expected %d sinks, got %d", test.want, sink.Len())
+			}
+		})
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -40,9 +41,20 @@ type Client struct {
 	buf     *bufio.Writer
 	m       sync.Mutex
 	prefix  string
+	hooks   Hooks
 	flushed atomic.Bool
 }
 
+// Hooks are called by the client for every line it emits or drops, for
+// instance to count them. Every hook is optional.
+type Hooks struct {
+	// Emitted is called for every line written.
+	Emitted func()
+	// Dropped is called for every line that was not written, with the reason:
+	// sampled or write_error.
+	Dropped func(reason string)
+}
+
 func millisecond(d time.Duration) int {
 	return int(d.Seconds() * 1000)
 }
@@ -54,17 +66,21 @@ func parsetags(tags map[string]string) string {
 		return ""
 	}
 
+	// Tags are sorted, so that the same tags always produce the same line.
+	keys := make([]string, 0, length)
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	b.WriteString("#")
-	i := 0
-	for key, value := range tags {
+	for i, key := range keys {
 		b.WriteString(key)
 		b.WriteString(":")
-		b.WriteString(value)
+		b.WriteString(tags[key])
 		if i != length-1 {
 			b.WriteString(",")
 		}
-
-		i++
 	}
 
 	return b.String()
@@ -85,6 +101,11 @@ func (c *Client) Prefix(s string) {
 	c.prefix = s
 }
 
+// SetHooks sets the hooks called for every line.
+func (c *Client) SetHooks(hooks Hooks) {
+	c.hooks = hooks
+}
+
 // Increment increments the counter for the given bucket.
 func (c *Client) Increment(name string, count int, rate float64, tags map[string]string) error {
 	return c.send(name, rate, "%d|c|%s|T%d", count, parsetags(tags), time.Now().Unix())
@@ -160,6 +181,7 @@ func (c *Client) send(stat string, rate float64, format string, args ...interfac
 		if rand.Float64() < rate {
 			format = fmt.Sprintf("%s|@%g", format, rate)
 		} else {
+			c.dropped("sampled")
 			return nil
 		}
 	}
@@ -188,5 +210,19 @@ func (c *Client) send(stat string, rate float64, format string, args ...interfac
 	}
 
 	_, err := fmt.Fprintf(c.buf, format, args...)
-	return err
+	if err != nil {
+		c.dropped("write_error")
+		return err
+	}
+
+	if c.hooks.Emitted != nil {
+		c.hooks.Emitted()
+	}
+	return nil
+}
+
+func (c *Client) dropped(reason string) {
+	if c.hooks.Dropped != nil {
+		c.hooks.Dropped(reason)
+	}
 }
@@ -50,6 +50,27 @@ func TestPrefix(t *testing.T) {
 	assert(t, buf.String(), "foo.bar.baz.incr:1|c||T"+strconv.FormatInt(time.Now().Unix(), 10))
 }
 
+func TestHooks(t *testing.T) {
+	var emitted int
+	dropped := make(map[string]int)
+	c := NewClient(new(bytes.Buffer))
+	c.SetHooks(Hooks{
+		Emitted: func() { emitted++ },
+		Dropped: func(reason string) { dropped[reason]++ },
+	})
+
+	if err := c.Incr("incr", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Increment("sampled", 1, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if emitted != 1 || dropped["sampled"] != 1 {
+		t.Errorf("expected one emitted and one sampled line, got %d emitted and %v dropped", emitted, dropped)
+	}
+}
+
 func TestIncr(t *testing.T) {
 	buf := new(bytes.Buffer)
 	c := NewClient(buf)
@@ -133,7 +154,7 @@ func TestMultiPacket(t *testing.T) {
 		t.Fatal(err)
 	}
 	c.Flush()
-	assert(t, buf.String(), fmt.Sprintf("unique:765|s|#foo:bar,baz:foo|T%d\nunique:765|s||T%d", time.Now().Unix(), time.Now().Unix()))
+	assert(t, buf.String(), fmt.Sprintf("unique:765|s|#baz:foo,foo:bar|T%d\nunique:765|s||T%d", time.Now().Unix(), time.Now().Unix()))
 }
 
 func TestMultiPacketOverflow(t *testing.T) {
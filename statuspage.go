@@ -0,0 +1,53 @@
+package promsentry
+
+import (
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/telemetry"
+)
+
+const (
+	// statusPageTop is the number of metrics and tag keys shown on the status page.
+	statusPageTop = 20
+	// statusPageRefresh is the number of seconds between two refreshes of the status page.
+	statusPageRefresh = 5
+)
+
+//go:embed ui
+var ui embed.FS
+
+var statusPageTemplate = template.Must(template.ParseFS(ui, "ui/status.html"))
+
+// statusPageData is rendered by the status page template.
+type statusPageData struct {
+	Refresh  int
+	Status   Status
+	Recorder bool
+	Pipeline pipeline.RecorderStats
+	Drops    []telemetry.Drop
+}
+
+// statusPageHandler serves the HTML status page, with the readiness and the transport state of health, the recent
+// drops, and the statistics of the recorder when it is not nil.
+func statusPageHandler(health *Health, recorder *pipeline.Recorder, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := statusPageData{
+			Refresh:  statusPageRefresh,
+			Status:   health.Status(),
+			Recorder: recorder != nil,
+			Drops:    telemetry.Drops.Recent(),
+		}
+		if recorder != nil {
+			data.Pipeline = recorder.Stats(statusPageTop)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusPageTemplate.Execute(w, data); err != nil {
+			logger.ErrorContext(r.Context(), "Unable to render the status page", "error", err)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package promsentry
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/aldy505/promsentry/sentry"
+)
+
+func TestStatusPage(t *testing.T) {
+	recorder := pipeline.NewRecorder(pipeline.RecorderOptions{})
+	health := NewHealth(HealthOptions{Hub: sentry.NewHub(nil, sentry.NewScope())})
+	server, err := NewServer(ServerOptions{
+		Sink:     discardSink{},
+		Health:   health,
+		Recorder: recorder,
+		Limits:   ServerLimits{MaxSeriesPerRequest: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminServer, err := NewAdminServer(AdminServerOptions{Health: health, Recorder: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, series := range []int{2, 3} {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(limitsTestRequest(t, series)))
+		server.Handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	response := httptest.NewRecorder()
+	adminServer.Handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.Code)
+	}
+
+	body := response.Body.String()
+	for _, want := range []string{
+		// The top metric and tag key, and the tail.
+		"<td>up</td><td class=\"number\">4</td>",
+		"<td>instance</td><td class=\"number\">2</td>",
+		"up:1|g|#instance:aa",
+		// The rejected request.
+		"<td>too_many_series</td>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the status page, got:\n%s", want, body)
+		}
+	}
+}
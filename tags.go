@@ -0,0 +1,77 @@
+package promsentry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aldy505/promsentry/pipeline"
+)
+
+// NewConversionOptions returns the conversion options of the configuration: the environment, the release and the
+// static tags added to every metric, the labels renamed into tags, and the routes that override them. Routes that
+// match on an identity apply to the clients authenticated with it.
+func NewConversionOptions(configuration *Configuration) pipeline.Options {
+	options := pipeline.Options{
+		Tags: pipeline.Tags{
+			Environment: configuration.Environment,
+			Release:     configuration.Release,
+			Static:      configuration.Tags.Static,
+		},
+		LabelTags: configuration.Tags.FromLabels,
+		Identity: func(ctx context.Context) string {
+			identity, _ := IdentityFromContext(ctx)
+			return identity.Name
+		},
+	}
+
+	for _, route := range configuration.Tags.Routes {
+		options.Routes = append(options.Routes, pipeline.Route{
+			Identity: route.Identity,
+			Labels:   route.Labels,
+			Tags: pipeline.Tags{
+				Environment: route.Environment,
+				Release:     route.Release,
+				Static:      route.Static,
+			},
+		})
+	}
+
+	return options
+}
+
+// validateTags checks that the tags can be written on statsd lines.
+func validateTags(configuration *Configuration) error {
+	if err := validateTagNames(configuration.Tags.Static); err != nil {
+		return fmt.Errorf("static: %w", err)
+	}
+
+	for label, tag := range configuration.Tags.FromLabels {
+		if label == "" {
+			return fmt.Errorf("from_labels: empty label name")
+		}
+		if strings.ContainsAny(tag, ":,|#") {
+			return fmt.Errorf("from_labels: invalid tag name %q", tag)
+		}
+	}
+
+	for i, route := range configuration.Tags.Routes {
+		if route.Identity == "" && len(route.Labels) == 0 {
+			return fmt.Errorf("route #%d: identity or labels is required", i+1)
+		}
+		if err := validateTagNames(route.Static); err != nil {
+			return fmt.Errorf("route #%d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func validateTagNames(tags map[string]string) error {
+	for name := range tags {
+		if name == "" || strings.ContainsAny(name, ":,|#") {
+			return fmt.Errorf("invalid tag name %q", name)
+		}
+	}
+	return nil
+}
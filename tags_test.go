@@ -0,0 +1,108 @@
+package promsentry
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aldy505/promsentry/pipeline"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// payloadSink keeps the last payload written to it.
+type payloadSink struct {
+	metric string
+}
+
+func (s *payloadSink) Write(_ context.Context, metric []byte) error {
+	s.metric = string(metric)
+	return nil
+}
+
+func TestNewConversionOptionsRoutesByIdentity(t *testing.T) {
+	configuration := &Configuration{Environment: "production", Release: "1.0.0"}
+	configuration.Tags.Static = map[string]string{"region": "eu"}
+	configuration.Tags.Routes = []TagRoute{{Identity: "staging", Environment: "staging"}}
+
+	sink := &payloadSink{}
+	converter := pipeline.NewConverter(sink, NewConversionOptions(configuration))
+	timeseries := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1}},
+	}}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{
+			name: "anonymous",
+			ctx:  context.Background(),
+			want: "up:1|g|#environment:production,region:eu,release:1.0.0|",
+		},
+		{
+			name: "other identity",
+			ctx:  ContextWithIdentity(context.Background(), Identity{Name: "production", Method: "bearer_token"}),
+			want: "up:1|g|#environment:production,region:eu,release:1.0.0|",
+		},
+		{
+			name: "matching identity",
+			ctx:  ContextWithIdentity(context.Background(), Identity{Name: "staging", Method: "bearer_token"}),
+			want: "up:1|g|#environment:staging,region:eu,release:1.0.0|",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := converter.WriteSeries(test.ctx, timeseries); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(sink.metric, test.want) {
+				t.Errorf("got %q, want a line starting with %q", sink.metric, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(configuration *Configuration)
+		message   string
+	}{
+		{
+			name: "static tag name",
+			configure: func(configuration *Configuration) {
+				configuration.Tags.Static = map[string]string{"a:b": "c"}
+			},
+			message: "invalid tag name",
+		},
+		{
+			name: "renamed label",
+			configure: func(configuration *Configuration) {
+				configuration.Tags.FromLabels = map[string]string{"env": "env|name"}
+			},
+			message: "invalid tag name",
+		},
+		{
+			name: "route without match",
+			configure: func(configuration *Configuration) {
+				configuration.Tags.Routes = []TagRoute{{Environment: "staging"}}
+			},
+			message: "identity or labels is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := &Configuration{}
+			test.configure(configuration)
+
+			err := configuration.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"sync"
+	"time"
+)
+
+// Drop counts the data promsentry dropped for the same reason, in a row.
+type Drop struct {
+	// Component is the part of promsentry that dropped the data: server,
+	// converter, statsd, transport or forward.
+	Component string `json:"component"`
+	// Reason is the reason of the drop, as reported by the metrics.
+	Reason string `json:"reason"`
+	// Count is the number of drops between First and Last.
+	Count int       `json:"count"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// DropLog keeps the most recent drops, for the status page. Consecutive drops
+// of the same component and reason are merged.
+type DropLog struct {
+	mu    sync.Mutex
+	size  int
+	drops []Drop
+}
+
+// Drops is the log every drop is recorded in.
+var Drops = NewDropLog(50)
+
+// NewDropLog creates a DropLog that keeps the given number of drops.
+func NewDropLog(size int) *DropLog {
+	return &DropLog{size: size}
+}
+
+// Record records that the component dropped count items for the reason.
+func (l *DropLog) Record(component string, reason string, count int) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n := len(l.drops); n > 0 && l.drops[n-1].Component == component && l.drops[n-1].Reason == reason {
+		l.drops[n-1].Count += count
+		l.drops[n-1].Last = now
+		return
+	}
+
+	if len(l.drops) == l.size {
+		copy(l.drops, l.drops[1:])
+		l.drops = l.drops[:len(l.drops)-1]
+	}
+	l.drops = append(l.drops, Drop{
+		Component: component,
+		Reason:    reason,
+		Count:     count,
+		First:     now,
+		Last:      now,
+	})
+}
+
+// Recent returns the drops, the most recent first.
+func (l *DropLog) Recent() []Drop {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := make([]Drop, len(l.drops))
+	for i, drop := range l.drops {
+		recent[len(l.drops)-1-i] = drop
+	}
+	return recent
+}
@@ -0,0 +1,45 @@
+package telemetry
+
+// Pipeline records the activity of the conversion and of the sinks in the
+// pipeline metrics. It is the Metrics of the pipeline options and sinks.
+type Pipeline struct{}
+
+// SeriesReceived accounts for the time series of a conversion.
+func (Pipeline) SeriesReceived(n int) {
+	SeriesReceived.Add(float64(n))
+}
+
+// SeriesConverted accounts for a time series that produced a statsd line.
+func (Pipeline) SeriesConverted() {
+	SeriesConverted.Inc()
+}
+
+// SampleReceived accounts for a sample, an exemplar or a histogram.
+func (Pipeline) SampleReceived(kind string) {
+	SamplesReceived.WithLabelValues(kind).Inc()
+}
+
+// LineEmitted accounts for a statsd line written by the conversion.
+func (Pipeline) LineEmitted() {
+	LinesEmitted.Inc()
+}
+
+// LineDropped accounts for a statsd line that the conversion didn't write.
+func (Pipeline) LineDropped(reason string) {
+	LinesDropped.WithLabelValues(reason).Inc()
+	Drops.Record("statsd", reason, 1)
+}
+
+// SinkWritten accounts for a write to a sink.
+func (Pipeline) SinkWritten(sink string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	SinkWrites.WithLabelValues(sink, result).Inc()
+}
+
+// SinkLineDropped accounts for a line that a sink skipped.
+func (Pipeline) SinkLineDropped(sink string, reason string) {
+	Drops.Record("sink", sink+"_"+reason, 1)
+}
@@ -0,0 +1,51 @@
+package telemetry
+
+import (
+	"strconv"
+	"time"
+)
+
+// SentryTransport records the activity of the Sentry transports in the
+// transport metrics. It is the TransportMetrics of the Sentry client options.
+type SentryTransport struct{}
+
+// EnvelopeSent accounts for an envelope that reached Sentry.
+func (SentryTransport) EnvelopeSent(transport string, bytes int64, status int) {
+	EnvelopesSent.WithLabelValues(transport).Inc()
+	if bytes > 0 {
+		BytesSent.WithLabelValues(transport).Add(float64(bytes))
+	}
+	SentryResponses.WithLabelValues(transport, strconv.Itoa(status)).Inc()
+}
+
+// SendError accounts for a request that failed without a response.
+func (SentryTransport) SendError(transport string) {
+	SendErrors.WithLabelValues(transport).Inc()
+}
+
+// EnvelopeDropped accounts for an envelope that will never be delivered.
+func (SentryTransport) EnvelopeDropped(transport string, reason string) {
+	EnvelopesDropped.WithLabelValues(transport, reason).Inc()
+	Drops.Record("transport", reason, 1)
+}
+
+// RateLimited accounts for the time Sentry asked us to back off for.
+func (SentryTransport) RateLimited(category string, duration time.Duration) {
+	RateLimitedSeconds.WithLabelValues(category).Add(duration.Seconds())
+}
+
+// QueueDepthChanged tracks the envelopes in the transport buffer.
+func (SentryTransport) QueueDepthChanged(transport string, delta int) {
+	QueueDepth.WithLabelValues(transport).Add(float64(delta))
+}
+
+// QueueBytes tracks the size of the persistent queue.
+func (SentryTransport) QueueBytes(transport string, bytes int64) {
+	QueueBytes.WithLabelValues(transport).Set(float64(bytes))
+}
+
+// QueueBytesDropped accounts for the bytes dropped from the persistent queue.
+func (SentryTransport) QueueBytesDropped(transport string, reason string, bytes int64) {
+	QueueDroppedBytes.WithLabelValues(transport, reason).Add(float64(bytes))
+	Drops.Record("transport", "queue_"+reason, 1)
+}
@@ -0,0 +1,210 @@
+// Package telemetry holds the Prometheus metrics that promsentry reports about
+// itself. They are registered on Registry, which is exposed by Handler.
+package telemetry
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "promsentry"
+
+// Registry is the registry every promsentry metric is registered on, along
+// with the Go runtime and process collectors.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Remote write handler metrics.
+var (
+	WriteRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "write_requests_total",
+		Help:      "Remote write requests handled, by HTTP status code.",
+	}, []string{"code"})
+	WriteRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "write_request_duration_seconds",
+		Help:      "Time spent handling remote write requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code"})
+	WriteRequestsRejected = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "write_requests_rejected_total",
+		Help:      "Remote write requests rejected because they exceeded a limit, by reason.",
+	}, []string{"reason"})
+	SeriesReceived = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "series_received_total",
+		Help:      "Time series received through remote write.",
+	})
+	SamplesReceived = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "samples_received_total",
+		Help:      "Samples received through remote write, by type (sample, exemplar or histogram).",
+	}, []string{"type"})
+	SeriesConverted = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "series_converted_total",
+		Help:      "Time series converted into at least one statsd line.",
+	})
+)
+
+// statsd client metrics.
+var (
+	LinesEmitted = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "statsd_lines_emitted_total",
+		Help:      "Statsd lines written by the statsd client.",
+	})
+	LinesDropped = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "statsd_lines_dropped_total",
+		Help:      "Statsd lines that were not emitted, by reason.",
+	}, []string{"reason"})
+)
+
+// Sink metrics.
+var (
+	SinkWrites = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_writes_total",
+		Help:      "Statsd payloads written to a sink, by sink and result (success or failure).",
+	}, []string{"sink", "result"})
+)
+
+// Authentication metrics.
+var (
+	AuthFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Remote write requests rejected by the authentication, by reason (missing_credentials, invalid_credentials or forbidden).",
+	}, []string{"reason"})
+)
+
+// Remote write forwarding metrics.
+var (
+	ForwardRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forward_requests_total",
+		Help:      "Remote write requests forwarded to another backend, by target and result (success, failure or dropped).",
+	}, []string{"target", "result"})
+	ForwardRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forward_retries_total",
+		Help:      "Remote write requests sent again to another backend after a failure, by target.",
+	}, []string{"target"})
+	ForwardQueueLength = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forward_queue_length",
+		Help:      "Remote write requests waiting to be forwarded, by target.",
+	}, []string{"target"})
+)
+
+// Scrape metrics.
+var (
+	Scrapes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scrapes_total",
+		Help:      "Scrapes of the targets, by job and result (success or failure).",
+	}, []string{"job", "result"})
+	ScrapeDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scrape_duration_seconds",
+		Help:      "Time spent scraping a target, by job.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"job"})
+	ScrapeTargets = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scrape_targets",
+		Help:      "Targets being scraped, by job.",
+	}, []string{"job"})
+)
+
+// Sentry transport metrics.
+var (
+	EnvelopesSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "envelopes_sent_total",
+		Help:      "Envelopes sent to Sentry, by transport.",
+	}, []string{"transport"})
+	EnvelopesDropped = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "envelopes_dropped_total",
+		Help:      "Envelopes that were never delivered to Sentry, by transport and reason.",
+	}, []string{"transport", "reason"})
+	BytesSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sent_bytes_total",
+		Help:      "Request body bytes sent to Sentry, after compression, by transport.",
+	}, []string{"transport"})
+	SentryResponses = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sentry_responses_total",
+		Help:      "Responses received from Sentry, by transport and HTTP status code.",
+	}, []string{"transport", "code"})
+	SendErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_errors_total",
+		Help:      "Requests to Sentry that failed without a response, by transport.",
+	}, []string{"transport"})
+	QueueDepth = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "transport_queue_depth",
+		Help:      "Envelopes waiting in the transport buffer, by transport.",
+	}, []string{"transport"})
+	QueueBytes = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "transport_queue_bytes",
+		Help:      "Bytes waiting in the persistent transport queue, by transport.",
+	}, []string{"transport"})
+	QueueDroppedBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transport_queue_dropped_bytes_total",
+		Help:      "Bytes dropped from the persistent transport queue before being sent, by transport and reason.",
+	}, []string{"transport", "reason"})
+	RateLimitedSeconds = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_seconds_total",
+		Help:      "Time Sentry asked us to back off for, by rate limit category.",
+	}, []string{"category"})
+)
+
+// Configuration reload metrics.
+var (
+	ConfigReloads = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads, by result (success or failure).",
+	}, []string{"result"})
+	ConfigLastReloadSuccessful = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload succeeded.",
+	})
+)
+
+// Handler returns an http.Handler that exposes the metrics of Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Logging metrics.
+var (
+	LogRecordsSampled = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_records_sampled_total",
+		Help:      "Repetitive log records that were dropped by sampling, by level.",
+	}, []string{"level"})
+)
@@ -1,17 +1,28 @@
 package promsentry
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 // CreateTLSConfiguration process the paths to certificate and the client authentication type based on the
 // values on the function parameter, then create a *tls.Config struct that can be passed to multiple stuff.
 // Some of them being the HTTP server or gRPC server.
 //
-// Please do not insert empty string values for each function parameter. You will get an error instead.
+// The client CA root path is optional when client certificates are not verified, every other parameter is required.
+// The minimum TLS version is 1.2.
 func CreateTLSConfiguration(serverCertPath string, serverKeyPath string, clientCARootPath string, clientAuthenticationType string) (*tls.Config, error) {
 	var clientAuthType tls.ClientAuthType
 	switch clientAuthenticationType {
@@ -39,20 +50,24 @@ func CreateTLSConfiguration(serverCertPath string, serverKeyPath string, clientC
 		return &tls.Config{}, fmt.Errorf("reading server key: %w", err)
 	}
 
-	clientCARoot, err := os.ReadFile(clientCARootPath)
-	if err != nil {
-		return &tls.Config{}, fmt.Errorf("reading client CA root: %w", err)
-	}
-
 	serverCertificatePair, err := tls.X509KeyPair(serverCert, serverKey)
 	if err != nil {
 		return &tls.Config{}, fmt.Errorf("converting x509 key pair: %w", err)
 	}
 
-	caCertificatePool := x509.NewCertPool()
+	var caCertificatePool *x509.CertPool
+	if clientCARootPath != "" {
+		clientCARoot, err := os.ReadFile(clientCARootPath)
+		if err != nil {
+			return &tls.Config{}, fmt.Errorf("reading client CA root: %w", err)
+		}
 
-	if ok := caCertificatePool.AppendCertsFromPEM(clientCARoot); !ok {
-		return &tls.Config{}, fmt.Errorf("invalid ca certificate")
+		caCertificatePool = x509.NewCertPool()
+		if ok := caCertificatePool.AppendCertsFromPEM(clientCARoot); !ok {
+			return &tls.Config{}, fmt.Errorf("invalid ca certificate")
+		}
+	} else if clientAuthType == tls.VerifyClientCertIfGiven || clientAuthType == tls.RequireAndVerifyClientCert {
+		return &tls.Config{}, fmt.Errorf("a client CA root is required to verify client certificates with %s", clientAuthenticationType)
 	}
 
 	return &tls.Config{
@@ -61,6 +76,240 @@ func CreateTLSConfiguration(serverCertPath string, serverKeyPath string, clientC
 		ClientAuth:         clientAuthType,
 		ClientCAs:          caCertificatePool,
 		InsecureSkipVerify: false,
-		MinVersion:         tls.VersionTLS11,
+		MinVersion:         tls.VersionTLS12,
 	}, nil
 }
+
+// ParseTLSVersion parses a TLS version, like "1.2" or "1.3". An empty string selects TLS 1.2.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToUpper(s), "TLS") {
+	case "", "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q, use 1.0, 1.1, 1.2 or 1.3", s)
+	}
+}
+
+// ParseCipherSuites parses the names of cipher suites, as listed by tls.CipherSuites, like
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Insecure cipher suites are rejected. No names selects the default cipher
+// suites of Go.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// serverNextProtos are the application protocols offered by the server. The configuration returned by
+// GetConfigForClient replaces the one that http.Server sets up for HTTP/2, it has to offer them again.
+var serverNextProtos = []string{"h2", "http/1.1"}
+
+// TLSReloader holds the TLS configuration of the server, so that certificates can be replaced while the server is
+// running. Connections that are already established keep using the previous certificates.
+type TLSReloader struct {
+	current atomic.Pointer[tls.Config]
+	logger  *slog.Logger
+
+	mu            sync.Mutex
+	configuration *Configuration
+}
+
+// NewTLSReloader creates a TLSReloader from the TLS section of the configuration. The reloads of the certificates
+// are logged through the logger, slog.Default() when it is nil.
+func NewTLSReloader(configuration *Configuration, logger *slog.Logger) (*TLSReloader, error) {
+	tlsConfig, err := createTLSConfigurationFrom(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	reloader := &TLSReloader{logger: logger, configuration: configuration}
+	reloader.store(tlsConfig)
+	return reloader, nil
+}
+
+// TLSConfig returns a *tls.Config for the HTTP server that always uses the latest certificates.
+func (t *TLSReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.current.Load(), nil
+		},
+		// Only used to let the server know that certificates are provided.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &t.current.Load().Certificates[0], nil
+		},
+		NextProtos: serverNextProtos,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// Reload loads the certificates of the new configuration, which are used once it is applied. TLS can't be disabled
+// without restarting the server.
+func (t *TLSReloader) Reload(_ *Configuration, configuration *Configuration) (ReloadCommit, error) {
+	if configuration.TLS.ServerCertificatePath == "" {
+		return nil, fmt.Errorf("disabling TLS requires a restart")
+	}
+
+	tlsConfig, err := createTLSConfigurationFrom(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(apply bool) {
+		if !apply {
+			return
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.configuration = configuration
+		t.store(tlsConfig)
+	}, nil
+}
+
+// Watch loads the certificates, the key and the CA bundle again when one of the files changes, until the context is
+// done. Tools like cert-manager replace the files before the certificates expire. Files that fail to load are logged
+// and the previous certificates are kept.
+func (t *TLSReloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watching the TLS files: %w", err)
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	watched := make(map[string]bool)
+	watch := func() {
+		// The directories are watched rather than the files, the files are usually replaced instead of written to.
+		for _, filePath := range t.files() {
+			dir := filepath.Dir(filePath)
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				t.logger.Error("Unable to watch the TLS files", "directory", dir, "error", err)
+				continue
+			}
+			watched[dir] = true
+		}
+	}
+	lastModified := t.modificationTimes()
+
+	// Files are usually written in several steps, wait for them to settle.
+	debounce := time.NewTimer(0)
+	<-debounce.C
+
+	for {
+		// The paths might have changed with the configuration.
+		watch()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-watcher.Events:
+			debounce.Reset(time.Millisecond * 100)
+		case <-debounce.C:
+			modified := t.modificationTimes()
+			if reflect.DeepEqual(modified, lastModified) {
+				continue
+			}
+			lastModified = modified
+
+			if err := t.reloadFiles(); err != nil {
+				t.logger.Error("Unable to reload the TLS certificates", "error", err)
+				continue
+			}
+			t.logger.Info("TLS certificates reloaded")
+		case err := <-watcher.Errors:
+			t.logger.Error("Unable to watch the TLS files", "error", err)
+		}
+	}
+}
+
+// reloadFiles loads the files of the current configuration again.
+func (t *TLSReloader) reloadFiles() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tlsConfig, err := createTLSConfigurationFrom(t.configuration)
+	if err != nil {
+		return err
+	}
+
+	t.store(tlsConfig)
+	return nil
+}
+
+// store makes the TLS configuration the one used by the new connections.
+func (t *TLSReloader) store(tlsConfig *tls.Config) {
+	tlsConfig.NextProtos = serverNextProtos
+	t.current.Store(tlsConfig)
+}
+
+// files returns the paths of the certificate, the key and the CA bundle.
+func (t *TLSReloader) files() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	files := []string{t.configuration.TLS.ServerCertificatePath, t.configuration.TLS.ServerKeyPath}
+	if t.configuration.TLS.CertificateAuthorityPath != "" {
+		files = append(files, t.configuration.TLS.CertificateAuthorityPath)
+	}
+	return files
+}
+
+func (t *TLSReloader) modificationTimes() []time.Time {
+	var times []time.Time
+	for _, filePath := range t.files() {
+		times = append(times, modificationTime(filePath))
+	}
+	return times
+}
+
+func createTLSConfigurationFrom(configuration *Configuration) (*tls.Config, error) {
+	tlsConfig, err := CreateTLSConfiguration(
+		configuration.TLS.ServerCertificatePath,
+		configuration.TLS.ServerKeyPath,
+		configuration.TLS.CertificateAuthorityPath,
+		configuration.TLS.ClientAuthenticationType)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.MinVersion, err = ParseTLSVersion(configuration.TLS.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.CipherSuites, err = ParseCipherSuites(configuration.TLS.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	return tlsConfig, nil
+}
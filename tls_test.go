@@ -0,0 +1,193 @@
+package promsentry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key to dir, and returns their paths.
+func writeCertificate(t *testing.T, dir string, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificatePath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certificatePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certificatePath, keyPath
+}
+
+func TestCreateTLSConfigurationWithoutCA(t *testing.T) {
+	certificatePath, keyPath := writeCertificate(t, t.TempDir(), "promsentry")
+
+	tlsConfig, err := CreateTLSConfiguration(certificatePath, keyPath, "", "NoClientCert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 as the minimum version, got %x", tlsConfig.MinVersion)
+	}
+
+	_, err = CreateTLSConfiguration(certificatePath, keyPath, "", "RequireAndVerifyClientCert")
+	if err == nil || !strings.Contains(err.Error(), "client CA root is required") {
+		t.Errorf("expected a missing CA error, got %v", err)
+	}
+}
+
+func TestCreateTLSConfigurationVersionAndCipherSuites(t *testing.T) {
+	certificatePath, keyPath := writeCertificate(t, t.TempDir(), "promsentry")
+
+	configuration := &Configuration{}
+	configuration.TLS.ServerCertificatePath = certificatePath
+	configuration.TLS.ServerKeyPath = keyPath
+	configuration.TLS.MinVersion = "1.3"
+	configuration.TLS.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+
+	tlsConfig, err := createTLSConfigurationFrom(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("expected TLS 1.3 as the minimum version, got %x", tlsConfig.MinVersion)
+	}
+	if len(tlsConfig.CipherSuites) != 1 || tlsConfig.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suites %v", tlsConfig.CipherSuites)
+	}
+
+	configuration.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	if _, err := createTLSConfigurationFrom(configuration); err == nil {
+		t.Error("expected an insecure cipher suite to be rejected")
+	}
+
+	configuration.TLS.CipherSuites = nil
+	configuration.TLS.MinVersion = "1.4"
+	if _, err := createTLSConfigurationFrom(configuration); err == nil {
+		t.Error("expected an unknown TLS version to be rejected")
+	}
+}
+
+func TestTLSReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certificatePath, keyPath := writeCertificate(t, dir, "before")
+
+	configuration := &Configuration{}
+	configuration.TLS.ServerCertificatePath = certificatePath
+	configuration.TLS.ServerKeyPath = keyPath
+
+	reloader, err := NewTLSReloader(configuration, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := reloader.Watch(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	commonName := func() string {
+		certificate, err := reloader.TLSConfig().GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+
+	// Let the watcher start, and make sure the modification time changes.
+	time.Sleep(time.Millisecond * 100)
+	writeCertificate(t, dir, "after")
+	future := time.Now().Add(time.Second)
+	_ = os.Chtimes(certificatePath, future, future)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for commonName() != "after" {
+		if time.Now().After(deadline) {
+			t.Fatal("the certificate was not reloaded")
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+func TestTLSReloaderHTTP2(t *testing.T) {
+	certificatePath, keyPath := writeCertificate(t, t.TempDir(), "localhost")
+
+	configuration := &Configuration{}
+	configuration.TLS.ServerCertificatePath = certificatePath
+	configuration.TLS.ServerKeyPath = keyPath
+
+	reloader, err := NewTLSReloader(configuration, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: reloader.TLSConfig(),
+	}
+	go func() {
+		_ = server.ServeTLS(listener, "", "")
+	}()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	response, err := client.Get("https://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	if response.TLS.NegotiatedProtocol != "h2" || response.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s negotiated as %q", response.Proto, response.TLS.NegotiatedProtocol)
+	}
+}
@@ -0,0 +1,233 @@
+package promsentry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry"
+	"github.com/aldy505/promsentry/sentry/wal"
+	"github.com/aldy505/promsentry/telemetry"
+)
+
+// NewSentryClient creates a Sentry client, and its transport, from the configuration.
+func NewSentryClient(configuration *Configuration) (*sentry.Client, error) {
+	return newSentryClient(configuration, nil)
+}
+
+// newSentryClient is NewSentryClient, with the transport of the client that is replaced, if any.
+func newSentryClient(configuration *Configuration, previous sentry.Transport) (*sentry.Client, error) {
+	transport, err := createSentryTransport(configuration, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := SentryClientOptions(configuration)
+	if err != nil {
+		closeTransport(transport)
+		return nil, err
+	}
+	options.Transport = transport
+
+	client, err := sentry.NewClient(options)
+	if err != nil {
+		closeTransport(transport)
+		return nil, err
+	}
+	return client, nil
+}
+
+// closeTransport releases the resources of a transport that is not used anymore, like its compression encoder or its
+// persistent queue.
+func closeTransport(transport sentry.Transport) {
+	if closer, ok := transport.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// SentryClientOptions returns the options of the Sentry client described by the configuration, including the TLS
+// and proxy settings of the outbound connections, without a transport.
+func SentryClientOptions(configuration *Configuration) (sentry.ClientOptions, error) {
+	options := sentry.ClientOptions{
+		Dsn:         configuration.SentryDsn,
+		Debug:       configuration.Debug,
+		SampleRate:  1.0,
+		ServerName:  "promsentry",
+		Environment: configuration.Environment,
+		Release:     configuration.Release,
+		Logger:      slog.Default().With("component", "transport"),
+
+		MaxMetricItemBytes: configuration.Transport.MaxItemBytes,
+		MaxMetricItemLines: configuration.Transport.MaxItemLines,
+		SendClientReports:  !configuration.Transport.DisableClientReports,
+		TransportMetrics:   telemetry.SentryTransport{},
+	}
+
+	if err := configureOutboundTLS(configuration, &options); err != nil {
+		return sentry.ClientOptions{}, fmt.Errorf("invalid transport tls configuration: %w", err)
+	}
+
+	if proxyURL := configuration.Transport.ProxyURL; proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return sentry.ClientOptions{}, fmt.Errorf("invalid transport proxy_url: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			return sentry.ClientOptions{}, fmt.Errorf("invalid transport proxy_url: unsupported scheme %q, use http, https or socks5", u.Scheme)
+		}
+		options.HTTPProxy = proxyURL
+		options.HTTPSProxy = proxyURL
+	}
+
+	return options, nil
+}
+
+// configureOutboundTLS sets the CA bundle, the client certificate and the minimum TLS version used to reach Sentry.
+// The CA bundle is added to the system roots, so that an internal CA can sign the certificate of a proxy or of a
+// self-hosted Sentry without losing the public ones.
+func configureOutboundTLS(configuration *Configuration, options *sentry.ClientOptions) error {
+	outbound := configuration.Transport.TLS
+
+	minVersion, err := ParseTLSVersion(outbound.MinVersion)
+	if err != nil {
+		return err
+	}
+	options.TLSMinVersion = minVersion
+
+	if outbound.CertificateAuthorityPath != "" {
+		caCertificates, err := os.ReadFile(outbound.CertificateAuthorityPath)
+		if err != nil {
+			return fmt.Errorf("reading CA certificate: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if ok := pool.AppendCertsFromPEM(caCertificates); !ok {
+			return fmt.Errorf("invalid ca certificate")
+		}
+		options.CaCerts = pool
+	}
+
+	if (outbound.ClientCertificatePath == "") != (outbound.ClientKeyPath == "") {
+		return fmt.Errorf("client_certificate_path and client_key_path must be set together")
+	}
+	if outbound.ClientCertificatePath != "" {
+		certificate, err := tls.LoadX509KeyPair(outbound.ClientCertificatePath, outbound.ClientKeyPath)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		options.ClientCertificates = []tls.Certificate{certificate}
+	}
+
+	return nil
+}
+
+// CreateSentryTransport creates the sentry.Transport described by the transport section of the configuration.
+// In dry run, it is a sentry.DryRunTransport that never sends anything. Otherwise, it returns nil when no DSN is
+// configured, letting the SDK pick its no-op transport.
+func CreateSentryTransport(configuration *Configuration) (sentry.Transport, error) {
+	return createSentryTransport(configuration, nil)
+}
+
+// createSentryTransport is CreateSentryTransport, with the transport that is replaced, if any. A persistent queue
+// stored in the same directory as the one of the previous transport is taken over rather than opened, see
+// sentry.DiskTransport.TakeOver.
+func createSentryTransport(configuration *Configuration, previous sentry.Transport) (sentry.Transport, error) {
+	if configuration.SentryDsn == "" && !configuration.DryRun {
+		return nil, nil
+	}
+
+	compression, err := sentry.ParseCompression(configuration.Transport.Compression)
+	if err == nil {
+		err = sentry.ValidateCompression(compression, configuration.Transport.CompressionLevel)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid transport configuration: %w", err)
+	}
+
+	if configuration.DryRun {
+		transport := sentry.NewDryRunTransport()
+		transport.Compression = compression
+		transport.CompressionLevel = configuration.Transport.CompressionLevel
+		return transport, nil
+	}
+
+	queue := configuration.Transport.Queue
+	if queue.Directory == "" {
+		transport := sentry.NewHTTPTransport()
+		transport.RetryPolicy = createRetryPolicy(configuration)
+		transport.Compression = compression
+		transport.CompressionLevel = configuration.Transport.CompressionLevel
+		if configuration.Transport.Workers > 0 {
+			transport.Workers = configuration.Transport.Workers
+		}
+		if configuration.Transport.BufferSize > 0 {
+			transport.BufferSize = configuration.Transport.BufferSize
+		}
+		if configuration.Transport.Timeout > 0 {
+			transport.Timeout = time.Duration(configuration.Transport.Timeout)
+		}
+		return transport, nil
+	}
+
+	fsync, err := wal.ParseFsyncPolicy(queue.Fsync)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transport queue configuration: %w", err)
+	}
+
+	transport := sentry.NewDiskTransport(queue.Directory)
+	transport.MaxSegmentSize = queue.MaxSegmentSize
+	transport.MaxSize = queue.MaxSize
+	transport.MaxAge = time.Duration(queue.MaxAge)
+	transport.Fsync = fsync
+	transport.FsyncInterval = time.Duration(queue.FsyncInterval)
+	transport.RetryPolicy = createRetryPolicy(configuration)
+	transport.Compression = compression
+	transport.CompressionLevel = configuration.Transport.CompressionLevel
+	if configuration.Transport.Timeout > 0 {
+		transport.Timeout = time.Duration(configuration.Transport.Timeout)
+	}
+
+	if disk, ok := previous.(*sentry.DiskTransport); ok && disk.Directory == queue.Directory {
+		transport.Previous = disk
+		return transport, nil
+	}
+
+	// Opened here rather than by the client, so that a queue that can't be opened fails the startup.
+	if err := transport.Open(); err != nil {
+		return nil, fmt.Errorf("invalid transport queue configuration: %w", err)
+	}
+
+	return transport, nil
+}
+
+// createRetryPolicy overrides the default retry policy with the values that are set in the configuration.
+func createRetryPolicy(configuration *Configuration) sentry.RetryPolicy {
+	retry := configuration.Transport.Retry
+	policy := sentry.DefaultRetryPolicy()
+
+	if retry.MaxAttempts != 0 {
+		policy.MaxAttempts = retry.MaxAttempts
+	}
+
+	if retry.InitialBackoff != 0 {
+		policy.InitialBackoff = time.Duration(retry.InitialBackoff)
+	}
+
+	if retry.MaxBackoff != 0 {
+		policy.MaxBackoff = time.Duration(retry.MaxBackoff)
+	}
+
+	if retry.Jitter != 0 {
+		policy.Jitter = retry.Jitter
+	}
+
+	return policy
+}
@@ -0,0 +1,180 @@
+package promsentry
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aldy505/promsentry/sentry"
+)
+
+func TestSentryClientOptionsOutbound(t *testing.T) {
+	dir := t.TempDir()
+	certificatePath, keyPath := writeCertificate(t, dir, "promsentry")
+
+	configuration := &Configuration{}
+	configuration.Transport.TLS.CertificateAuthorityPath = certificatePath
+	configuration.Transport.TLS.ClientCertificatePath = certificatePath
+	configuration.Transport.TLS.ClientKeyPath = keyPath
+	configuration.Transport.TLS.MinVersion = "1.3"
+	configuration.Transport.ProxyURL = "http://proxy.internal:3128"
+
+	options, err := SentryClientOptions(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.CaCerts == nil {
+		t.Error("expected the CA certificate to be trusted")
+	}
+	if len(options.ClientCertificates) != 1 {
+		t.Errorf("expected 1 client certificate, got %d", len(options.ClientCertificates))
+	}
+	if options.TLSMinVersion != tls.VersionTLS13 {
+		t.Errorf("expected TLS 1.3 as the minimum version, got %x", options.TLSMinVersion)
+	}
+	if options.HTTPProxy != configuration.Transport.ProxyURL || options.HTTPSProxy != configuration.Transport.ProxyURL {
+		t.Errorf("expected the proxy to be used for http and https, got %q and %q", options.HTTPProxy, options.HTTPSProxy)
+	}
+}
+
+func TestSentryClientOptionsErrors(t *testing.T) {
+	certificatePath, _ := writeCertificate(t, t.TempDir(), "promsentry")
+
+	tests := []struct {
+		name      string
+		configure func(configuration *Configuration)
+		message   string
+	}{
+		{
+			name: "certificate without key",
+			configure: func(configuration *Configuration) {
+				configuration.Transport.TLS.ClientCertificatePath = certificatePath
+			},
+			message: "must be set together",
+		},
+		{
+			name: "missing CA",
+			configure: func(configuration *Configuration) {
+				configuration.Transport.TLS.CertificateAuthorityPath = "/does/not/exist.crt"
+			},
+			message: "reading CA certificate",
+		},
+		{
+			name: "unknown TLS version",
+			configure: func(configuration *Configuration) {
+				configuration.Transport.TLS.MinVersion = "2.0"
+			},
+			message: "unknown TLS version",
+		},
+		{
+			name: "proxy scheme",
+			configure: func(configuration *Configuration) {
+				configuration.Transport.ProxyURL = "ftp://proxy.internal"
+			},
+			message: "unsupported scheme",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := &Configuration{}
+			test.configure(configuration)
+
+			_, err := SentryClientOptions(configuration)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
+
+func TestCreateSentryTransportTimeoutAndBufferSize(t *testing.T) {
+	configuration := &Configuration{SentryDsn: "https://public@example.com/1"}
+	configuration.Transport.Timeout = Duration(time.Second * 5)
+	configuration.Transport.BufferSize = 200
+
+	transport, err := CreateSentryTransport(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpTransport, ok := transport.(*sentry.HTTPTransport)
+	if !ok {
+		t.Fatalf("expected an HTTP transport, got %T", transport)
+	}
+	if httpTransport.Timeout != time.Second*5 {
+		t.Errorf("expected a timeout of 5s, got %s", httpTransport.Timeout)
+	}
+	if httpTransport.BufferSize != 200 {
+		t.Errorf("expected a buffer size of 200, got %d", httpTransport.BufferSize)
+	}
+}
+
+func TestCreateSentryTransportDryRun(t *testing.T) {
+	configuration := &Configuration{DryRun: true}
+	configuration.Transport.Compression = "gzip"
+
+	transport, err := CreateSentryTransport(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dryRun, ok := transport.(*sentry.DryRunTransport)
+	if !ok {
+		t.Fatalf("expected a dry run transport without a DSN, got %T", transport)
+	}
+	if dryRun.Compression != sentry.CompressionGzip {
+		t.Errorf("expected gzip compression, got %q", dryRun.Compression)
+	}
+
+	// The Sentry sink is used even when other sinks are configured.
+	configuration.Sinks.Stdout.Enabled = true
+	sink, err := CreateSink(configuration, sentry.NewHub(nil, sentry.NewScope()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if sink.Len() != 2 {
+		t.Errorf("expected the stdout and Sentry sinks, got %d sinks", sink.Len())
+	}
+}
+
+func TestCreateSentryTransportQueueError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	configuration := &Configuration{SentryDsn: "https://public@example.com/1"}
+	configuration.Transport.Queue.Directory = filepath.Join(file, "queue")
+	if _, err := CreateSentryTransport(configuration); err == nil || !strings.Contains(err.Error(), "disk queue") {
+		t.Errorf("expected the disk queue to fail to open, got %v", err)
+	}
+
+	configuration.Transport.Queue.Directory = t.TempDir()
+	configuration.Transport.Retry.MaxAttempts = 5
+	transport, err := CreateSentryTransport(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.(*sentry.DiskTransport).Close()
+	if policy := transport.(*sentry.DiskTransport).RetryPolicy; policy.MaxAttempts != 5 {
+		t.Errorf("expected the retry policy of the configuration, got %+v", policy)
+	}
+}
+
+func TestCreateSentryTransportCompressionLevel(t *testing.T) {
+	configuration := &Configuration{SentryDsn: "https://public@example.com/1"}
+	configuration.Transport.Compression = "gzip"
+	configuration.Transport.CompressionLevel = 42
+
+	if _, err := CreateSentryTransport(configuration); err == nil || !strings.Contains(err.Error(), "compression level") {
+		t.Errorf("expected an invalid compression level error, got %v", err)
+	}
+	if err := configuration.Validate(); err == nil || !strings.Contains(err.Error(), "compression level") {
+		t.Errorf("expected the validation to fail, got %v", err)
+	}
+}